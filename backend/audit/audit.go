@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"context"
+	"time"
+)
+
+// Outcomes of an audited operation
+const (
+	OutcomeSuccess  = "success"
+	OutcomeError    = "error"
+	OutcomeCanceled = "canceled"
+	OutcomeRejected = "rejected"
+)
+
+// Record is one audited analyze or download
+type Record struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	RequestID string    `json:"requestId,omitempty"`
+	// Identity is the authenticated user ID, or the client IP for anonymous requests
+	Identity string `json:"identity"`
+	User     string `json:"user,omitempty"`
+	ClientIP string `json:"clientIp"`
+	URL      string `json:"url"`
+	Platform string `json:"platform,omitempty"`
+	VideoID  string `json:"videoId,omitempty"`
+	Format   string `json:"format,omitempty"`
+	Bytes    int64  `json:"bytes,omitempty"`
+	Outcome  string `json:"outcome"`
+	// Cause is the classified error cause when the outcome is not a success
+	Cause      string `json:"cause,omitempty"`
+	DurationMS int64  `json:"durationMs"`
+}
+
+// Sink receives audit records. Implementations must be safe for concurrent use.
+type Sink interface {
+	Write(record Record) error
+	Close() error
+}
+
+// Filter selects records; zero fields match everything
+type Filter struct {
+	User     string
+	Platform string
+	From     time.Time
+	To       time.Time
+	// Limit caps the number of records returned, newest first
+	Limit int
+}
+
+// Match reports whether record passes the filter
+func (f Filter) Match(record Record) bool {
+	if f.User != "" && record.Identity != f.User && record.User != f.User {
+		return false
+	}
+	if f.Platform != "" && record.Platform != f.Platform {
+		return false
+	}
+	if !f.From.IsZero() && record.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !record.Time.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// Querier is implemented by sinks that can read back what they wrote
+type Querier interface {
+	Query(ctx context.Context, filter Filter) ([]Record, error)
+}
+
+// Nop discards all records, used when auditing is disabled
+type Nop struct{}
+
+func (Nop) Write(Record) error { return nil }
+func (Nop) Close() error       { return nil }
@@ -0,0 +1,190 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	currentFile   = "audit.jsonl"
+	rotatedPrefix = "audit-"
+	rotatedSuffix = ".jsonl"
+)
+
+// FileSink appends records as JSON lines to dir/audit.jsonl.
+// When the file exceeds maxBytes it is renamed to audit-<timestamp>.jsonl
+// and a new file is started; only the newest maxFiles rotated files are kept.
+type FileSink struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// NewFileSink opens (or creates) the audit log in dir
+func NewFileSink(dir string, maxBytes int64, maxFiles int) (*FileSink, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %w", err)
+	}
+
+	s := &FileSink{dir: dir, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(filepath.Join(s.dir, currentFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+func (s *FileSink) Write(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return fmt.Errorf("audit log is closed")
+	}
+	if s.maxBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log: %w", err)
+	}
+
+	rotated := filepath.Join(s.dir, rotatedPrefix+time.Now().UTC().Format("20060102T150405.000000000")+rotatedSuffix)
+	if err := os.Rename(filepath.Join(s.dir, currentFile), rotated); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+
+	if s.maxFiles > 0 {
+		files, err := s.rotatedFiles()
+		if err == nil && len(files) > s.maxFiles {
+			for _, old := range files[:len(files)-s.maxFiles] {
+				os.Remove(old)
+			}
+		}
+	}
+
+	return s.open()
+}
+
+// rotatedFiles lists rotated files oldest first (timestamps sort lexically)
+func (s *FileSink) rotatedFiles() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, rotatedPrefix+"*"+rotatedSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Query scans rotated and current files and returns matching records, newest first
+func (s *FileSink) Query(ctx context.Context, filter Filter) ([]Record, error) {
+	s.mu.Lock()
+	files, err := s.rotatedFiles()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit logs: %w", err)
+	}
+	files = append(files, filepath.Join(s.dir, currentFile))
+
+	var records []Record
+	for _, path := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := scanFile(path, filter, &records); err != nil {
+			return nil, err
+		}
+	}
+
+	slices.Reverse(records)
+	if filter.Limit > 0 && len(records) > filter.Limit {
+		records = records[:filter.Limit]
+	}
+	return records, nil
+}
+
+func scanFile(path string, filter Filter, records *[]Record) error {
+	file, err := os.Open(path)
+	if err != nil {
+		// A file may be removed by rotation between listing and opening it
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			// Skip a partially written trailing line
+			continue
+		}
+		if filter.Match(record) {
+			*records = append(*records, record)
+		}
+	}
+	return scanner.Err()
+}
+
+// Close flushes and closes the current file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
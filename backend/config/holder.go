@@ -0,0 +1,26 @@
+package config
+
+import "sync/atomic"
+
+// Holder gives concurrent readers the current configuration
+// while a reload swaps it underneath them
+type Holder struct {
+	current atomic.Pointer[Config]
+}
+
+// NewHolder creates a holder with an initial configuration
+func NewHolder(cfg *Config) *Holder {
+	h := &Holder{}
+	h.current.Store(cfg)
+	return h
+}
+
+// Get returns the current configuration; callers must not modify it
+func (h *Holder) Get() *Config {
+	return h.current.Load()
+}
+
+// Set replaces the current configuration
+func (h *Holder) Set(cfg *Config) {
+	h.current.Store(cfg)
+}
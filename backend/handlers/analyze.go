@@ -2,21 +2,30 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+
+	"viddown/audit"
+	"viddown/middleware"
 	"viddown/services"
 )
 
 type AnalyzeHandler struct {
-	ytdlp  *services.YtDlpService
-	logger *slog.Logger
+	extractor services.Extractor
+	platforms *services.PlatformRegistry
+	audit     audit.Sink
+	logger    *slog.Logger
 }
 
-func NewAnalyzeHandler(ytdlp *services.YtDlpService, logger *slog.Logger) *AnalyzeHandler {
+func NewAnalyzeHandler(extractor services.Extractor, platforms *services.PlatformRegistry, auditSink audit.Sink, logger *slog.Logger) *AnalyzeHandler {
 	return &AnalyzeHandler{
-		ytdlp:  ytdlp,
-		logger: logger,
+		extractor: extractor,
+		platforms: platforms,
+		audit:     auditSink,
+		logger:    logger,
 	}
 }
 
@@ -25,11 +34,21 @@ type AnalyzeRequest struct {
 }
 
 type AnalyzeResponse struct {
-	Platform  string            `json:"platform"`
-	Title     string            `json:"title"`
-	Duration  int               `json:"duration"`
-	Thumbnail string            `json:"thumbnail"`
-	Formats   []services.Format `json:"formats"`
+	ID          string            `json:"id,omitempty"`
+	URL         string            `json:"url"`
+	Platform    string            `json:"platform"`
+	Title       string            `json:"title"`
+	Duration    int               `json:"duration"`
+	Thumbnail   string            `json:"thumbnail"`
+	Author      string            `json:"author,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Formats     []services.Format `json:"formats"`
+	// Items are the photos and videos of a carousel post or a tweet
+	Items []services.MediaItem `json:"items,omitempty"`
+	// Chapters are the sections of a long video, each downloadable alone
+	Chapters []services.Chapter `json:"chapters,omitempty"`
+	// Music is the metadata of a YouTube Music track or album
+	Music *services.MusicInfo `json:"music,omitempty"`
 }
 
 type ErrorResponse struct {
@@ -45,11 +64,11 @@ func (h *AnalyzeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req AnalyzeRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.logger.Error("Failed to decode request", "error", err)
+	if status, message := decodeJSON(r, &req); message != "" {
+		h.logger.WarnContext(r.Context(), "Failed to decode request", "error", message)
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: message})
 		return
 	}
 
@@ -60,21 +79,61 @@ func (h *AnalyzeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.logger.Info("Analyzing URL", "url", req.URL)
+	// Links to one video in different forms are logged and audited alike
+	videoURL := req.URL
+	if canonical, err := h.extractor.Canonicalize(req.URL); err == nil {
+		videoURL = canonical.URL
+	}
+
+	h.logger.InfoContext(r.Context(), "Analyzing URL", "url", videoURL, "client_ip", middleware.ClientIP(r))
+
+	record := newAuditRecord(r, "analyze", videoURL)
+	defer writeAudit(r.Context(), h.logger, h.audit, record)
 
-	info, err := h.ytdlp.Analyze(r.Context(), req.URL)
+	info, err := h.extractor.Analyze(r.Context(), videoURL)
 	if err != nil {
-		h.logger.Error("Failed to analyze URL", "url", req.URL, "error", err)
+		platform, _ := h.extractor.Identify(videoURL)
+		record.Platform = string(platform)
+		record.Cause = services.ClassifyError(err)
+		if r.Context().Err() != nil {
+			record.Outcome = audit.OutcomeCanceled
+		}
+
+		h.logger.ErrorContext(r.Context(), "Failed to analyze URL", "url", videoURL, "error", err, "cause", services.ClassifyError(err), "request_id", chimiddleware.GetReqID(r.Context()))
 		
 		w.Header().Set("Content-Type", "application/json")
 		
-		switch err {
-		case services.ErrInvalidURL:
+		switch {
+		case errors.Is(err, services.ErrURLTooLong):
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "URL is too long"})
+		case errors.Is(err, services.ErrInvalidURL):
 			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid URL format"})
-		case services.ErrUnsupportedURL:
+		case errors.Is(err, services.ErrUnsupportedURL):
 			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(ErrorResponse{Error: "Unsupported platform. Supported: YouTube, Instagram, TikTok"})
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "Unsupported platform. Supported: " + h.platforms.Names()})
+		case errors.Is(err, services.ErrInsecureScheme), errors.Is(err, services.ErrHostNotAllowed), errors.Is(err, services.ErrBlockedAddress):
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "This link is not allowed"})
+		case errors.Is(err, services.ErrLiveManifest):
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "Live streams are not supported"})
+		case errors.Is(err, services.ErrUnsupportedManifest):
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "This stream format is not supported"})
+		case errors.Is(err, services.ErrNotMedia), errors.Is(err, services.ErrNotManifest):
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "The link does not point to a video or audio file"})
+		case errors.Is(err, services.ErrResponseTooBig):
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "The file is too large"})
+		case errors.Is(err, services.ErrMediaUnavailable):
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "The file is not available"})
+		case services.ClassifyError(err) == "private":
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "This content is private or requires login"})
 		default:
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to analyze video. Please check the URL and try again."})
@@ -83,20 +142,31 @@ func (h *AnalyzeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get simplified formats
-	simplifiedFormats := h.ytdlp.GetBestFormats(info.Formats)
+	simplifiedFormats := h.extractor.BestFormats(info)
 	if len(simplifiedFormats) == 0 {
 		simplifiedFormats = info.Formats
 	}
 
+	record.Platform = string(info.Platform)
+	record.VideoID = info.ID
+	record.Outcome = audit.OutcomeSuccess
+
 	response := AnalyzeResponse{
-		Platform:  string(info.Platform),
-		Title:     info.Title,
-		Duration:  info.Duration,
-		Thumbnail: info.Thumbnail,
-		Formats:   simplifiedFormats,
+		ID:          info.ID,
+		URL:         videoURL,
+		Platform:    string(info.Platform),
+		Title:       info.Title,
+		Duration:    info.Duration,
+		Thumbnail:   info.Thumbnail,
+		Author:      info.Author,
+		Description: info.Description,
+		Formats:     simplifiedFormats,
+		Items:       info.Items,
+		Chapters:    info.Chapters,
+		Music:       info.Music,
 	}
 
-	h.logger.Info("Analysis complete", "url", req.URL, "title", info.Title, "formats", len(response.Formats))
+	h.logger.InfoContext(r.Context(), "Analysis complete", "url", videoURL, "title", info.Title, "formats", len(response.Formats))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"viddown/audit"
+	"viddown/services"
+)
+
+const (
+	testShareURL     = "https://youtu.be/dQw4w9WgXcQ?si=tracking"
+	testCanonicalURL = "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+)
+
+func newTestAnalyzeHandler(backend *stubBackend, sink audit.Sink) *AnalyzeHandler {
+	platforms := services.NewPlatformRegistry(services.DefaultPlatforms())
+	return NewAnalyzeHandler(backend, platforms, sink, discardLogger())
+}
+
+func postAnalyze(h http.Handler, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/api/analyze", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	return response
+}
+
+func TestAnalyzeHandler(t *testing.T) {
+	backend := &stubBackend{
+		canonical: map[string]string{testShareURL: testCanonicalURL},
+		info: &services.VideoInfo{
+			ID:       "dQw4w9WgXcQ",
+			Platform: services.PlatformYouTube,
+			Title:    "Never Gonna Give You Up",
+			Duration: 213,
+			Formats:  []services.Format{{ID: "137+140", Type: "video", Quality: "1080p", Ext: "mp4"}},
+		},
+	}
+	sink := &memorySink{}
+
+	response := postAnalyze(newTestAnalyzeHandler(backend, sink), `{"url": "`+testShareURL+`"}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", response.Code, response.Body)
+	}
+
+	var body AnalyzeResponse
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.URL != testCanonicalURL || body.Title != "Never Gonna Give You Up" || len(body.Formats) != 1 {
+		t.Errorf("response = %+v", body)
+	}
+	if len(backend.analyzed) != 1 || backend.analyzed[0] != testCanonicalURL {
+		t.Errorf("analyzed %v, want the canonical URL", backend.analyzed)
+	}
+
+	record := sink.last()
+	if record.Action != "analyze" || record.URL != testCanonicalURL || record.VideoID != "dQw4w9WgXcQ" || record.Outcome != audit.OutcomeSuccess {
+		t.Errorf("audit record = %+v", record)
+	}
+}
+
+func TestAnalyzeHandlerErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		err     error
+		status  int
+		message string
+	}{
+		{name: "missing url", body: `{}`, status: http.StatusBadRequest, message: "URL is required"},
+		{name: "malformed body", body: `{"url":`, status: http.StatusBadRequest},
+		{name: "unsupported", body: `{"url": "https://example.com/video"}`, err: services.ErrUnsupportedURL, status: http.StatusBadRequest, message: "Unsupported platform"},
+		{name: "unavailable", body: `{"url": "` + testShareURL + `"}`, err: fmt.Errorf("%w: removed", services.ErrMediaUnavailable), status: http.StatusNotFound},
+		{name: "live", body: `{"url": "` + testShareURL + `"}`, err: services.ErrLiveManifest, status: http.StatusUnprocessableEntity},
+		{name: "failure", body: `{"url": "` + testShareURL + `"}`, err: fmt.Errorf("yt-dlp exited"), status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &stubBackend{canonical: map[string]string{testShareURL: testCanonicalURL}, err: tt.err}
+			response := postAnalyze(newTestAnalyzeHandler(backend, &memorySink{}), tt.body)
+			if response.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", response.Code, tt.status, response.Body)
+			}
+			var body ErrorResponse
+			if err := json.NewDecoder(response.Body).Decode(&body); err != nil || body.Error == "" {
+				t.Fatalf("body is not an error response: %v", err)
+			}
+			if !strings.Contains(body.Error, tt.message) {
+				t.Errorf("error = %q, want it to contain %q", body.Error, tt.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+
+	"viddown/audit"
+	"viddown/middleware"
+)
+
+// newAuditRecord starts an audit record for the request.
+// The outcome defaults to an error until the handler records success.
+func newAuditRecord(r *http.Request, action, videoURL string) *audit.Record {
+	record := &audit.Record{
+		Time:      time.Now().UTC(),
+		Action:    action,
+		RequestID: chimiddleware.GetReqID(r.Context()),
+		Identity:  middleware.Identity(r),
+		ClientIP:  middleware.ClientIP(r),
+		URL:       videoURL,
+		Outcome:   audit.OutcomeError,
+	}
+	if user := middleware.UserFromContext(r.Context()); user != nil {
+		record.User = user.ID
+	}
+	return record
+}
+
+// writeAudit completes and writes a record. Audit failures are logged but
+// never fail the request that is being audited.
+func writeAudit(ctx context.Context, logger *slog.Logger, sink audit.Sink, record *audit.Record) {
+	record.DurationMS = time.Since(record.Time).Milliseconds()
+	if err := sink.Write(*record); err != nil {
+		logger.ErrorContext(ctx, "Failed to write audit record", "action", record.Action, "error", err)
+	}
+}
+
+type AuditHandler struct {
+	querier audit.Querier
+}
+
+func NewAuditHandler(querier audit.Querier) *AuditHandler {
+	return &AuditHandler{querier: querier}
+}
+
+type AuditResponse struct {
+	Records []audit.Record `json:"records"`
+}
+
+// ServeHTTP queries the audit log.
+// Filters: user (user ID or "ip:<addr>"), platform, from/to (RFC 3339), limit.
+func (h *AuditHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	filter := audit.Filter{
+		User:     query.Get("user"),
+		Platform: query.Get("platform"),
+		Limit:    100,
+	}
+
+	for _, param := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if value := query.Get(param.name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid " + param.name + ": expected RFC 3339 time, e.g. 2024-01-31T00:00:00Z"})
+				return
+			}
+			*param.dst = t
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > 10000 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid limit: expected 1-10000"})
+			return
+		}
+		filter.Limit = limit
+	}
+
+	records, err := h.querier.Query(r.Context(), filter)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to query audit log"})
+		return
+	}
+	if records == nil {
+		records = []audit.Record{}
+	}
+
+	json.NewEncoder(w).Encode(AuditResponse{Records: records})
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"viddown/audit"
+	"viddown/services"
+)
+
+// stubBackend is a services.Backend serving a fixed analysis and file,
+// recording what it was asked for
+type stubBackend struct {
+	// canonical maps URLs to their canonical form; unlisted URLs are invalid
+	canonical map[string]string
+	info      *services.VideoInfo
+	err       error
+	// content and filename are what DownloadToFile produces
+	content  string
+	filename string
+
+	mu       sync.Mutex
+	analyzed []string
+	// downloaded holds "url format" per DownloadToFile call
+	downloaded []string
+}
+
+func (b *stubBackend) Analyze(ctx context.Context, url string) (*services.VideoInfo, error) {
+	b.mu.Lock()
+	b.analyzed = append(b.analyzed, url)
+	b.mu.Unlock()
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.info, nil
+}
+
+func (b *stubBackend) BestFormats(info *services.VideoInfo) []services.Format {
+	return info.Formats
+}
+
+func (b *stubBackend) Identify(url string) (services.Platform, string) {
+	if b.info == nil {
+		return services.PlatformYouTube, ""
+	}
+	return b.info.Platform, b.info.ID
+}
+
+func (b *stubBackend) Canonicalize(url string) (*services.CanonicalURL, error) {
+	canonical, ok := b.canonical[url]
+	if !ok {
+		return nil, services.ErrInvalidURL
+	}
+	return &services.CanonicalURL{Platform: services.PlatformYouTube, URL: canonical}, nil
+}
+
+func (b *stubBackend) EstimateWork(url, formatID string, isAudioOnly bool) services.DownloadWork {
+	return services.DownloadWork{}
+}
+
+func (b *stubBackend) DownloadToFile(ctx context.Context, url, formatID, tempDir string, isAudioOnly bool) (string, string, error) {
+	b.mu.Lock()
+	b.downloaded = append(b.downloaded, url+" "+formatID)
+	b.mu.Unlock()
+	if b.err != nil {
+		return "", "", b.err
+	}
+	path := filepath.Join(tempDir, "download.tmp")
+	if err := os.WriteFile(path, []byte(b.content), 0o644); err != nil {
+		return "", "", err
+	}
+	return path, b.filename, nil
+}
+
+// memorySink keeps audit records for inspection
+type memorySink struct {
+	mu      sync.Mutex
+	records []audit.Record
+}
+
+func (s *memorySink) Write(record audit.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+func (s *memorySink) last() audit.Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.records) == 0 {
+		return audit.Record{}
+	}
+	return s.records[len(s.records)-1]
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
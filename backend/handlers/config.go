@@ -5,27 +5,39 @@ import (
 	"net/http"
 
 	"viddown/config"
+	"viddown/services"
 )
 
 type ConfigHandler struct {
-	cfg *config.Config
+	cfg       *config.Holder
+	platforms *services.PlatformRegistry
 }
 
-func NewConfigHandler(cfg *config.Config) *ConfigHandler {
-	return &ConfigHandler{cfg: cfg}
+func NewConfigHandler(cfg *config.Holder, platforms *services.PlatformRegistry) *ConfigHandler {
+	return &ConfigHandler{cfg: cfg, platforms: platforms}
 }
 
 type ConfigResponse struct {
 	AuthRequired  bool     `json:"authRequired"`
 	MaxConcurrent int      `json:"maxConcurrent"`
 	Platforms     []string `json:"platforms"`
+	// PlatformHosts are the domains of each enabled platform, for recognizing links
+	PlatformHosts map[string][]string `json:"platformHosts"`
 }
 
 func (h *ConfigHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg := h.cfg.Get()
 	response := ConfigResponse{
-		AuthRequired:  h.cfg.AuthRequired,
-		MaxConcurrent: h.cfg.MaxConcurrent,
-		Platforms:     []string{"youtube", "instagram", "tiktok"},
+		AuthRequired:  cfg.AuthRequired,
+		MaxConcurrent: cfg.MaxConcurrent,
+		Platforms:     []string{},
+		PlatformHosts: map[string][]string{},
+	}
+	for _, spec := range h.platforms.Enabled() {
+		response.Platforms = append(response.Platforms, string(spec.Platform))
+		if len(spec.Hosts) > 0 {
+			response.PlatformHosts[string(spec.Platform)] = spec.Hosts
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -9,23 +10,34 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/attribute"
+
+	"viddown/audit"
+	"viddown/middleware"
 	"viddown/services"
+	"viddown/tracing"
 )
 
 type DownloadHandler struct {
-	ytdlp     *services.YtDlpService
-	semaphore *services.Semaphore
-	logger    *slog.Logger
+	backend services.Backend
+	queue   *services.DownloadQueue
+	tempDir string
+	audit   audit.Sink
+	logger  *slog.Logger
 }
 
-func NewDownloadHandler(ytdlp *services.YtDlpService, semaphore *services.Semaphore, logger *slog.Logger) *DownloadHandler {
+func NewDownloadHandler(backend services.Backend, queue *services.DownloadQueue, tempDir string, auditSink audit.Sink, logger *slog.Logger) *DownloadHandler {
 	return &DownloadHandler{
-		ytdlp:     ytdlp,
-		semaphore: semaphore,
-		logger:    logger,
+		backend: backend,
+		queue:   queue,
+		tempDir: tempDir,
+		audit:   auditSink,
+		logger:  logger,
 	}
 }
 
@@ -47,7 +59,7 @@ func (h *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// URL decode
 	decodedURL, err := url.QueryUnescape(videoURL)
 	if err != nil {
-		h.logger.Error("Failed to decode URL", "error", err)
+		h.logger.ErrorContext(r.Context(), "Failed to decode URL", "error", err)
 		http.Error(w, `{"error": "Invalid URL encoding"}`, http.StatusBadRequest)
 		return
 	}
@@ -59,31 +71,96 @@ func (h *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Check if this is an audio-only download
 	isAudioOnly := formatType == "audio"
 
-	// Try to acquire semaphore (limit concurrent downloads)
-	if !h.semaphore.TryAcquire() {
-		h.logger.Warn("Too many concurrent downloads", "available", h.semaphore.Available())
-		http.Error(w, `{"error": "Server busy. Please try again in a moment."}`, http.StatusServiceUnavailable)
+	ctx := r.Context()
+	startTime := time.Now()
+
+	record := newAuditRecord(r, "download", decodedURL)
+	record.Format = formatID
+	defer writeAudit(ctx, h.logger, h.audit, record)
+
+	// Links to one video in different forms are logged and audited alike;
+	// links no backend can download are refused before taking a queue slot
+	canonical, err := h.backend.Canonicalize(decodedURL)
+	if err != nil {
+		record.Outcome = audit.OutcomeRejected
+		record.Cause = services.ClassifyError(err)
+		h.logger.WarnContext(ctx, "Download URL rejected", "url", decodedURL, "error", err)
+		switch {
+		case errors.Is(err, services.ErrURLTooLong):
+			http.Error(w, `{"error": "URL is too long"}`, http.StatusBadRequest)
+		case errors.Is(err, services.ErrUnsupportedURL):
+			http.Error(w, `{"error": "Unsupported platform"}`, http.StatusBadRequest)
+		default:
+			http.Error(w, `{"error": "Invalid URL format"}`, http.StatusBadRequest)
+		}
 		return
 	}
-	defer h.semaphore.Release()
+	decodedURL = canonical.URL
+	record.URL = decodedURL
 
-	h.logger.Info("Starting download", "url", decodedURL, "format", formatID)
+	platform, videoID := h.backend.Identify(decodedURL)
+	record.Platform, record.VideoID = string(platform), videoID
 
-	ctx := r.Context()
-	startTime := time.Now()
+	// Wait for download slots; slots are shared fairly between clients
+	// and expensive downloads (transcodes, 4K, long videos) take more of them
+	identity := middleware.Identity(r)
+	weight := h.backend.EstimateWork(decodedURL, formatID, isAudioOnly).Weight()
+	release, err := h.queue.Acquire(ctx, identity, weight, func(status services.QueueStatus) {
+		h.logger.InfoContext(ctx, "Download queued", "url", decodedURL, "identity", identity, "weight", weight, "position", status.Position, "estimated_wait", status.EstimatedWait)
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrQueueFull) {
+			record.Outcome = audit.OutcomeRejected
+			record.Cause = "queue_full"
+			h.logger.WarnContext(ctx, "Download queue is full", "queued", h.queue.Waiting())
+			w.Header().Set("Retry-After", strconv.Itoa(int(h.queue.EstimateWait().Seconds())))
+			http.Error(w, `{"error": "Server busy. Please try again in a moment."}`, http.StatusServiceUnavailable)
+			return
+		}
+		record.Outcome = audit.OutcomeCanceled
+		record.Cause = services.ClassifyError(err)
+		h.logger.InfoContext(ctx, "Download abandoned while queued", "url", decodedURL, "identity", identity, "waited", time.Since(startTime))
+		return
+	}
+	defer release()
+
+	h.logger.InfoContext(ctx, "Starting download", "url", decodedURL, "format", formatID, "client_ip", middleware.ClientIP(r), "weight", weight, "queued", time.Since(startTime))
+
+	// Files served as-is are relayed without a temp file, which also lets
+	// clients resume with Range requests
+	if streamer, ok := h.backend.(services.Streamer); ok {
+		stream, err := streamer.Stream(ctx, decodedURL, formatID, r.Header)
+		if err == nil {
+			h.serveStream(w, r, stream, record, decodedURL, startTime)
+			return
+		}
+		if !errors.Is(err, services.ErrNotStreamable) {
+			record.Cause = services.ClassifyError(err)
+			if ctx.Err() != nil {
+				record.Outcome = audit.OutcomeCanceled
+			}
+			h.logger.ErrorContext(ctx, "Download failed", "url", decodedURL, "error", err, "cause", services.ClassifyError(err), "request_id", chimiddleware.GetReqID(ctx), "duration", time.Since(startTime))
+			http.Error(w, `{"error": "Download failed"}`, http.StatusBadGateway)
+			return
+		}
+	}
 
 	// Create temp directory if it doesn't exist
-	tempDir := "/tmp/viddown"
+	tempDir := h.tempDir
 	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		h.logger.Error("Failed to create temp directory", "error", err)
+		h.logger.ErrorContext(ctx, "Failed to create temp directory", "error", err)
 		http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
 		return
 	}
 
 	// Download to temp file first (this ensures proper merging for video+audio formats)
-	tempFile, filename, err := h.ytdlp.DownloadToFile(ctx, decodedURL, formatID, tempDir, isAudioOnly)
+	tempFile, filename, err := h.backend.DownloadToFile(ctx, decodedURL, formatID, tempDir, isAudioOnly)
 	if err != nil {
-		h.logger.Error("Download failed", "url", decodedURL, "error", err, "duration", time.Since(startTime))
+		record.Cause = services.ClassifyError(err)
+		if ctx.Err() != nil {
+			record.Outcome = audit.OutcomeCanceled
+		}
+		h.logger.ErrorContext(ctx, "Download failed", "url", decodedURL, "error", err, "cause", services.ClassifyError(err), "request_id", chimiddleware.GetReqID(ctx), "duration", time.Since(startTime))
 		http.Error(w, `{"error": "Download failed"}`, http.StatusInternalServerError)
 		return
 	}
@@ -92,7 +169,7 @@ func (h *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Open the downloaded file
 	file, err := os.Open(tempFile)
 	if err != nil {
-		h.logger.Error("Failed to open temp file", "file", tempFile, "error", err)
+		h.logger.ErrorContext(ctx, "Failed to open temp file", "file", tempFile, "error", err)
 		http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
 		return
 	}
@@ -101,21 +178,16 @@ func (h *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Get file info for Content-Length
 	fileInfo, err := file.Stat()
 	if err != nil {
-		h.logger.Error("Failed to stat temp file", "file", tempFile, "error", err)
+		h.logger.ErrorContext(ctx, "Failed to stat temp file", "file", tempFile, "error", err)
 		http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
 		return
 	}
 
 	// Set headers
-	sanitizedFilename := sanitizeFilename(filename)
-	encodedFilename := url.PathEscape(filename)
-
 	ext := filepath.Ext(filename)
 	if ext == "" {
 		ext = ".mp4"
 		filename += ext
-		sanitizedFilename += ext
-		encodedFilename = url.PathEscape(filename)
 	}
 
 	contentType := mime.TypeByExtension(ext)
@@ -125,18 +197,64 @@ func (h *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, sanitizedFilename, encodedFilename))
+	w.Header().Set("Content-Disposition", contentDisposition(filename))
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.Header().Set("Cache-Control", "no-cache")
 
 	// Stream the file to response
+	_, span := tracing.Start(ctx, "stream response", attribute.Int64("file.size", fileInfo.Size()))
 	written, err := io.Copy(w, file)
+	span.SetAttributes(attribute.Int64("bytes.written", written))
+	tracing.End(span, err)
+	record.Bytes = written
 	if err != nil {
-		h.logger.Error("Failed to stream file", "file", tempFile, "error", err, "written", written)
+		record.Outcome = audit.OutcomeCanceled
+		record.Cause = "client_disconnected"
+		h.logger.ErrorContext(ctx, "Failed to stream file", "file", tempFile, "error", err, "written", written)
 		return
 	}
 
-	h.logger.Info("Download complete", "url", decodedURL, "filename", filename, "size", fileInfo.Size(), "duration", time.Since(startTime))
+	record.Outcome = audit.OutcomeSuccess
+	h.logger.InfoContext(ctx, "Download complete", "url", decodedURL, "filename", filename, "size", fileInfo.Size(), "duration", time.Since(startTime))
+}
+
+// serveStream relays a backend stream, passing range responses through
+func (h *DownloadHandler) serveStream(w http.ResponseWriter, r *http.Request, stream *services.MediaStream, record *audit.Record, videoURL string, startTime time.Time) {
+	ctx := r.Context()
+	defer stream.Body.Close()
+
+	for name, values := range stream.Header {
+		w.Header()[name] = values
+	}
+	if stream.Filename != "" {
+		w.Header().Set("Content-Disposition", contentDisposition(stream.Filename))
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(stream.Status)
+
+	_, span := tracing.Start(ctx, "stream response", attribute.Int("http.upstream_status", stream.Status))
+	written, err := io.Copy(w, stream.Body)
+	span.SetAttributes(attribute.Int64("bytes.written", written))
+	tracing.End(span, err)
+	record.Bytes = written
+	if err != nil {
+		record.Cause = services.ClassifyError(err)
+		if ctx.Err() != nil {
+			record.Outcome = audit.OutcomeCanceled
+			record.Cause = "client_disconnected"
+		}
+		h.logger.ErrorContext(ctx, "Failed to stream file", "url", videoURL, "error", err, "written", written)
+		return
+	}
+
+	record.Outcome = audit.OutcomeSuccess
+	h.logger.InfoContext(ctx, "Download complete", "url", videoURL, "filename", stream.Filename, "status", stream.Status, "size", written, "duration", time.Since(startTime))
+}
+
+// contentDisposition offers filename as an attachment, with a UTF-8 variant for non-ASCII names
+func contentDisposition(filename string) string {
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, sanitizeFilename(filename), url.PathEscape(filename))
 }
 
 func sanitizeFilename(filename string) string {
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"viddown/audit"
+	"viddown/services"
+)
+
+func newTestDownloadHandler(t *testing.T, backend services.Backend, sink audit.Sink) *DownloadHandler {
+	queue := services.NewDownloadQueue(services.NewSemaphore(services.WeightUnit), 1, 1)
+	return NewDownloadHandler(backend, queue, t.TempDir(), sink, discardLogger())
+}
+
+func getDownload(h http.Handler, query url.Values) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/download?"+query.Encode(), nil))
+	return response
+}
+
+func TestDownloadHandler(t *testing.T) {
+	backend := &stubBackend{
+		canonical: map[string]string{testShareURL: testCanonicalURL},
+		info:      &services.VideoInfo{ID: "dQw4w9WgXcQ", Platform: services.PlatformYouTube},
+		content:   "video bytes",
+		filename:  "Never Gonna Give You Up.mp4",
+	}
+	sink := &memorySink{}
+
+	response := getDownload(newTestDownloadHandler(t, backend, sink), url.Values{"url": {testShareURL}, "format_id": {"137+140"}})
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", response.Code, response.Body)
+	}
+	if got := response.Body.String(); got != "video bytes" {
+		t.Errorf("body = %q, want the downloaded file", got)
+	}
+	if got := response.Header().Get("Content-Type"); got != "video/mp4" {
+		t.Errorf("Content-Type = %q, want video/mp4", got)
+	}
+	if got, want := response.Header().Get("Content-Disposition"), contentDisposition("Never Gonna Give You Up.mp4"); got != want {
+		t.Errorf("Content-Disposition = %q, want %q", got, want)
+	}
+	if len(backend.downloaded) != 1 || backend.downloaded[0] != testCanonicalURL+" 137+140" {
+		t.Errorf("downloaded %v, want the canonical URL in format 137+140", backend.downloaded)
+	}
+
+	record := sink.last()
+	if record.Action != "download" || record.URL != testCanonicalURL || record.Format != "137+140" || record.Bytes != int64(len("video bytes")) || record.Outcome != audit.OutcomeSuccess {
+		t.Errorf("audit record = %+v", record)
+	}
+}
+
+func TestDownloadHandlerErrors(t *testing.T) {
+	t.Run("missing url", func(t *testing.T) {
+		response := getDownload(newTestDownloadHandler(t, &stubBackend{}, &memorySink{}), url.Values{})
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", response.Code)
+		}
+	})
+
+	t.Run("invalid url", func(t *testing.T) {
+		backend := &stubBackend{}
+		sink := &memorySink{}
+		response := getDownload(newTestDownloadHandler(t, backend, sink), url.Values{"url": {"not a url"}})
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", response.Code)
+		}
+		if len(backend.downloaded) != 0 {
+			t.Errorf("downloaded %v, want nothing", backend.downloaded)
+		}
+		if record := sink.last(); record.Outcome != audit.OutcomeRejected || record.Cause != "invalid_url" {
+			t.Errorf("audit record = %+v, want rejected as invalid_url", record)
+		}
+	})
+
+	t.Run("backend failure", func(t *testing.T) {
+		backend := &stubBackend{canonical: map[string]string{testShareURL: testCanonicalURL}, err: errors.New("yt-dlp exited")}
+		sink := &memorySink{}
+		response := getDownload(newTestDownloadHandler(t, backend, sink), url.Values{"url": {testShareURL}})
+		if response.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d, want 500", response.Code)
+		}
+		if len(backend.downloaded) != 1 || backend.downloaded[0] != testCanonicalURL+" best" {
+			t.Errorf("downloaded %v, want the best format by default", backend.downloaded)
+		}
+		if record := sink.last(); record.Outcome != audit.OutcomeError {
+			t.Errorf("audit outcome = %q, want %q", record.Outcome, audit.OutcomeError)
+		}
+	})
+}
+
+// streamingBackend relays a fixed file as a services.Streamer, answering
+// Range requests for a suffix of it
+type streamingBackend struct {
+	*stubBackend
+	err    error
+	ranges []string
+}
+
+func (b *streamingBackend) Stream(ctx context.Context, url, formatID string, header http.Header) (*services.MediaStream, error) {
+	b.ranges = append(b.ranges, header.Get("Range"))
+	if b.err != nil {
+		return nil, b.err
+	}
+	stream := &services.MediaStream{
+		Body:     io.NopCloser(strings.NewReader(b.content)),
+		Status:   http.StatusOK,
+		Header:   http.Header{"Content-Type": {"video/mp4"}, "Accept-Ranges": {"bytes"}},
+		Filename: b.filename,
+	}
+	if header.Get("Range") == "bytes=6-" {
+		stream.Body = io.NopCloser(strings.NewReader(b.content[6:]))
+		stream.Status = http.StatusPartialContent
+		stream.Header.Set("Content-Range", "bytes 6-10/11")
+	}
+	return stream, nil
+}
+
+func TestDownloadHandlerStream(t *testing.T) {
+	newBackend := func(err error) *streamingBackend {
+		return &streamingBackend{
+			stubBackend: &stubBackend{
+				canonical: map[string]string{testShareURL: testCanonicalURL},
+				content:   "video bytes",
+				filename:  "clip.mp4",
+			},
+			err: err,
+		}
+	}
+
+	t.Run("range", func(t *testing.T) {
+		backend := newBackend(nil)
+		sink := &memorySink{}
+		request := httptest.NewRequest(http.MethodGet, "/api/download?"+url.Values{"url": {testShareURL}}.Encode(), nil)
+		request.Header.Set("Range", "bytes=6-")
+		response := httptest.NewRecorder()
+		newTestDownloadHandler(t, backend, sink).ServeHTTP(response, request)
+
+		if response.Code != http.StatusPartialContent {
+			t.Fatalf("status = %d, want 206: %s", response.Code, response.Body)
+		}
+		if got := response.Body.String(); got != "bytes" {
+			t.Errorf("body = %q, want the requested range", got)
+		}
+		if got := response.Header().Get("Content-Range"); got != "bytes 6-10/11" {
+			t.Errorf("Content-Range = %q, want it relayed", got)
+		}
+		if got, want := response.Header().Get("Content-Disposition"), contentDisposition("clip.mp4"); got != want {
+			t.Errorf("Content-Disposition = %q, want %q", got, want)
+		}
+		if len(backend.ranges) != 1 || backend.ranges[0] != "bytes=6-" {
+			t.Errorf("Range forwarded as %q", backend.ranges)
+		}
+		if len(backend.downloaded) != 0 {
+			t.Errorf("downloaded %v, want the stream relayed without a temp file", backend.downloaded)
+		}
+		if record := sink.last(); record.Outcome != audit.OutcomeSuccess || record.Bytes != int64(len("bytes")) {
+			t.Errorf("audit record = %+v", record)
+		}
+	})
+
+	t.Run("not streamable", func(t *testing.T) {
+		backend := newBackend(services.ErrNotStreamable)
+		response := getDownload(newTestDownloadHandler(t, backend, &memorySink{}), url.Values{"url": {testShareURL}})
+		if response.Code != http.StatusOK || response.Body.String() != "video bytes" {
+			t.Fatalf("status = %d, body = %q, want the file downloaded instead", response.Code, response.Body)
+		}
+		if len(backend.downloaded) != 1 {
+			t.Errorf("downloaded %v, want one download to a temp file", backend.downloaded)
+		}
+	})
+
+	t.Run("upstream failure", func(t *testing.T) {
+		backend := newBackend(services.ErrMediaUnavailable)
+		sink := &memorySink{}
+		response := getDownload(newTestDownloadHandler(t, backend, sink), url.Values{"url": {testShareURL}})
+		if response.Code != http.StatusBadGateway {
+			t.Errorf("status = %d, want 502", response.Code)
+		}
+		if len(backend.downloaded) != 0 {
+			t.Errorf("downloaded %v, want no fallback to a temp file", backend.downloaded)
+		}
+		if record := sink.last(); record.Outcome != audit.OutcomeError || record.Cause != "unavailable" {
+			t.Errorf("audit record = %+v", record)
+		}
+	})
+}
@@ -5,25 +5,41 @@ import (
 	"net/http"
 	"os/exec"
 	"time"
+
+	"viddown/services"
 )
 
 type HealthHandler struct {
 	ytdlpPath string
 	startTime time.Time
+	semaphore *services.Semaphore
+	queue     *services.DownloadQueue
 }
 
-func NewHealthHandler(ytdlpPath string) *HealthHandler {
+func NewHealthHandler(ytdlpPath string, semaphore *services.Semaphore, queue *services.DownloadQueue) *HealthHandler {
 	return &HealthHandler{
 		ytdlpPath: ytdlpPath,
 		startTime: time.Now(),
+		semaphore: semaphore,
+		queue:     queue,
 	}
 }
 
 type HealthResponse struct {
-	Status    string `json:"status"`
-	Uptime    string `json:"uptime"`
-	YtDlp     string `json:"yt_dlp"`
-	Timestamp string `json:"timestamp"`
+	Status    string          `json:"status"`
+	Uptime    string          `json:"uptime"`
+	YtDlp     string          `json:"yt_dlp"`
+	Timestamp string          `json:"timestamp"`
+	Downloads DownloadsHealth `json:"downloads"`
+}
+
+// DownloadsHealth reports download slot usage; slots are weighted by expected work
+type DownloadsHealth struct {
+	Capacity           int     `json:"capacity"`
+	InUse              int     `json:"in_use"`
+	Holders            int     `json:"holders"`
+	Waiters            int     `json:"waiters"`
+	LongestWaitSeconds float64 `json:"longest_wait_seconds"`
 }
 
 func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -36,11 +52,21 @@ func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	semStats := h.semaphore.Stats()
+	queueStats := h.queue.Stats()
+
 	response := HealthResponse{
 		Status:    "ok",
 		Uptime:    time.Since(h.startTime).Round(time.Second).String(),
 		YtDlp:     ytdlpVersion,
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Downloads: DownloadsHealth{
+			Capacity:           semStats.Capacity,
+			InUse:              semStats.InUse,
+			Holders:            semStats.Holders,
+			Waiters:            queueStats.Waiting,
+			LongestWaitSeconds: queueStats.LongestWait.Seconds(),
+		},
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+
+	"viddown/services"
+)
+
+type LogsHandler struct {
+	logs *services.ProcessLogStore
+}
+
+func NewLogsHandler(logs *services.ProcessLogStore) *LogsHandler {
+	return &LogsHandler{logs: logs}
+}
+
+type LogsResponse struct {
+	RequestID   string                     `json:"requestId"`
+	Invocations []services.ProcessLogEntry `json:"invocations"`
+}
+
+// ServeHTTP returns the captured yt-dlp output for a recent request.
+// Request IDs contain a "/" ("host/xxxxxxxxxx-000001"), so clients send them
+// percent-encoded; chi matches on the raw path and leaves them encoded.
+func (h *LogsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	requestID, err := url.PathUnescape(chi.URLParam(r, "requestID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request ID encoding"})
+		return
+	}
+
+	entries, ok := h.logs.Get(requestID)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "No logs for this request ID. Logs are only kept for recent requests."})
+		return
+	}
+
+	json.NewEncoder(w).Encode(LogsResponse{
+		RequestID:   requestID,
+		Invocations: entries,
+	})
+}
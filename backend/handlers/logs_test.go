@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+
+	"viddown/services"
+)
+
+func TestLogsHandlerFindsGeneratedRequestID(t *testing.T) {
+	logs := services.NewProcessLogStore(10, 1024)
+
+	// A request with an ID generated by chi, whose yt-dlp output is captured
+	var requestID string
+	capture := chimiddleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = chimiddleware.GetReqID(r.Context())
+		logs.Start(r.Context(), "analyze", []string{"--dump-json"}).WriteLine("[youtube] dQw4w9WgXcQ: Downloading webpage")
+	}))
+	capture.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/analyze", nil))
+	if !strings.Contains(requestID, "/") {
+		t.Fatalf("generated request ID %q has no slash, the case this test covers", requestID)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/api/admin/logs/{requestID}", NewLogsHandler(logs).ServeHTTP)
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/admin/logs/"+url.PathEscape(requestID), nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", response.Code, response.Body)
+	}
+
+	var body LogsResponse
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.RequestID != requestID {
+		t.Errorf("requestId = %q, want %q", body.RequestID, requestID)
+	}
+	if len(body.Invocations) != 1 || body.Invocations[0].Operation != "analyze" {
+		t.Errorf("invocations = %+v, want the analyze invocation", body.Invocations)
+	}
+}
+
+func TestLogsHandlerUnknownRequestID(t *testing.T) {
+	router := chi.NewRouter()
+	router.Get("/api/admin/logs/{requestID}", NewLogsHandler(services.NewProcessLogStore(10, 1024)).ServeHTTP)
+
+	for path, want := range map[string]int{
+		"/api/admin/logs/host%2Fmissing-000001": http.StatusNotFound,
+		"/api/admin/logs/bad%zzescape":          http.StatusBadRequest,
+	} {
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.URL.Path, request.URL.RawPath = path, path
+		router.ServeHTTP(response, request)
+		if response.Code != want {
+			t.Errorf("%s: status = %d, want %d", path, response.Code, want)
+		}
+	}
+}
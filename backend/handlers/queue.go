@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"viddown/middleware"
+	"viddown/services"
+)
+
+type QueueHandler struct {
+	queue *services.DownloadQueue
+}
+
+func NewQueueHandler(queue *services.DownloadQueue) *QueueHandler {
+	return &QueueHandler{queue: queue}
+}
+
+type QueueEntry struct {
+	Position             int `json:"position"`
+	EstimatedWaitSeconds int `json:"estimatedWaitSeconds"`
+	WaitingSeconds       int `json:"waitingSeconds"`
+}
+
+type QueueResponse struct {
+	// Waiting lists the caller's own queued downloads
+	Waiting []QueueEntry `json:"waiting"`
+	// QueueLength is the total number of queued downloads across all clients
+	QueueLength int `json:"queueLength"`
+	// EstimatedWaitSeconds is the expected wait for a new download
+	EstimatedWaitSeconds int `json:"estimatedWaitSeconds"`
+}
+
+func (h *QueueHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	response := QueueResponse{
+		Waiting:              []QueueEntry{},
+		QueueLength:          h.queue.Waiting(),
+		EstimatedWaitSeconds: int(h.queue.EstimateWait().Seconds()),
+	}
+
+	for _, status := range h.queue.Status(middleware.Identity(r)) {
+		response.Waiting = append(response.Waiting, QueueEntry{
+			Position:             status.Position,
+			EstimatedWaitSeconds: int(status.EstimatedWait.Seconds()),
+			WaitingSeconds:       int(status.Waiting.Seconds()),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// decodeJSON strictly decodes a single JSON object from the request body into v.
+// Unknown fields, trailing data and oversized bodies are rejected.
+// On failure it returns the status code and a message for the client; message is empty on success.
+func decodeJSON(r *http.Request, v any) (status int, message string) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		var maxBytesErr *http.MaxBytesError
+
+		switch {
+		case errors.Is(err, io.EOF):
+			return http.StatusBadRequest, "Request body is empty"
+		case errors.As(err, &maxBytesErr):
+			return http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body too large (max %d bytes)", maxBytesErr.Limit)
+		case errors.As(err, &syntaxErr):
+			return http.StatusBadRequest, fmt.Sprintf("Malformed JSON at position %d", syntaxErr.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return http.StatusBadRequest, "Malformed JSON: unexpected end of body"
+		case errors.As(err, &typeErr):
+			return http.StatusBadRequest, fmt.Sprintf("Invalid value for field %q: expected %s", typeErr.Field, typeErr.Type)
+		}
+		// encoding/json has no typed error for unknown fields
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return http.StatusBadRequest, "Unknown field " + field
+		}
+		return http.StatusBadRequest, "Invalid request body"
+	}
+
+	if decoder.More() {
+		return http.StatusBadRequest, "Request body must contain a single JSON object"
+	}
+	return http.StatusOK, ""
+}
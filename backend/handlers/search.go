@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+
+	"viddown/middleware"
+	"viddown/services"
+)
+
+// maxSearchQuery caps the length of a search query in characters
+const maxSearchQuery = 200
+
+type SearchHandler struct {
+	search *services.SearchService
+	logger *slog.Logger
+}
+
+func NewSearchHandler(search *services.SearchService, logger *slog.Logger) *SearchHandler {
+	return &SearchHandler{
+		search: search,
+		logger: logger,
+	}
+}
+
+type SearchResponse struct {
+	Results []services.SearchResult `json:"results"`
+}
+
+// ServeHTTP searches videos by text. Query parameters: q, platform (youtube
+// by default, or soundcloud) and limit (10 by default).
+func (h *SearchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Query is required"})
+		return
+	}
+	if utf8.RuneCountInString(query) > maxSearchQuery {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Query is too long: at most %d characters", maxSearchQuery)})
+		return
+	}
+
+	platform := services.Platform(strings.ToLower(r.URL.Query().Get("platform")))
+	if platform == "" {
+		platform = services.PlatformYouTube
+	}
+
+	limit := services.DefaultSearchResults
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > services.MaxSearchResults {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Invalid limit: expected 1-%d", services.MaxSearchResults)})
+			return
+		}
+		limit = n
+	}
+
+	h.logger.InfoContext(r.Context(), "Searching", "query", query, "platform", platform, "limit", limit, "client_ip", middleware.ClientIP(r))
+
+	results, err := h.search.Search(r.Context(), query, platform, limit)
+	if err != nil {
+		if errors.Is(err, services.ErrSearchUnsupported) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "Search is not available for this platform"})
+			return
+		}
+		h.logger.ErrorContext(r.Context(), "Failed to search", "query", query, "platform", platform, "error", err, "cause", services.ClassifyError(err), "request_id", chimiddleware.GetReqID(r.Context()))
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Search failed. Please try again."})
+		return
+	}
+
+	h.logger.InfoContext(r.Context(), "Search complete", "query", query, "platform", platform, "results", len(results))
+	json.NewEncoder(w).Encode(SearchResponse{Results: results})
+}
@@ -1,28 +1,36 @@
 package handlers
 
 import (
-	"io"
+	"bytes"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"viddown/services"
+	"viddown/tracing"
 )
 
 type ThumbnailHandler struct {
-	logger *slog.Logger
-	client *http.Client
+	logger     *slog.Logger
+	thumbnails *services.ThumbnailService
 }
 
-func NewThumbnailHandler(logger *slog.Logger) *ThumbnailHandler {
+func NewThumbnailHandler(thumbnails *services.ThumbnailService, logger *slog.Logger) *ThumbnailHandler {
 	return &ThumbnailHandler{
-		logger: logger,
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		logger:     logger,
+		thumbnails: thumbnails,
 	}
 }
 
+// ServeHTTP proxies a thumbnail. Optional query parameters:
+// w and h bound the size (aspect ratio is kept), format is jpeg, png or webp.
 func (h *ThumbnailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -38,90 +46,76 @@ func (h *ThumbnailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Decode URL
 	decodedURL, err := url.QueryUnescape(thumbnailURL)
 	if err != nil {
-		h.logger.Error("Failed to decode thumbnail URL", "error", err)
+		h.logger.ErrorContext(r.Context(), "Failed to decode thumbnail URL", "error", err)
 		http.Error(w, "Invalid URL", http.StatusBadRequest)
 		return
 	}
 
-	// Validate that it's a known thumbnail domain
-	if !isAllowedThumbnailDomain(decodedURL) {
-		h.logger.Warn("Blocked thumbnail request for unknown domain", "url", decodedURL)
-		http.Error(w, "Domain not allowed", http.StatusForbidden)
-		return
-	}
-
-	// Fetch the thumbnail
-	req, err := http.NewRequestWithContext(r.Context(), "GET", decodedURL, nil)
+	opts, err := parseThumbnailOptions(r.URL.Query())
 	if err != nil {
-		h.logger.Error("Failed to create request", "error", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Set headers to look like a browser
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
-	req.Header.Set("Accept", "image/*")
-	req.Header.Set("Referer", "https://www.youtube.com/")
-
-	resp, err := h.client.Do(req)
+	// The fetcher enforces the host allowlist on every redirect hop
+	thumbnail, err := h.thumbnails.Get(r.Context(), decodedURL, opts)
 	if err != nil {
-		h.logger.Error("Failed to fetch thumbnail", "url", decodedURL, "error", err)
-		http.Error(w, "Failed to fetch thumbnail", http.StatusBadGateway)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		h.logger.Warn("Thumbnail fetch failed", "url", decodedURL, "status", resp.StatusCode)
-		http.Error(w, "Thumbnail not found", resp.StatusCode)
+		switch {
+		case errors.Is(err, services.ErrInvalidURL), errors.Is(err, services.ErrInsecureScheme):
+			http.Error(w, "Invalid URL", http.StatusBadRequest)
+		case errors.Is(err, services.ErrHostNotAllowed), errors.Is(err, services.ErrBlockedAddress):
+			h.logger.WarnContext(r.Context(), "Blocked thumbnail request", "url", decodedURL, "error", err)
+			http.Error(w, "Domain not allowed", http.StatusForbidden)
+		case errors.Is(err, services.ErrThumbnailNotFound):
+			h.logger.WarnContext(r.Context(), "Thumbnail fetch failed", "url", decodedURL)
+			http.Error(w, "Thumbnail not found", http.StatusNotFound)
+		case errors.Is(err, services.ErrUnsupportedImage):
+			h.logger.WarnContext(r.Context(), "Cannot transform thumbnail", "url", decodedURL, "error", err)
+			http.Error(w, "Cannot convert this thumbnail", http.StatusUnprocessableEntity)
+		default:
+			h.logger.ErrorContext(r.Context(), "Failed to fetch thumbnail", "url", decodedURL, "error", err)
+			http.Error(w, "Failed to fetch thumbnail", http.StatusBadGateway)
+		}
 		return
 	}
 
-	// Copy headers
-	contentType := resp.Header.Get("Content-Type")
-	if contentType != "" {
-		w.Header().Set("Content-Type", contentType)
-	} else {
-		w.Header().Set("Content-Type", "image/jpeg")
-	}
-
-	// Cache for 1 hour
-	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Content-Type", thumbnail.ContentType)
+	w.Header().Set("ETag", `"`+thumbnail.Hash+`"`)
+	maxAge := max(0, int(time.Until(thumbnail.Expires).Seconds()))
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(maxAge))
 
-	// Stream response
-	io.Copy(w, resp.Body)
+	// ServeContent answers If-None-Match with 304
+	_, span := tracing.Start(r.Context(), "stream response")
+	span.SetAttributes(attribute.Int("bytes.size", len(thumbnail.Data)))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(thumbnail.Data))
+	span.End()
 }
 
-func isAllowedThumbnailDomain(rawURL string) bool {
-	allowedDomains := []string{
-		"i.ytimg.com",
-		"img.youtube.com",
-		"i1.ytimg.com",
-		"i2.ytimg.com",
-		"i3.ytimg.com",
-		"i4.ytimg.com",
-		"i9.ytimg.com",
-		"yt3.ggpht.com",
-		"instagram.com",
-		"cdninstagram.com",
-		"scontent.cdninstagram.com",
-		"tiktokcdn.com",
-		"p16-sign-va.tiktokcdn.com",
-		"p16-sign-sg.tiktokcdn.com",
-	}
-
-	parsedURL, err := url.Parse(rawURL)
-	if err != nil {
-		return false
-	}
-
-	host := strings.ToLower(parsedURL.Host)
-	for _, domain := range allowedDomains {
-		if host == domain || strings.HasSuffix(host, "."+domain) {
-			return true
+func parseThumbnailOptions(query url.Values) (services.ThumbnailOptions, error) {
+	var opts services.ThumbnailOptions
+	for _, param := range []struct {
+		name   string
+		target *int
+	}{{"w", &opts.Width}, {"h", &opts.Height}} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > services.MaxThumbnailDimension {
+			return opts, fmt.Errorf("Invalid %s: expected 1-%d", param.name, services.MaxThumbnailDimension)
 		}
+		*param.target = n
 	}
 
-	return false
+	switch format := strings.ToLower(query.Get("format")); format {
+	case "":
+	case "jpeg", "jpg":
+		opts.Format = "jpeg"
+	case "png", "webp":
+		opts.Format = format
+	default:
+		return opts, errors.New("Invalid format: expected jpeg, png or webp")
+	}
+	return opts, nil
 }
-
@@ -0,0 +1,29 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Level is the process-wide log level. It can be changed at runtime.
+var Level = new(slog.LevelVar)
+
+// NewHandler creates the JSON log handler used by the service.
+// Attributes pass through the redaction policy (see Redact) before being written.
+func NewHandler(w io.Writer) slog.Handler {
+	return slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       Level,
+		ReplaceAttr: Redact,
+	})
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error"
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return level, fmt.Errorf("invalid log level %q", s)
+	}
+	return level, nil
+}
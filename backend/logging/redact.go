@@ -0,0 +1,102 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces secret values in logs
+const Redacted = "REDACTED"
+
+// safeQueryParams are query parameters that identify content rather than
+// a user or session, so they are kept in logged URLs
+var safeQueryParams = map[string]bool{
+	"v":     true,
+	"list":  true,
+	"index": true,
+	"t":     true,
+	"start": true,
+	"p":     true,
+}
+
+// secretKeys are log attribute keys whose values are never written
+var secretKeys = map[string]bool{
+	"authorization": true,
+	"cookie":        true,
+	"cookies":       true,
+	"set-cookie":    true,
+	"token":         true,
+	"password":      true,
+	"secret":        true,
+	"api_key":       true,
+}
+
+var urlPattern = regexp.MustCompile(`https?://[^\s"'<>]+`)
+
+// Redact is a slog ReplaceAttr function implementing the redaction policy:
+//   - secret keys (authorization, cookie, token, ...) are replaced entirely
+//   - keys named "url" or ending in "url" are logged via RedactURL
+//   - free-form "error" values have any URLs inside them redacted
+func Redact(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+
+	switch {
+	case secretKeys[key]:
+		return slog.String(a.Key, Redacted)
+	case strings.HasSuffix(key, "url"):
+		if a.Value.Kind() == slog.KindString {
+			return slog.String(a.Key, RedactURL(a.Value.String()))
+		}
+	case key == "error" || key == "stderr":
+		return slog.String(a.Key, RedactText(a.Value.String()))
+	}
+	return a
+}
+
+// RedactURL removes credentials from a URL and masks query parameters that
+// aren't known to be safe (video IDs, playlists and timestamps are kept).
+// Strings that aren't absolute URLs are returned unchanged.
+func RedactURL(raw string) string {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return raw
+	}
+
+	if parsed.User != nil {
+		parsed.User = url.User(Redacted)
+	}
+	if parsed.RawQuery != "" {
+		query := parsed.Query()
+		for key, values := range query {
+			if !safeQueryParams[strings.ToLower(key)] {
+				for i := range values {
+					values[i] = Redacted
+				}
+			}
+		}
+		parsed.RawQuery = query.Encode()
+	}
+	parsed.Fragment = ""
+	return parsed.String()
+}
+
+// RedactText redacts every URL embedded in free-form text such as error messages
+func RedactText(text string) string {
+	return urlPattern.ReplaceAllStringFunc(text, RedactURL)
+}
+
+// RedactHeaders returns a copy of headers that is safe to log
+func RedactHeaders(headers http.Header) http.Header {
+	safe := make(http.Header, len(headers))
+	for key, values := range headers {
+		if secretKeys[strings.ToLower(key)] || strings.EqualFold(key, "Proxy-Authorization") {
+			safe[key] = []string{Redacted}
+			continue
+		}
+		safe[key] = values
+	}
+	return safe
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log/slog"
 	"net/http"
 	"os"
@@ -11,70 +12,219 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	chimiddleware "github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/cors"
+	"github.com/redis/go-redis/v9"
 
+	"viddown/audit"
 	"viddown/config"
 	"viddown/handlers"
+	"viddown/logging"
+	"viddown/metrics"
 	"viddown/middleware"
 	"viddown/services"
+	"viddown/tracing"
 )
 
 func main() {
-	// Initialize structured logger
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:]))
+	}
+
+	// Initialize structured logger; records logged with a request context carry trace IDs
+	logger := slog.New(tracing.LogHandler(logging.NewHandler(os.Stdout)))
 	slog.SetDefault(logger)
 
-	// Load configuration
-	cfg := config.Load()
+	// Load configuration: defaults, then the config file, then environment variables
+	cfgPath, err := configPath(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		logger.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	cfgHolder := config.NewHolder(cfg)
+
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+	logging.Level.Set(level)
 	logger.Info("Configuration loaded",
+		"file", cfgPath,
 		"port", cfg.Port,
+		"logLevel", level,
 		"authRequired", cfg.AuthRequired,
 		"maxConcurrent", cfg.MaxConcurrent,
+		"maxPerClient", cfg.MaxPerClient,
 		"rateLimitRPM", cfg.RateLimitRPM,
+		"rateLimits", cfg.RateLimits,
+		"rateLimitStore", cfg.RateLimitStore,
+		"trustedProxies", cfg.TrustedProxies,
+		"trustedProxyHeader", cfg.TrustedProxyHeader,
 	)
 
+	// Initialize tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:    cfg.TracingEndpoint,
+		SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		logger.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
 	// Initialize services
-	validator := services.NewValidator()
-	ytdlp := services.NewYtDlpService(cfg.YtDlpPath, validator)
-	semaphore := services.NewSemaphore(cfg.MaxConcurrent)
-	rateLimiter := middleware.NewRateLimiter(cfg.RateLimitRPM)
+	platforms := services.NewPlatformRegistry(services.DefaultPlatforms())
+	if err := platforms.SetEnabled(cfg.EnabledPlatforms); err != nil {
+		logger.Error("Invalid platform configuration", "error", err)
+		os.Exit(1)
+	}
+	platforms.SetDirectHosts(cfg.DirectHosts)
+	validator := services.NewValidator(cfg.MaxURLLength, platforms)
+	processLogs := services.NewProcessLogStore(cfg.ProcessLogRequests, cfg.ProcessLogBytes)
+	ytdlp := services.NewYtDlpService(cfg.YtDlpPath, validator, processLogs)
+
+	// Plain media files and HLS/DASH manifests on allowlisted hosts are fetched natively, everything else by yt-dlp
+	directFetcher := services.NewSafeFetcher(services.FetcherOptions{
+		Hosts:        cfg.DirectHosts,
+		MaxBytes:     cfg.DirectMaxBytes,
+		IdleTimeout:  time.Minute,
+		AllowHTTP:    cfg.DirectAllowHTTP,
+		AllowPrivate: cfg.DirectAllowPrivateNetworks,
+	})
+	backends := services.NewRegistry(validator)
+	backends.Register(ytdlp, services.PlatformYouTube)
+	backends.Register(services.NewMusicService(ytdlp), services.PlatformYouTubeMusic)
+	backends.Register(services.NewTikTokService(ytdlp), services.PlatformTikTok)
+
+	// Photos of posts are fetched from the platform's CDN, videos by yt-dlp
+	postFetcher := func(platform services.Platform) *services.SafeFetcher {
+		spec, _ := platforms.Lookup(platform)
+		return services.NewSafeFetcher(services.FetcherOptions{
+			Hosts:    spec.ThumbnailHosts,
+			MaxBytes: 50 * 1024 * 1024,
+			Timeout:  2 * time.Minute,
+		})
+	}
+	backends.Register(services.NewPostService(services.PlatformInstagram, ytdlp, postFetcher(services.PlatformInstagram), cfg.InstagramCookiesFile), services.PlatformInstagram)
+	backends.Register(services.NewPostService(services.PlatformX, ytdlp, postFetcher(services.PlatformX), ""), services.PlatformX)
+	backends.Register(services.NewVimeoService(ytdlp), services.PlatformVimeo)
+	backends.Register(services.NewRedditService(ytdlp), services.PlatformReddit)
+	backends.Register(services.NewSoundCloudService(ytdlp), services.PlatformSoundCloud)
+	backends.Register(services.NewTwitchService(ytdlp), services.PlatformTwitch)
+	manifests := services.NewManifestService(directFetcher, cfg.FFmpegPath, cfg.ManifestConcurrency, cfg.DirectMaxBytes, processLogs)
+	backends.Register(services.NewDirectService(directFetcher, manifests), services.PlatformDirect)
+	semaphore := services.NewSemaphore(cfg.MaxConcurrent * services.WeightUnit)
+	downloadQueue := services.NewDownloadQueue(semaphore, cfg.MaxPerClient, cfg.MaxQueue)
+
+	// The store is validated by config.Load, so the URL parses
+	var rateLimitStore middleware.RateLimitStore
+	if cfg.RateLimitStore == "redis" {
+		opts, _ := redis.ParseURL(cfg.RedisURL)
+		rateLimitStore = middleware.NewRedisRateLimitStore(redis.NewClient(opts), "viddown:ratelimit:")
+	} else {
+		rateLimitStore = middleware.NewMemoryRateLimitStore()
+	}
+	fallbackLimit, routeLimits := rateLimits(cfg)
+	rateLimiter := middleware.NewRateLimiter(rateLimitStore, fallbackLimit, routeLimits, logger)
+
+	clientIP, err := middleware.NewClientIPResolver(cfg.TrustedProxies, cfg.TrustedProxyHeader, cfg.IPv6Prefix)
+	if err != nil {
+		logger.Error("Invalid trusted proxy configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Gauges sampled at scrape time
+	metrics.GaugeFunc("download_slots_in_use", "Weighted download slots currently held.", func() float64 {
+		return float64(semaphore.Stats().InUse)
+	})
+	metrics.GaugeFunc("download_slots_available", "Weighted download slots currently free.", func() float64 {
+		return float64(semaphore.Available())
+	})
+	metrics.GaugeFunc("download_queue_length", "Downloads waiting for a slot.", func() float64 {
+		return float64(downloadQueue.Stats().Waiting)
+	})
+	metrics.GaugeFunc("temp_dir_bytes", "Disk space used by in-flight downloads.", func() float64 {
+		return float64(services.DirSize(cfg.TempDir))
+	})
+
+	// Audit log of analyzes and downloads
+	var auditSink audit.Sink = audit.Nop{}
+	if cfg.AuditDir != "" {
+		fileSink, err := audit.NewFileSink(cfg.AuditDir, cfg.AuditMaxBytes, cfg.AuditMaxFiles)
+		if err != nil {
+			logger.Error("Failed to open audit log", "error", err)
+			os.Exit(1)
+		}
+		auditSink = fileSink
+	}
 
 	// Initialize handlers
-	healthHandler := handlers.NewHealthHandler(cfg.YtDlpPath)
-	configHandler := handlers.NewConfigHandler(cfg)
-	analyzeHandler := handlers.NewAnalyzeHandler(ytdlp, logger)
-	downloadHandler := handlers.NewDownloadHandler(ytdlp, semaphore, logger)
-	thumbnailHandler := handlers.NewThumbnailHandler(logger)
+	healthHandler := handlers.NewHealthHandler(cfg.YtDlpPath, semaphore, downloadQueue)
+	configHandler := handlers.NewConfigHandler(cfgHolder, platforms)
+	analyzeHandler := handlers.NewAnalyzeHandler(backends, platforms, auditSink, logger)
+	searchHandler := handlers.NewSearchHandler(services.NewSearchService(ytdlp, platforms), logger)
+	downloadHandler := handlers.NewDownloadHandler(backends, downloadQueue, cfg.TempDir, auditSink, logger)
+	queueHandler := handlers.NewQueueHandler(downloadQueue)
+	thumbnailFetcher := services.NewSafeFetcher(services.FetcherOptions{
+		Hosts:    thumbnailHosts(cfg, platforms),
+		MaxBytes: cfg.ThumbnailMaxBytes,
+		Timeout:  30 * time.Second,
+	})
+	thumbnailCache, err := services.NewThumbnailCache(cfg.ThumbnailCacheDir, cfg.ThumbnailCacheMemoryBytes, cfg.ThumbnailCacheDiskBytes)
+	if err != nil {
+		// Thumbnails are cheap to fetch again, so a missing disk tier is not fatal
+		logger.Warn("Failed to open thumbnail cache directory, caching in memory only", "dir", cfg.ThumbnailCacheDir, "error", err)
+		thumbnailCache, _ = services.NewThumbnailCache("", cfg.ThumbnailCacheMemoryBytes, cfg.ThumbnailCacheDiskBytes)
+	}
+	thumbnails := services.NewThumbnailService(thumbnailFetcher, thumbnailCache, cfg.ThumbnailCacheMinTTL)
+	thumbnailHandler := handlers.NewThumbnailHandler(thumbnails, logger)
+	logsHandler := handlers.NewLogsHandler(processLogs)
 
 	// Initialize router
 	r := chi.NewRouter()
 
 	// Global middleware
 	r.Use(chimiddleware.RequestID)
-	r.Use(chimiddleware.RealIP)
-	r.Use(chimiddleware.Logger)
+	r.Use(clientIP.Middleware)
+	r.Use(tracing.Middleware)
+	r.Use(metrics.Middleware)
+	r.Use(middleware.AccessLog(logger))
 	r.Use(chimiddleware.Recoverer)
 	r.Use(chimiddleware.Timeout(6 * time.Hour)) // Extended for long videos
+	r.Use(middleware.SecurityHeaders(cfg.SecurityHeaders))
+	r.Use(middleware.MaxURLLength(cfg.MaxURLLength))
 
-	// CORS
-	r.Use(cors.Handler(middleware.CORS()))
+	// CORS; same-origin only unless CORS_ALLOWED_ORIGINS is set
+	corsPolicy := middleware.NewCORS(corsConfig(cfg))
+	r.Use(corsPolicy.Handler)
 
-	// Rate limiting
-	r.Use(rateLimiter.Middleware)
+	// Prometheus scrape endpoint; not proxied by Nginx, so only reachable internally
+	r.Handle("/metrics", metrics.Handler())
 
 	// Auth middleware (currently a no-op when AUTH_REQUIRED=false)
 	authProvider := &middleware.NoAuthProvider{}
-	r.Use(middleware.AuthMiddleware(cfg.AuthRequired, authProvider))
-
-	// API routes
-	r.Route("/api", func(r chi.Router) {
-		r.Get("/health", healthHandler.ServeHTTP)
-		r.Get("/config", configHandler.ServeHTTP)
-		r.Post("/analyze", analyzeHandler.ServeHTTP)
-		r.Get("/download", downloadHandler.ServeHTTP)
-		r.Get("/thumbnail", thumbnailHandler.ServeHTTP)
+
+	// API routes, each with its own rate limit allowance and body size limit
+	r.With(middleware.AuthMiddleware(cfg.AuthRequired, authProvider)).Route("/api", func(r chi.Router) {
+		route := func(name string) chi.Router {
+			return r.With(rateLimiter.Limit(name), middleware.MaxBodySize(cfg.BodyLimit(name)))
+		}
+		route("health").Get("/health", healthHandler.ServeHTTP)
+		route("config").Get("/config", configHandler.ServeHTTP)
+		route("analyze").Post("/analyze", analyzeHandler.ServeHTTP)
+		// A search runs yt-dlp like an analyze and draws on the same allowance
+		route("analyze").Get("/search", searchHandler.ServeHTTP)
+		route("download").Get("/download", downloadHandler.ServeHTTP)
+		route("queue").Get("/queue", queueHandler.ServeHTTP)
+		route("thumbnail").Get("/thumbnail", thumbnailHandler.ServeHTTP)
+
+		// Admin endpoints, require ADMIN_TOKEN
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(middleware.AdminOnly(cfg.AdminToken), middleware.MaxBodySize(cfg.BodyLimit("admin")))
+			r.Get("/logs/{requestID}", logsHandler.ServeHTTP)
+			if querier, ok := auditSink.(audit.Querier); ok {
+				r.Get("/audit", handlers.NewAuditHandler(querier).ServeHTTP)
+			}
+		})
 	})
 
 	// Create server
@@ -95,10 +245,30 @@ func main() {
 		}
 	}()
 
-	// Graceful shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	// SIGHUP reloads the settings that can change live
+	reloader := &reloader{
+		path:        cfgPath,
+		holder:      cfgHolder,
+		rateLimiter: rateLimiter,
+		clientIP:    clientIP,
+		cors:        corsPolicy,
+		thumbnails:  thumbnailFetcher,
+		platforms:   platforms,
+		direct:      directFetcher,
+		semaphore:   semaphore,
+		queue:       downloadQueue,
+		logger:      logger,
+	}
+
+	// Graceful shutdown on SIGINT/SIGTERM
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reloader.reload()
+	}
 
 	logger.Info("Shutting down server...")
 
@@ -110,6 +280,18 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := rateLimiter.Close(); err != nil {
+		logger.Error("Failed to close rate limit store", "error", err)
+	}
+
+	if err := auditSink.Close(); err != nil {
+		logger.Error("Failed to close audit log", "error", err)
+	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("Failed to flush traces", "error", err)
+	}
+
 	logger.Info("Server stopped gracefully")
 }
 
@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "viddown"
+
+// Registry holds all application metrics, served by Handler
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status.",
+		// Downloads can take many minutes, so buckets extend well beyond typical API latency
+		Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800},
+	}, []string{"route", "method", "status"})
+
+	BytesServed = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_response_bytes_total",
+		Help:      "Response body bytes written by route.",
+	}, []string{"route"})
+
+	SubprocessDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "subprocess_duration_seconds",
+		Help:      "yt-dlp subprocess phase durations (analyze, download, merge, postprocess).",
+		Buckets:   []float64{.5, 1, 2, 5, 10, 20, 30, 60, 120, 300, 600, 1200, 3600},
+	}, []string{"phase", "outcome"})
+
+	Operations = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "operations_total",
+		Help:      "Analyze and download operations by platform and outcome.",
+	}, []string{"operation", "platform", "outcome"})
+
+	Errors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_total",
+		Help:      "Failed operations by classified cause and platform.",
+	}, []string{"operation", "platform", "cause"})
+
+	RateLimitRejections = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Requests rejected by the rate limiter, by route.",
+	}, []string{"route"})
+
+	ThumbnailCache = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "thumbnail_cache_requests_total",
+		Help:      "Thumbnail cache lookups by result (hit, miss, revalidated, stale).",
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// GaugeFunc registers a gauge whose value is read from fn at scrape time
+func GaugeFunc(name, help string, fn func() float64) {
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn)
+}
+
+// ObserveOperation records the outcome of an analyze or download.
+// cause is empty on success.
+func ObserveOperation(operation, platform, cause string) {
+	if cause == "" {
+		Operations.WithLabelValues(operation, platform, "success").Inc()
+		return
+	}
+	Operations.WithLabelValues(operation, platform, "error").Inc()
+	Errors.WithLabelValues(operation, platform, cause).Inc()
+}
+
+// ObservePhase records how long a yt-dlp phase took
+func ObservePhase(phase string, duration time.Duration, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	SubprocessDuration.WithLabelValues(phase, outcome).Observe(duration.Seconds())
+}
+
+// Middleware records request counts, latency and response size.
+// Requests are labelled by chi route pattern rather than path to keep cardinality bounded.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+
+		defer func() {
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			code := strconv.Itoa(status)
+
+			HTTPRequests.WithLabelValues(route, r.Method, code).Inc()
+			HTTPDuration.WithLabelValues(route, r.Method, code).Observe(time.Since(start).Seconds())
+			BytesServed.WithLabelValues(route).Add(float64(ww.BytesWritten()))
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
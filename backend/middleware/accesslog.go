@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+
+	"viddown/logging"
+)
+
+const accessEntryKey contextKey = "accessEntry"
+
+// accessEntry collects request details discovered by inner middleware
+// (such as the authenticated user) for the access log line
+type accessEntry struct {
+	user string
+}
+
+// quietRoutes are logged at debug level to keep probes out of the logs
+var quietRoutes = map[string]bool{
+	"/api/health": true,
+	"/metrics":    true,
+}
+
+// AccessLog writes one structured log line per request with method, route
+// pattern, status, response size, duration, request ID, client IP and user.
+// The query string is never logged, it carries the URLs users download.
+// At debug level the request headers are added, with credentials redacted,
+// to help diagnose proxies and clients.
+func AccessLog(logger *slog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			entry := &accessEntry{}
+			ctx := context.WithValue(r.Context(), accessEntryKey, entry)
+
+			// Echo the request ID so clients can quote it in support requests
+			if requestID := chimiddleware.GetReqID(ctx); requestID != "" {
+				w.Header().Set(chimiddleware.RequestIDHeader, requestID)
+			}
+
+			ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			start := time.Now()
+
+			defer func() {
+				status := ww.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+
+				route := ""
+				if rctx := chi.RouteContext(ctx); rctx != nil {
+					route = rctx.RoutePattern()
+				}
+
+				level := slog.LevelInfo
+				switch {
+				case status >= http.StatusInternalServerError:
+					level = slog.LevelError
+				case status >= http.StatusBadRequest:
+					level = slog.LevelWarn
+				case quietRoutes[route]:
+					level = slog.LevelDebug
+				}
+
+				attrs := []slog.Attr{
+					slog.String("method", r.Method),
+					slog.String("route", route),
+					slog.String("path", r.URL.Path),
+					slog.Int("status", status),
+					slog.Int("bytes", ww.BytesWritten()),
+					slog.Duration("duration", time.Since(start)),
+					slog.String("request_id", chimiddleware.GetReqID(ctx)),
+					slog.String("client_ip", ClientIP(r)),
+					slog.String("user", entry.user),
+					slog.String("user_agent", r.UserAgent()),
+				}
+				if logger.Enabled(ctx, slog.LevelDebug) {
+					attrs = append(attrs, slog.Any("headers", logging.RedactHeaders(r.Header)))
+				}
+				logger.LogAttrs(ctx, level, "Request", attrs...)
+			}()
+
+			next.ServeHTTP(ww, r.WithContext(ctx))
+		})
+	}
+}
+
+// setAccessUser records the authenticated user for the access log
+func setAccessUser(ctx context.Context, user *User) {
+	if entry, ok := ctx.Value(accessEntryKey).(*accessEntry); ok && user != nil {
+		entry.user = user.ID
+	}
+}
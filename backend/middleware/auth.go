@@ -49,6 +49,12 @@ func (p *OAuthProvider) Validate(token string) (*User, error) {
 	return nil, nil
 }
 
+// UserFromContext returns the authenticated user, or nil for anonymous requests
+func UserFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(UserContextKey).(*User)
+	return user
+}
+
 // AuthMiddleware creates authentication middleware
 // When required=false, it passes all requests through
 // When required=true, it validates the token using the provider
@@ -72,6 +78,7 @@ func AuthMiddleware(required bool, provider AuthProvider) func(next http.Handler
 				return
 			}
 
+			setAccessUser(r.Context(), user)
 			ctx := context.WithValue(r.Context(), UserContextKey, user)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
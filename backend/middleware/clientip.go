@@ -0,0 +1,266 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ClientInfo describes where a request originated from
+type ClientInfo struct {
+	// IP is the resolved client address, used for logging and auditing
+	IP string
+	// Key is the address used to group clients for rate limiting.
+	// For IPv6 clients it may be aggregated to a network prefix.
+	Key string
+}
+
+const clientInfoKey contextKey = "client"
+
+// Forwarding headers a trusted proxy may set
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+// ClientIPResolver resolves the originating client address of a request.
+// Forwarding headers are only honored when the direct peer is a trusted proxy,
+// so clients cannot pick their own identity by sending spoofed headers.
+type ClientIPResolver struct {
+	mu         sync.RWMutex
+	trusted    []*net.IPNet
+	header     string
+	ipv6Prefix int
+}
+
+// NewClientIPResolver creates a resolver that trusts the given proxies.
+// Each entry is either a CIDR ("10.0.0.0/8") or a single address ("127.0.0.1").
+// header is the one forwarding header the proxies set (HeaderXForwardedFor,
+// HeaderXRealIP or HeaderForwarded); the others are ignored, as a proxy passes
+// through whatever the client sent in them.
+// ipv6Prefix aggregates IPv6 clients to a network of that size for rate limiting
+// keys (64 is a typical choice); 0 disables aggregation.
+func NewClientIPResolver(trustedProxies []string, header string, ipv6Prefix int) (*ClientIPResolver, error) {
+	resolver := &ClientIPResolver{}
+	if err := resolver.Update(trustedProxies, header, ipv6Prefix); err != nil {
+		return nil, err
+	}
+	return resolver, nil
+}
+
+// Update replaces the trusted proxies, their header and the IPv6 prefix,
+// e.g. after a config reload. On error the previous settings are kept.
+func (c *ClientIPResolver) Update(trustedProxies []string, header string, ipv6Prefix int) error {
+	if ipv6Prefix < 0 || ipv6Prefix > 128 {
+		return fmt.Errorf("invalid IPv6 prefix length %d", ipv6Prefix)
+	}
+	switch {
+	case strings.EqualFold(header, HeaderForwarded):
+		header = HeaderForwarded
+	case strings.EqualFold(header, HeaderXForwardedFor):
+		header = HeaderXForwardedFor
+	case strings.EqualFold(header, HeaderXRealIP):
+		header = HeaderXRealIP
+	default:
+		return fmt.Errorf("unsupported forwarding header %q", header)
+	}
+
+	var trusted []*net.IPNet
+	for _, entry := range trustedProxies {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		trusted = append(trusted, network)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trusted = trusted
+	c.header = header
+	c.ipv6Prefix = ipv6Prefix
+	return nil
+}
+
+// Resolve returns the client IP for a request.
+// When the peer is a trusted proxy, only the configured forwarding header is
+// consulted. Forwarding chains (Forwarded per RFC 7239, X-Forwarded-For) are
+// walked right-to-left and the first address that is not a trusted proxy wins.
+func (c *ClientIPResolver) Resolve(r *http.Request) net.IP {
+	peer := parseHost(r.RemoteAddr)
+	if peer == nil || !c.isTrusted(peer) {
+		return peer
+	}
+
+	c.mu.RLock()
+	header := c.header
+	c.mu.RUnlock()
+
+	values := r.Header.Values(header)
+	if len(values) == 0 {
+		return peer
+	}
+
+	switch header {
+	case HeaderForwarded:
+		return c.walkChain(parseForwarded(values), peer)
+	case HeaderXForwardedFor:
+		var chain []string
+		for _, value := range values {
+			for _, hop := range strings.Split(value, ",") {
+				chain = append(chain, strings.TrimSpace(hop))
+			}
+		}
+		return c.walkChain(chain, peer)
+	default:
+		if realIP := parseHost(strings.TrimSpace(values[0])); realIP != nil {
+			return realIP
+		}
+		return peer
+	}
+}
+
+// Key returns the rate limiting key for a client IP
+func (c *ClientIPResolver) Key(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	c.mu.RLock()
+	prefix := c.ipv6Prefix
+	c.mu.RUnlock()
+	if ip.To4() == nil && prefix > 0 {
+		network := &net.IPNet{IP: ip.Mask(net.CIDRMask(prefix, 128)), Mask: net.CIDRMask(prefix, 128)}
+		return network.String()
+	}
+	return ip.String()
+}
+
+// Middleware resolves the client once per request and stores it in the context
+func (c *ClientIPResolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := c.Resolve(r)
+
+		info := ClientInfo{IP: r.RemoteAddr, Key: r.RemoteAddr}
+		if ip != nil {
+			info = ClientInfo{IP: ip.String(), Key: c.Key(ip)}
+		}
+
+		ctx := context.WithValue(r.Context(), clientInfoKey, info)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClientIP returns the resolved client IP stored by ClientIPResolver.Middleware.
+// It falls back to the peer address when the middleware did not run.
+func ClientIP(r *http.Request) string {
+	if info, ok := r.Context().Value(clientInfoKey).(ClientInfo); ok {
+		return info.IP
+	}
+	if ip := parseHost(r.RemoteAddr); ip != nil {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
+
+// ClientKey returns the rate limiting key stored by ClientIPResolver.Middleware
+func ClientKey(r *http.Request) string {
+	if info, ok := r.Context().Value(clientInfoKey).(ClientInfo); ok {
+		return info.Key
+	}
+	return ClientIP(r)
+}
+
+func (c *ClientIPResolver) isTrusted(ip net.IP) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, network := range c.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkChain walks a forwarding chain from the closest hop outwards.
+// Trusted proxies are skipped; the first untrusted hop is the client.
+// If a hop cannot be parsed the walk stops at the last valid address,
+// since anything beyond it may have been supplied by the client.
+func (c *ClientIPResolver) walkChain(chain []string, peer net.IP) net.IP {
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := parseHost(chain[i])
+		if ip == nil {
+			return client
+		}
+		client = ip
+		if !c.isTrusted(ip) {
+			return ip
+		}
+	}
+	return client
+}
+
+// parseForwarded extracts the "for" parameters of RFC 7239 Forwarded headers, in order
+func parseForwarded(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			node := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					node = strings.Trim(strings.TrimSpace(val), `"`)
+				}
+			}
+			chain = append(chain, node)
+		}
+	}
+	return chain
+}
+
+// parseHost parses an address that may carry a port and IPv6 brackets
+// ("1.2.3.4", "1.2.3.4:80", "[::1]:80", "[::1]", "::1")
+func parseHost(addr string) net.IP {
+	if addr == "" {
+		return nil
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	// Strip IPv6 zone, it is never meaningful for a remote client
+	if i := strings.IndexByte(addr, '%'); i >= 0 {
+		addr = addr[:i]
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
@@ -1,17 +1,69 @@
 package middleware
 
-import "github.com/go-chi/cors"
-
-func CORS() cors.Options {
-	return cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: true,
-		MaxAge:           300, // Maximum value not ignored by any of major browsers
-	}
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/go-chi/cors"
+)
+
+// CORSConfig is the cross-origin policy for the API
+type CORSConfig struct {
+	// AllowedOrigins lists origins allowed to call the API from a browser,
+	// e.g. "https://app.example.com" or "https://*.example.com".
+	// Empty means same-origin only.
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// AllowCredentials lets browsers send cookies and Authorization to allowed origins
+	AllowCredentials bool
+}
+
+// exposedHeaders are response headers the frontend may read cross-origin:
+// the filename chosen by the server, IDs for support requests and rate limit state
+var exposedHeaders = []string{
+	"Content-Disposition",
+	"X-Request-Id",
+	"X-Trace-Id",
+	"RateLimit-Policy",
+	"RateLimit-Limit",
+	"RateLimit-Remaining",
+	"RateLimit-Reset",
+	"Retry-After",
+}
+
+// CORS applies a cross-origin policy that can be replaced on config reload
+type CORS struct {
+	policy atomic.Pointer[cors.Cors]
 }
 
+// NewCORS creates the CORS middleware for cfg
+func NewCORS(cfg CORSConfig) *CORS {
+	c := &CORS{}
+	c.Update(cfg)
+	return c
+}
 
+// Update replaces the policy
+func (c *CORS) Update(cfg CORSConfig) {
+	options := cors.Options{
+		AllowedOrigins:   cfg.AllowedOrigins,
+		AllowedMethods:   cfg.AllowedMethods,
+		AllowedHeaders:   cfg.AllowedHeaders,
+		ExposedHeaders:   exposedHeaders,
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           300, // Maximum value not ignored by any of major browsers
+	}
+	if len(cfg.AllowedOrigins) == 0 {
+		// The cors package treats an empty list as "*"; reject every cross-origin caller instead
+		options.AllowOriginFunc = func(*http.Request, string) bool { return false }
+	}
+	c.policy.Store(cors.New(options))
+}
 
+// Handler applies the current policy to a request
+func (c *CORS) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.policy.Load().Handler(next).ServeHTTP(w, r)
+	})
+}
@@ -1,76 +1,136 @@
 package middleware
 
 import (
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
-	"golang.org/x/time/rate"
+	"viddown/metrics"
 )
 
-type visitor struct {
-	limiter  *rate.Limiter
-	lastSeen time.Time
+// RateLimit describes the allowance of a single route
+type RateLimit struct {
+	// RPM is the sustained number of requests per minute; 0 disables limiting
+	RPM int
+	// Burst is the bucket size; defaults to RPM when zero
+	Burst int
 }
 
+func (l RateLimit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.RPM
+}
+
+// RateLimiter keeps a separate allowance per route and identity,
+// so cheap requests (thumbnails, health checks) don't eat into the
+// allowance for expensive ones (downloads).
 type RateLimiter struct {
-	visitors map[string]*visitor
+	store    RateLimitStore
+	logger   *slog.Logger
 	mu       sync.RWMutex
-	rpm      int
+	limits   map[string]RateLimit
+	fallback RateLimit
 }
 
-func NewRateLimiter(rpm int) *RateLimiter {
-	rl := &RateLimiter{
-		visitors: make(map[string]*visitor),
-		rpm:      rpm,
+// NewRateLimiter creates a rate limiter backed by store.
+// Routes without an entry in limits use fallback.
+func NewRateLimiter(store RateLimitStore, fallback RateLimit, limits map[string]RateLimit, logger *slog.Logger) *RateLimiter {
+	return &RateLimiter{
+		store:    store,
+		logger:   logger,
+		limits:   limits,
+		fallback: fallback,
 	}
-	go rl.cleanupVisitors()
-	return rl
 }
 
-func (rl *RateLimiter) getVisitor(ip string) *rate.Limiter {
+// SetLimits replaces the route allowances, e.g. after a config reload.
+// Counters already held by the store are kept.
+func (rl *RateLimiter) SetLimits(fallback RateLimit, limits map[string]RateLimit) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
+	rl.fallback = fallback
+	rl.limits = limits
+}
 
-	v, exists := rl.visitors[ip]
-	if !exists {
-		limiter := rate.NewLimiter(rate.Every(time.Minute/time.Duration(rl.rpm)), rl.rpm)
-		rl.visitors[ip] = &visitor{limiter: limiter, lastSeen: time.Now()}
-		return limiter
+func (rl *RateLimiter) limitFor(route string) RateLimit {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	if limit, ok := rl.limits[route]; ok {
+		return limit
 	}
+	return rl.fallback
+}
 
-	v.lastSeen = time.Now()
-	return v.limiter
+// Close releases the underlying store
+func (rl *RateLimiter) Close() error {
+	return rl.store.Close()
 }
 
-func (rl *RateLimiter) cleanupVisitors() {
-	for {
-		time.Sleep(time.Minute)
-		rl.mu.Lock()
-		for ip, v := range rl.visitors {
-			if time.Since(v.lastSeen) > 3*time.Minute {
-				delete(rl.visitors, ip)
+// policy describes the allowance as "burst;w=seconds", the window in which
+// a full burst is earned back
+func (l RateLimit) policy() string {
+	window := int(math.Ceil(float64(l.burst()) * 60 / float64(l.RPM)))
+	return strconv.Itoa(l.burst()) + ";w=" + strconv.Itoa(window)
+}
+
+// Limit returns middleware that rate limits requests against the named route's allowance.
+// Requests are keyed by the authenticated user when present and the client IP otherwise.
+// Limited responses get RateLimit-Policy, RateLimit-Limit, RateLimit-Remaining
+// and RateLimit-Reset, and rejections Retry-After. Routes without an allowance,
+// and responses outside rate limited routes, carry no rate limit headers: there
+// is no limit or remaining count to report.
+func (rl *RateLimiter) Limit(route string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := rl.limitFor(route)
+			if limit.RPM <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Policy", limit.policy())
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.burst()))
+
+			result, err := rl.store.Allow(r.Context(), route+"|"+Identity(r), limit, 1)
+			if err != nil {
+				// Fail open: an unavailable store must not take the service down
+				rl.logger.Error("Rate limit store failed", "route", route, "error", err)
+				next.ServeHTTP(w, r)
+				return
 			}
-		}
-		rl.mu.Unlock()
+
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+
+			if !result.Allowed {
+				metrics.RateLimitRejections.WithLabelValues(route).Inc()
+				w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(result.RetryAfter))))
+				http.Error(w, `{"error": "Too many requests. Please wait a moment."}`, http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
 	}
 }
 
-func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr
-		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-			ip = forwarded
-		}
-
-		limiter := rl.getVisitor(ip)
-		if !limiter.Allow() {
-			http.Error(w, `{"error": "Too many requests. Please wait a moment."}`, http.StatusTooManyRequests)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
+// Identity returns the key a request is accounted under: the authenticated
+// user when there is one, otherwise the client IP (or its IPv6 network)
+func Identity(r *http.Request) string {
+	if user := UserFromContext(r.Context()); user != nil && user.ID != "" {
+		return "user:" + user.ID
+	}
+	return "ip:" + ClientKey(r)
 }
 
-
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
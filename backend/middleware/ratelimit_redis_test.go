@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedisStore(t *testing.T) (*RedisRateLimitStore, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	store := NewRedisRateLimitStore(redis.NewClient(&redis.Options{Addr: server.Addr()}), "test:")
+	t.Cleanup(func() { store.Close() })
+	return store, server
+}
+
+// TestRedisStoreMatchesMemoryStore replays the same requests through the Lua
+// script and the in-process GCRA and expects identical results at every step
+func TestRedisStoreMatchesMemoryStore(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit RateLimit
+		// steps are the delays before each request
+		steps []time.Duration
+	}{
+		{
+			name:  "burst then deny",
+			limit: RateLimit{RPM: 10, Burst: 3},
+			steps: []time.Duration{0, 0, 0, 0, 0},
+		},
+		{
+			name:  "refill after interval",
+			limit: RateLimit{RPM: 10, Burst: 2},
+			steps: []time.Duration{0, 0, 0, 3 * time.Second, 3 * time.Second, 0, time.Minute, 0},
+		},
+		{
+			name:  "burst defaults to rpm",
+			limit: RateLimit{RPM: 60},
+			steps: []time.Duration{0, 500 * time.Millisecond, 250 * time.Millisecond, 2 * time.Second, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, server := newTestRedisStore(t)
+			now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+			var tat time.Time
+
+			for i, step := range tt.steps {
+				now = now.Add(step)
+				server.SetTime(now)
+
+				got, err := store.Allow(context.Background(), "client", tt.limit, 1)
+				if err != nil {
+					t.Fatalf("request %d: %v", i, err)
+				}
+				want, next := gcra(now, tat, tt.limit, 1)
+				if want.Allowed {
+					tat = next
+				}
+
+				if got != want {
+					t.Errorf("request %d at +%s: redis %+v, memory %+v", i, now.Sub(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)), got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedisStoreAllowDenyRetryAfter(t *testing.T) {
+	store, server := newTestRedisStore(t)
+	limit := RateLimit{RPM: 6, Burst: 2}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	server.SetTime(now)
+
+	for i := range 2 {
+		result, err := store.Allow(context.Background(), "client", limit, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != 1-i {
+			t.Fatalf("request %d: got %+v, want allowed with %d remaining", i, result, 1-i)
+		}
+	}
+
+	result, err := store.Allow(context.Background(), "client", limit, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed || result.Remaining != 0 || result.RetryAfter != 10*time.Second || result.ResetAfter != 20*time.Second {
+		t.Fatalf("over the burst: got %+v, want denied, retry after 10s, reset after 20s", result)
+	}
+
+	// Other identities have their own bucket
+	if result, _ := store.Allow(context.Background(), "other", limit, 1); !result.Allowed {
+		t.Fatalf("other client: got %+v, want allowed", result)
+	}
+
+	server.SetTime(now.Add(10 * time.Second))
+	if result, _ := store.Allow(context.Background(), "client", limit, 1); !result.Allowed {
+		t.Fatalf("after retry-after: got %+v, want allowed", result)
+	}
+}
+
+func TestRedisStoreExpiresRefilledBuckets(t *testing.T) {
+	store, server := newTestRedisStore(t)
+	if _, err := store.Allow(context.Background(), "client", RateLimit{RPM: 60}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !server.Exists("test:client") {
+		t.Fatal("bucket was not stored under the prefixed key")
+	}
+
+	server.FastForward(time.Second)
+	if server.Exists("test:client") {
+		t.Error("bucket outlived its refill time")
+	}
+}
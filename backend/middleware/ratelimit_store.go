@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimitResult is the outcome of a single rate limit check
+type RateLimitResult struct {
+	Allowed bool
+	// Remaining is the number of requests that could still be made right now
+	Remaining int
+	// RetryAfter is how long to wait before the request would be allowed (zero when allowed)
+	RetryAfter time.Duration
+	// ResetAfter is how long until the full burst is available again
+	ResetAfter time.Duration
+}
+
+// RateLimitStore keeps rate limiter state. Implementations must be safe for
+// concurrent use; shared implementations (Redis) let several replicas
+// enforce a single allowance per identity.
+type RateLimitStore interface {
+	// Allow consumes cost tokens from the bucket identified by key
+	Allow(ctx context.Context, key string, limit RateLimit, cost int) (RateLimitResult, error)
+	// Close releases resources held by the store
+	Close() error
+}
+
+// gcra evaluates the generic cell rate algorithm.
+// tat is the theoretical arrival time stored for the key (zero when absent).
+// It returns the result and the new TAT to store when the request is allowed.
+func gcra(now, tat time.Time, limit RateLimit, cost int) (RateLimitResult, time.Time) {
+	interval := time.Minute / time.Duration(limit.RPM)
+	tolerance := interval * time.Duration(limit.burst())
+
+	if tat.Before(now) {
+		tat = now
+	}
+
+	newTat := tat.Add(interval * time.Duration(cost))
+	allowAt := newTat.Add(-tolerance)
+	if now.Before(allowAt) {
+		return RateLimitResult{
+			Allowed:    false,
+			Remaining:  max(0, int(now.Sub(tat.Add(-tolerance))/interval)),
+			RetryAfter: allowAt.Sub(now),
+			ResetAfter: tat.Sub(now),
+		}, tat
+	}
+
+	return RateLimitResult{
+		Allowed:    true,
+		Remaining:  max(0, int(now.Sub(allowAt)/interval)),
+		ResetAfter: newTat.Sub(now),
+	}, newTat
+}
+
+// MemoryRateLimitStore keeps limiter state in process memory.
+// State is lost on restart and is not shared between replicas.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]time.Time
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// NewMemoryRateLimitStore creates an in-memory store and starts its cleanup loop
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{
+		buckets: make(map[string]time.Time),
+		stop:    make(chan struct{}),
+	}
+	go s.cleanup(time.Minute)
+	return s
+}
+
+func (s *MemoryRateLimitStore) Allow(ctx context.Context, key string, limit RateLimit, cost int) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, tat := gcra(time.Now(), s.buckets[key], limit, cost)
+	if result.Allowed {
+		s.buckets[key] = tat
+	}
+	return result, nil
+}
+
+// Close stops the cleanup loop
+func (s *MemoryRateLimitStore) Close() error {
+	s.once.Do(func() { close(s.stop) })
+	return nil
+}
+
+// cleanup drops buckets that have fully refilled, they carry no state
+func (s *MemoryRateLimitStore) cleanup(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, tat := range s.buckets {
+				if tat.Before(now) {
+					delete(s.buckets, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
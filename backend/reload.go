@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"viddown/config"
+	"viddown/logging"
+	"viddown/middleware"
+	"viddown/services"
+)
+
+// configPath returns the config file named by -config or CONFIG_FILE
+func configPath(flags *flag.FlagSet, args []string) (string, error) {
+	path := flags.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	return *path, nil
+}
+
+// runConfigCommand implements "viddown config check [-config path]", which
+// validates the configuration the server would start with and exits
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: viddown config check [-config path]")
+		return 2
+	}
+
+	path, err := configPath(flag.NewFlagSet("config check", flag.ContinueOnError), args[1:])
+	if err != nil {
+		return 2
+	}
+
+	cfg, err := config.Load(path)
+	if err == nil {
+		err = services.NewPlatformRegistry(services.DefaultPlatforms()).CheckNames(cfg.EnabledPlatforms)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		return 1
+	}
+	fmt.Println("Configuration OK")
+	return 0
+}
+
+// rateLimits converts the configured allowances for the rate limiter
+func rateLimits(cfg *config.Config) (middleware.RateLimit, map[string]middleware.RateLimit) {
+	limits := make(map[string]middleware.RateLimit, len(cfg.RateLimits))
+	for route, limit := range cfg.RateLimits {
+		limits[route] = middleware.RateLimit{RPM: limit.RPM, Burst: limit.Burst}
+	}
+	return middleware.RateLimit{RPM: cfg.RateLimitRPM}, limits
+}
+
+// corsConfig converts the configured cross-origin policy
+func corsConfig(cfg *config.Config) middleware.CORSConfig {
+	return middleware.CORSConfig{
+		AllowedOrigins:   cfg.CORSAllowedOrigins,
+		AllowedMethods:   cfg.CORSAllowedMethods,
+		AllowedHeaders:   cfg.CORSAllowedHeaders,
+		AllowCredentials: cfg.CORSAllowCredentials,
+	}
+}
+
+// thumbnailHosts returns the domains the thumbnail proxy may fetch from: those
+// of the enabled platforms and the configured extra ones
+func thumbnailHosts(cfg *config.Config, platforms *services.PlatformRegistry) []string {
+	return append(platforms.ThumbnailHosts(), cfg.ThumbnailHosts...)
+}
+
+// liveConfig returns a copy of current with the settings that can change
+// without a restart taken from next
+func liveConfig(current, next *config.Config) *config.Config {
+	live := *current
+	live.MaxConcurrent = next.MaxConcurrent
+	live.MaxPerClient = next.MaxPerClient
+	live.MaxQueue = next.MaxQueue
+	live.RateLimitRPM = next.RateLimitRPM
+	live.RateLimits = next.RateLimits
+	live.LogLevel = next.LogLevel
+	live.TrustedProxies = next.TrustedProxies
+	live.TrustedProxyHeader = next.TrustedProxyHeader
+	live.IPv6Prefix = next.IPv6Prefix
+	live.CORSAllowedOrigins = next.CORSAllowedOrigins
+	live.CORSAllowedMethods = next.CORSAllowedMethods
+	live.CORSAllowedHeaders = next.CORSAllowedHeaders
+	live.CORSAllowCredentials = next.CORSAllowCredentials
+	live.EnabledPlatforms = next.EnabledPlatforms
+	live.ThumbnailHosts = next.ThumbnailHosts
+	live.DirectHosts = next.DirectHosts
+	return &live
+}
+
+// reloader re-reads the configuration on SIGHUP and applies it to running services
+type reloader struct {
+	path        string
+	holder      *config.Holder
+	rateLimiter *middleware.RateLimiter
+	clientIP    *middleware.ClientIPResolver
+	cors        *middleware.CORS
+	thumbnails  *services.SafeFetcher
+	platforms   *services.PlatformRegistry
+	direct      *services.SafeFetcher
+	semaphore   *services.Semaphore
+	queue       *services.DownloadQueue
+	logger      *slog.Logger
+}
+
+// reload applies a new configuration. An invalid configuration is
+// rejected as a whole and the running one is kept.
+func (r *reloader) reload() {
+	next, err := config.Load(r.path)
+	if err != nil {
+		r.logger.Error("Config reload failed, keeping current configuration", "error", err)
+		return
+	}
+
+	if err := r.platforms.CheckNames(next.EnabledPlatforms); err != nil {
+		r.logger.Error("Config reload failed, keeping current configuration", "error", err)
+		return
+	}
+	if err := r.clientIP.Update(next.TrustedProxies, next.TrustedProxyHeader, next.IPv6Prefix); err != nil {
+		r.logger.Error("Config reload failed, keeping current configuration", "error", err)
+		return
+	}
+
+	current := r.holder.Get()
+	live := liveConfig(current, next)
+
+	level, _ := logging.ParseLevel(live.LogLevel)
+	logging.Level.Set(level)
+	r.rateLimiter.SetLimits(rateLimits(live))
+	r.cors.Update(corsConfig(live))
+	r.platforms.SetEnabled(live.EnabledPlatforms)
+	r.thumbnails.SetAllowlist(thumbnailHosts(live, r.platforms))
+	r.platforms.SetDirectHosts(live.DirectHosts)
+	r.direct.SetAllowlist(live.DirectHosts)
+	r.semaphore.Resize(live.MaxConcurrent * services.WeightUnit)
+	r.queue.SetLimits(live.MaxPerClient, live.MaxQueue)
+	r.holder.Set(live)
+
+	r.logger.Info("Configuration reloaded", "changed", config.Diff(current, live))
+	if pending := config.Diff(live, next); len(pending) > 0 {
+		r.logger.Warn("Some settings only take effect after a restart", "settings", pending)
+	}
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CanonicalURL is the normalized form of a media URL: one video reached
+// through youtu.be, an embed or a share link gets the same URL and key
+type CanonicalURL struct {
+	Platform Platform
+	// ID is the platform's media ID, "" when the URL doesn't carry one
+	// (short links, direct files). For unlisted Vimeo videos it is ID/HASH.
+	ID string
+	// URL is the canonical URL with tracking parameters removed
+	URL string
+}
+
+// Key identifies the media for caching and deduplication; unlike URL it
+// ignores parameters like the start time that don't change the media
+func (c *CanonicalURL) Key() string {
+	if c.ID != "" {
+		return string(c.Platform) + ":" + c.ID
+	}
+	return c.URL
+}
+
+// trackingParams are query parameters added by ad and newsletter campaigns
+// to any link, besides utm_*
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "yclid": true, "mc_cid": true, "mc_eid": true,
+}
+
+// shareParams are query parameters added by the platforms' share buttons.
+// Elsewhere names like ref and _t can be meaningful, so only platform links drop them.
+var shareParams = map[string]bool{
+	"si": true, "feature": true, "pp": true, "ab_channel": true,
+	"igsh": true, "igshid": true, "img_index": true,
+	"_r": true, "_t": true, "is_from_webapp": true, "sender_device": true, "is_copy_url": true, "web_id": true,
+	"ref": true, "ref_src": true,
+}
+
+// youtubeTimestamp matches start times like 90, 90s and 1h2m3s
+var youtubeTimestamp = regexp.MustCompile(`^(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s?)?$`)
+
+// cleanURL returns the platform link u without a fragment, tracking and
+// share parameters, with the scheme and host lowercased
+func cleanURL(u *url.URL) string {
+	return stripQuery(u, shareParams)
+}
+
+// cleanDirectURL is like cleanURL for a link to a media file, keeping share
+// parameter names that may be part of a signed link
+func cleanDirectURL(u *url.URL) string {
+	return stripQuery(u, nil)
+}
+
+// canonicalDirect keeps a direct link as it is apart from tracking parameters
+func canonicalDirect(u *url.URL, id string) string {
+	return cleanDirectURL(u)
+}
+
+// stripQuery removes the fragment, tracking parameters and the parameters of
+// drop from u, with the scheme and host lowercased
+func stripQuery(u *url.URL, drop map[string]bool) string {
+	clean := *u
+	clean.Scheme = strings.ToLower(clean.Scheme)
+	clean.Host = strings.ToLower(clean.Host)
+	clean.Fragment, clean.RawFragment = "", ""
+
+	// Filtered in place: signed links may depend on the order of the rest
+	var kept []string
+	for _, pair := range strings.Split(clean.RawQuery, "&") {
+		name, _, _ := strings.Cut(pair, "=")
+		name, _ = url.QueryUnescape(name)
+		name = strings.ToLower(name)
+		if pair != "" && !trackingParams[name] && !drop[name] && !strings.HasPrefix(name, "utm_") {
+			kept = append(kept, pair)
+		}
+	}
+	clean.RawQuery = strings.Join(kept, "&")
+	return clean.String()
+}
+
+// canonicalYouTube returns www.youtube.com/watch?v=ID, keeping the start
+// time and playlist
+func canonicalYouTube(u *url.URL, id string) string {
+	if id == "" {
+		return cleanURL(u)
+	}
+
+	canonical := "https://www.youtube.com/watch?v=" + id
+	if list := u.Query().Get("list"); list != "" {
+		canonical += "&list=" + url.QueryEscape(list)
+	}
+	if seconds := youtubeStart(u); seconds > 0 {
+		canonical += "&t=" + strconv.Itoa(seconds)
+	}
+	return canonical
+}
+
+// canonicalYouTubeMusic returns music.youtube.com/watch?v=ID for tracks
+// and /playlist?list=ID for albums; browse pages are only cleaned
+func canonicalYouTubeMusic(u *url.URL, id string) string {
+	switch {
+	case id == "" || strings.HasPrefix(u.Path, "/browse/"):
+		return cleanURL(u)
+	case strings.HasPrefix(u.Path, "/playlist"):
+		return "https://music.youtube.com/playlist?list=" + url.QueryEscape(id)
+	default:
+		return "https://music.youtube.com/watch?v=" + id
+	}
+}
+
+// youtubeStart returns the start time of a YouTube link in seconds, from t,
+// start or a #t= fragment
+func youtubeStart(u *url.URL) int {
+	value := u.Query().Get("t")
+	if value == "" {
+		value = u.Query().Get("start")
+	}
+	if value == "" {
+		value, _ = strings.CutPrefix(u.Fragment, "t=")
+	}
+
+	parts := youtubeTimestamp.FindStringSubmatch(value)
+	if value == "" || parts == nil {
+		return 0
+	}
+	seconds := 0
+	for i, unit := range []int{3600, 60, 1} {
+		n, _ := strconv.Atoi(parts[i+1])
+		seconds += n * unit
+	}
+	return seconds
+}
+
+// canonicalInstagram returns www.instagram.com/p/CODE/; reels and posts
+// share their codes
+func canonicalInstagram(u *url.URL, id string) string {
+	if id == "" {
+		return cleanURL(u)
+	}
+	return "https://www.instagram.com/p/" + id + "/"
+}
+
+// canonicalTikTok returns www.tiktok.com/@user/video/ID for full links;
+// short links are only cleaned, their target is known after resolving
+func canonicalTikTok(u *url.URL, id string) string {
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if id == "" || len(segments) != 3 || !strings.HasPrefix(segments[0], "@") {
+		return cleanURL(u)
+	}
+	return "https://www.tiktok.com/" + segments[0] + "/video/" + id
+}
+
+// canonicalVimeo returns vimeo.com/ID, or vimeo.com/ID/HASH for an unlisted
+// video, whether its hash came in the path or as player.vimeo.com's ?h=
+func canonicalVimeo(u *url.URL, id string) string {
+	if id == "" {
+		return cleanURL(u)
+	}
+	if id, hash, ok := strings.Cut(id, "/"); ok {
+		return "https://vimeo.com/" + id + "/" + url.PathEscape(hash)
+	}
+	return "https://vimeo.com/" + id
+}
+
+// canonicalX returns x.com/i/status/ID; the account name in tweet links is
+// decorative and twitter.com links are the same tweets
+func canonicalX(u *url.URL, id string) string {
+	if id == "" {
+		return cleanURL(u)
+	}
+	return "https://x.com/i/status/" + id
+}
+
+// canonicalReddit returns www.reddit.com/comments/ID/ for posts; share
+// links are only cleaned, their target is known after resolving
+func canonicalReddit(u *url.URL, id string) string {
+	if id == "" {
+		return cleanURL(u)
+	}
+	return "https://www.reddit.com/comments/" + id + "/"
+}
+
+// canonicalSoundCloud returns soundcloud.com/artist/track without the
+// playlist context and share parameters tracks are linked with
+func canonicalSoundCloud(u *url.URL, id string) string {
+	if strings.ToLower(u.Hostname()) == "on.soundcloud.com" {
+		return cleanURL(u)
+	}
+	return "https://soundcloud.com/" + strings.Trim(u.Path, "/")
+}
+
+// canonicalTwitch returns www.twitch.tv/videos/ID for VODs; clips keep
+// their channel path
+func canonicalTwitch(u *url.URL, id string) string {
+	if id == "" || strings.Contains(u.Path, "/clip/") {
+		return cleanURL(u)
+	}
+	return "https://www.twitch.tv/videos/" + id
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+// newTestValidator recognizes every built-in platform, with direct links
+// on media.example.com
+func newTestValidator(t *testing.T) *Validator {
+	t.Helper()
+	platforms := NewPlatformRegistry(DefaultPlatforms())
+	if err := platforms.SetEnabled(platforms.ids()); err != nil {
+		t.Fatal(err)
+	}
+	platforms.SetDirectHosts([]string{"media.example.com"})
+	return NewValidator(2048, platforms)
+}
+
+func TestCanonicalize(t *testing.T) {
+	validator := newTestValidator(t)
+
+	tests := []struct {
+		name    string
+		url     string
+		want    string
+		wantKey string
+	}{
+		{"youtube watch", "https://www.youtube.com/watch?v=dQw4w9WgXcQ&feature=share&utm_source=x", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "youtube:dQw4w9WgXcQ"},
+		{"youtube short link", "https://youtu.be/dQw4w9WgXcQ?si=tracking&t=1m30s", "https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=90", "youtube:dQw4w9WgXcQ"},
+		{"youtube shorts", "https://youtube.com/shorts/dQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "youtube:dQw4w9WgXcQ"},
+		{"youtube music album", "https://music.youtube.com/playlist?list=OLAK5uy_abc&si=x", "https://music.youtube.com/playlist?list=OLAK5uy_abc", "youtube_music:OLAK5uy_abc"},
+		{"instagram reel", "https://www.instagram.com/reel/C1a2b3c4d5/?igsh=abc", "https://www.instagram.com/p/C1a2b3c4d5/", "instagram:C1a2b3c4d5"},
+		{"tiktok video", "https://www.tiktok.com/@user/video/7234567890123456789?is_from_webapp=1", "https://www.tiktok.com/@user/video/7234567890123456789", "tiktok:7234567890123456789"},
+		{"tiktok short link", "https://vm.tiktok.com/ZMabc123/?_t=8x", "https://vm.tiktok.com/ZMabc123/", "https://vm.tiktok.com/ZMabc123/"},
+		{"vimeo", "https://vimeo.com/123456?share=copy", "https://vimeo.com/123456", "vimeo:123456"},
+		{"vimeo player", "https://player.vimeo.com/video/123456", "https://vimeo.com/123456", "vimeo:123456"},
+		{"vimeo channel", "https://vimeo.com/channels/staffpicks/123456", "https://vimeo.com/123456", "vimeo:123456"},
+		{"vimeo unlisted", "https://vimeo.com/123456/abcdef", "https://vimeo.com/123456/abcdef", "vimeo:123456/abcdef"},
+		{"vimeo unlisted player", "https://player.vimeo.com/video/123456?h=abcdef", "https://vimeo.com/123456/abcdef", "vimeo:123456/abcdef"},
+		{"x", "https://twitter.com/someone/status/1234567890/video/1?s=20", "https://x.com/i/status/1234567890", "x:1234567890"},
+		{"reddit post", "https://www.reddit.com/r/videos/comments/abc123/title/?utm_source=share", "https://www.reddit.com/comments/abc123/", "reddit:abc123"},
+		{"reddit short link", "https://redd.it/abc123", "https://www.reddit.com/comments/abc123/", "reddit:abc123"},
+		{"soundcloud track", "https://soundcloud.com/artist/track?in=artist/sets/album&ref=clipboard", "https://soundcloud.com/artist/track", "https://soundcloud.com/artist/track"},
+		{"soundcloud private track", "https://soundcloud.com/artist/track/s-AbC123", "https://soundcloud.com/artist/track/s-AbC123", "https://soundcloud.com/artist/track/s-AbC123"},
+		{"soundcloud short link", "https://on.soundcloud.com/AbC123?ref=clipboard", "https://on.soundcloud.com/AbC123", "https://on.soundcloud.com/AbC123"},
+		{"twitch vod", "https://www.twitch.tv/streamer/v/123456789", "https://www.twitch.tv/videos/123456789", "twitch:123456789"},
+		{"direct link", "https://media.example.com/video.mp4?X-Amz-Signature=abc&ref=1&fbclid=x", "https://media.example.com/video.mp4?X-Amz-Signature=abc&ref=1", "https://media.example.com/video.mp4?X-Amz-Signature=abc&ref=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			canonical, err := validator.Canonicalize(tt.url)
+			if err != nil {
+				t.Fatalf("Canonicalize(%q): %v", tt.url, err)
+			}
+			if canonical.URL != tt.want {
+				t.Errorf("URL = %q, want %q", canonical.URL, tt.want)
+			}
+			if got := canonical.Key(); got != tt.wantKey {
+				t.Errorf("Key() = %q, want %q", got, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeRejects(t *testing.T) {
+	validator := newTestValidator(t)
+
+	tests := []struct {
+		name string
+		url  string
+		want error
+	}{
+		{"not a url", "dQw4w9WgXcQ", ErrInvalidURL},
+		{"ftp", "ftp://youtube.com/watch?v=dQw4w9WgXcQ", ErrInvalidURL},
+		{"unknown host", "https://example.com/video.mp4", ErrUnsupportedURL},
+		{"youtube channel", "https://www.youtube.com/@channel", ErrUnsupportedURL},
+		{"reddit bare code", "https://www.reddit.com/abc123", ErrUnsupportedURL},
+		{"soundcloud profile", "https://soundcloud.com/artist", ErrUnsupportedURL},
+		{"soundcloud playlists", "https://soundcloud.com/artist/sets", ErrUnsupportedURL},
+		{"soundcloud likes", "https://soundcloud.com/artist/likes/", ErrUnsupportedURL},
+		{"soundcloud popular tracks", "https://soundcloud.com/artist/popular-tracks", ErrUnsupportedURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := validator.Canonicalize(tt.url); !errors.Is(err, tt.want) {
+				t.Errorf("Canonicalize(%q) = %v, want %v", tt.url, err, tt.want)
+			}
+		})
+	}
+
+	platforms := NewPlatformRegistry(DefaultPlatforms())
+	if _, err := NewValidator(2048, platforms).Canonicalize("https://vimeo.com/123456"); !errors.Is(err, ErrUnsupportedURL) {
+		t.Errorf("Vimeo with default platforms: %v, want %v", err, ErrUnsupportedURL)
+	}
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"viddown/logging"
+	"viddown/tracing"
+)
+
+var (
+	ErrYtDlpUnavailable  = errors.New("yt-dlp could not be started")
+	ErrFFmpegUnavailable = errors.New("ffmpeg could not be started")
+)
+
+// secretFlags are yt-dlp options whose value must never be recorded
+var secretFlags = map[string]bool{
+	"--cookies":        true,
+	"--username":       true,
+	"--password":       true,
+	"--video-password": true,
+	"--add-header":     true,
+	"--twofactor":      true,
+}
+
+// invocation is a yt-dlp process together with its span and captured output
+type invocation struct {
+	cmd  *exec.Cmd
+	span trace.Span
+	log  *ProcessLog
+}
+
+// command creates a yt-dlp invocation with a span describing it and stderr
+// captured into the process log of the current request.
+// The caller calls finish once the process has exited.
+func (s *YtDlpService) command(ctx context.Context, operation string, args ...string) (context.Context, *invocation) {
+	redacted := redactArgs(args)
+	ctx, span := tracing.Start(ctx, "yt-dlp "+operation,
+		attribute.String("process.executable.path", s.ytdlpPath),
+		attribute.StringSlice("process.command_args", redacted),
+	)
+
+	cmd := exec.CommandContext(ctx, s.ytdlpPath, args...)
+	log := s.logs.Start(ctx, operation, redacted)
+	cmd.Stderr = log
+
+	return ctx, &invocation{cmd: cmd, span: span, log: log}
+}
+
+// cookieArgs returns the yt-dlp arguments for the cookie file at path, or
+// none when path is empty. yt-dlp writes the jar back on exit, so it gets a
+// private copy: the configured file stays untouched and concurrent runs don't
+// overwrite each other. The caller calls cleanup once yt-dlp has exited.
+func cookieArgs(path string) (args []string, cleanup func(), err error) {
+	if path == "" {
+		return nil, func() {}, nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read cookie file: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp("", "cookies-*.txt")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup = func() { os.Remove(dst.Name()) }
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to copy cookie file: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return []string{"--cookies", dst.Name()}, cleanup, nil
+}
+
+// startError marks err with unavailable when cmd never started, telling a
+// missing or unrunnable binary apart from a failed run or a missing input file
+func startError(cmd *exec.Cmd, err, unavailable error) error {
+	if cmd.Process == nil {
+		return fmt.Errorf("%w: %w", unavailable, err)
+	}
+	return err
+}
+
+func (inv *invocation) finish(err error) {
+	tracing.End(inv.span, err)
+	inv.log.finish(err)
+}
+
+// summary is the tail of the captured output, attached to errors
+func (inv *invocation) summary() string {
+	return inv.log.Tail(5)
+}
+
+// redactArgs hides secrets in command arguments: values of credential flags
+// and URL query parameters, which often carry signatures or tokens
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		switch {
+		case i > 0 && secretFlags[args[i-1]]:
+			redacted[i] = logging.Redacted
+		default:
+			redacted[i] = logging.RedactURL(arg)
+		}
+	}
+	return redacted
+}
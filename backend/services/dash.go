@@ -0,0 +1,377 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxManifestSegments guards against templates expanding to absurd segment counts
+const maxManifestSegments = 100000
+
+type mpdDocument struct {
+	Type     string      `xml:"type,attr"`
+	Duration string      `xml:"mediaPresentationDuration,attr"`
+	BaseURL  []string    `xml:"BaseURL"`
+	Periods  []mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	Duration       string             `xml:"duration,attr"`
+	BaseURL        []string           `xml:"BaseURL"`
+	AdaptationSets []mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	MimeType          string              `xml:"mimeType,attr"`
+	ContentType       string              `xml:"contentType,attr"`
+	Codecs            string              `xml:"codecs,attr"`
+	Lang              string              `xml:"lang,attr"`
+	BaseURL           []string            `xml:"BaseURL"`
+	ContentProtection []struct{}          `xml:"ContentProtection"`
+	SegmentTemplate   *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList       *mpdSegmentList     `xml:"SegmentList"`
+	Representations   []mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID                string              `xml:"id,attr"`
+	Bandwidth         int                 `xml:"bandwidth,attr"`
+	Height            int                 `xml:"height,attr"`
+	MimeType          string              `xml:"mimeType,attr"`
+	Codecs            string              `xml:"codecs,attr"`
+	BaseURL           []string            `xml:"BaseURL"`
+	ContentProtection []struct{}          `xml:"ContentProtection"`
+	SegmentTemplate   *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList       *mpdSegmentList     `xml:"SegmentList"`
+}
+
+type mpdSegmentTemplate struct {
+	Media          string        `xml:"media,attr"`
+	Initialization string        `xml:"initialization,attr"`
+	StartNumber    *int64        `xml:"startNumber,attr"`
+	Timescale      int64         `xml:"timescale,attr"`
+	Duration       int64         `xml:"duration,attr"`
+	Timeline       []mpdTimeline `xml:"SegmentTimeline>S"`
+}
+
+type mpdTimeline struct {
+	T *int64 `xml:"t,attr"`
+	D int64  `xml:"d,attr"`
+	R int64  `xml:"r,attr"`
+}
+
+type mpdSegmentList struct {
+	Initialization *struct {
+		SourceURL string `xml:"sourceURL,attr"`
+		Range     string `xml:"range,attr"`
+	} `xml:"Initialization"`
+	SegmentURLs []struct {
+		Media      string `xml:"media,attr"`
+		MediaRange string `xml:"mediaRange,attr"`
+	} `xml:"SegmentURL"`
+}
+
+// templatePattern matches $Identifier$ and $Identifier%0Nd$ in segment templates
+var templatePattern = regexp.MustCompile(`\$(RepresentationID|Number|Bandwidth|Time|)(?:%0(\d+)d)?\$`)
+
+// isoDurationPattern matches the xs:duration values used by MPDs, e.g. PT1H2M3.5S
+var isoDurationPattern = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:([\d.]+)S)?)?$`)
+
+// parseDASH parses a static single-period MPD into a track per video and
+// audio representation, with segment URLs fully expanded
+func parseDASH(base *url.URL, body []byte) (*manifest, error) {
+	var doc mpdDocument
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotManifest, err)
+	}
+	if doc.Type == "dynamic" {
+		return nil, ErrLiveManifest
+	}
+	if len(doc.Periods) != 1 {
+		return nil, fmt.Errorf("%w: %d periods, only single-period MPDs are supported", ErrUnsupportedManifest, len(doc.Periods))
+	}
+	period := doc.Periods[0]
+
+	duration := parseISODuration(doc.Duration)
+	if periodDuration := parseISODuration(period.Duration); periodDuration > 0 {
+		duration = periodDuration
+	}
+
+	periodBase, err := resolveBase(base, doc.BaseURL, period.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &manifest{duration: duration}
+	videos, audios, protected := 0, 0, false
+	for _, set := range period.AdaptationSets {
+		setBase, err := resolveBase(periodBase, set.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+		for _, rep := range set.Representations {
+			if len(set.ContentProtection) > 0 || len(rep.ContentProtection) > 0 {
+				protected = true
+				continue
+			}
+
+			mimeType := firstNonEmpty(rep.MimeType, set.MimeType)
+			codecs := firstNonEmpty(rep.Codecs, set.Codecs)
+			track := &manifestTrack{bandwidth: rep.Bandwidth, height: rep.Height, name: set.Lang}
+			switch {
+			case strings.HasPrefix(mimeType, "video/") || set.ContentType == "video":
+				track.id = fmt.Sprintf("v%d", videos)
+				track.kind = "video_only"
+				if strings.Contains(codecs, ",") {
+					// Muxed audio and video in one representation
+					track.kind = "video"
+				}
+				videos++
+			case strings.HasPrefix(mimeType, "audio/") || set.ContentType == "audio":
+				track.id = fmt.Sprintf("a%d", audios)
+				track.kind = "audio"
+				audios++
+			default:
+				// Subtitles, thumbnails
+				continue
+			}
+
+			repBase, err := resolveBase(setBase, rep.BaseURL)
+			if err != nil {
+				return nil, err
+			}
+			template := mergeTemplates(set.SegmentTemplate, rep.SegmentTemplate)
+			list := rep.SegmentList
+			if list == nil {
+				list = set.SegmentList
+			}
+			if track.segments, err = dashSegments(repBase, rep, template, list, duration); err != nil {
+				return nil, err
+			}
+			m.tracks = append(m.tracks, track)
+		}
+	}
+
+	if len(m.tracks) == 0 && protected {
+		return nil, fmt.Errorf("%w: DRM-protected content", ErrUnsupportedManifest)
+	}
+	return m, nil
+}
+
+// dashSegments expands the segments of a representation, initialization first
+func dashSegments(base *url.URL, rep mpdRepresentation, template *mpdSegmentTemplate, list *mpdSegmentList, duration float64) ([]manifestSegment, error) {
+	switch {
+	case template != nil:
+		return templateSegments(base, rep, template, duration)
+	case list != nil:
+		var segments []manifestSegment
+		if list.Initialization != nil {
+			segment, err := listSegment(base, list.Initialization.SourceURL, list.Initialization.Range)
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, segment)
+		}
+		for _, entry := range list.SegmentURLs {
+			segment, err := listSegment(base, entry.Media, entry.MediaRange)
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, segment)
+		}
+		return segments, nil
+	default:
+		// SegmentBase or a bare BaseURL: the representation is a single file
+		return []manifestSegment{{url: base.String()}}, nil
+	}
+}
+
+func templateSegments(base *url.URL, rep mpdRepresentation, template *mpdSegmentTemplate, duration float64) ([]manifestSegment, error) {
+	expand := func(pattern string, number, time int64) (string, error) {
+		path := templatePattern.ReplaceAllStringFunc(pattern, func(match string) string {
+			parts := templatePattern.FindStringSubmatch(match)
+			var value string
+			switch parts[1] {
+			case "":
+				return "$"
+			case "RepresentationID":
+				return rep.ID
+			case "Bandwidth":
+				value = strconv.Itoa(rep.Bandwidth)
+			case "Number":
+				value = strconv.FormatInt(number, 10)
+			case "Time":
+				value = strconv.FormatInt(time, 10)
+			}
+			if width, err := strconv.Atoi(parts[2]); err == nil && len(value) < width {
+				value = strings.Repeat("0", width-len(value)) + value
+			}
+			return value
+		})
+		return resolveReference(base, path)
+	}
+
+	var segments []manifestSegment
+	if template.Initialization != "" {
+		initURL, err := expand(template.Initialization, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, manifestSegment{url: initURL})
+	}
+	if template.Media == "" {
+		return nil, fmt.Errorf("%w: segment template without media", ErrUnsupportedManifest)
+	}
+
+	timescale := max(template.Timescale, 1)
+	number := int64(1)
+	if template.StartNumber != nil {
+		number = *template.StartNumber
+	}
+	add := func(time int64) error {
+		if len(segments) > maxManifestSegments {
+			return fmt.Errorf("%w: too many segments", ErrUnsupportedManifest)
+		}
+		segmentURL, err := expand(template.Media, number, time)
+		if err != nil {
+			return err
+		}
+		segments = append(segments, manifestSegment{url: segmentURL})
+		number++
+		return nil
+	}
+
+	switch {
+	case len(template.Timeline) > 0:
+		var time int64
+		end := int64(duration * float64(timescale))
+		for _, entry := range template.Timeline {
+			if entry.T != nil {
+				time = *entry.T
+			}
+			if entry.D <= 0 {
+				return nil, fmt.Errorf("%w: segment timeline without duration", ErrUnsupportedManifest)
+			}
+			repeat := entry.R
+			if repeat < 0 {
+				// Repeat until the end of the period
+				repeat = int64(math.Ceil(float64(end-time)/float64(entry.D))) - 1
+			}
+			for i := int64(0); i <= repeat; i++ {
+				if err := add(time); err != nil {
+					return nil, err
+				}
+				time += entry.D
+			}
+		}
+	case template.Duration > 0:
+		if duration <= 0 {
+			return nil, fmt.Errorf("%w: unknown presentation duration", ErrUnsupportedManifest)
+		}
+		count := int64(math.Ceil(duration * float64(timescale) / float64(template.Duration)))
+		for i := int64(0); i < count; i++ {
+			if err := add(i * template.Duration); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w: segment template without timeline or duration", ErrUnsupportedManifest)
+	}
+	return segments, nil
+}
+
+// listSegment builds a SegmentList entry; an empty reference is the representation's own URL
+func listSegment(base *url.URL, ref, byteRange string) (manifestSegment, error) {
+	segment := manifestSegment{url: base.String()}
+	if ref != "" {
+		segmentURL, err := resolveReference(base, ref)
+		if err != nil {
+			return segment, err
+		}
+		segment.url = segmentURL
+	}
+	if byteRange != "" {
+		first, last, ok := strings.Cut(byteRange, "-")
+		start, err1 := strconv.ParseInt(first, 10, 64)
+		end, err2 := strconv.ParseInt(last, 10, 64)
+		if !ok || err1 != nil || err2 != nil || end < start {
+			return segment, fmt.Errorf("%w: byte range %q", ErrUnsupportedManifest, byteRange)
+		}
+		segment.offset, segment.length = start, end-start+1
+	}
+	return segment, nil
+}
+
+// mergeTemplates lets a representation's template override its adaptation set's
+func mergeTemplates(set, rep *mpdSegmentTemplate) *mpdSegmentTemplate {
+	if set == nil || rep == nil {
+		if rep != nil {
+			return rep
+		}
+		return set
+	}
+	merged := *set
+	if rep.Media != "" {
+		merged.Media = rep.Media
+	}
+	if rep.Initialization != "" {
+		merged.Initialization = rep.Initialization
+	}
+	if rep.StartNumber != nil {
+		merged.StartNumber = rep.StartNumber
+	}
+	if rep.Timescale > 0 {
+		merged.Timescale = rep.Timescale
+	}
+	if rep.Duration > 0 {
+		merged.Duration = rep.Duration
+	}
+	if len(rep.Timeline) > 0 {
+		merged.Timeline = rep.Timeline
+	}
+	return &merged
+}
+
+// resolveBase applies the first BaseURL of each level in turn
+func resolveBase(base *url.URL, levels ...[]string) (*url.URL, error) {
+	for _, level := range levels {
+		if len(level) == 0 || strings.TrimSpace(level[0]) == "" {
+			continue
+		}
+		next, err := base.Parse(strings.TrimSpace(level[0]))
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad BaseURL %q", ErrUnsupportedManifest, level[0])
+		}
+		base = next
+	}
+	return base, nil
+}
+
+// parseISODuration returns the seconds of an xs:duration, or 0 when it can't be parsed
+func parseISODuration(value string) float64 {
+	parts := isoDurationPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if parts == nil {
+		return 0
+	}
+	var seconds float64
+	for i, unit := range []float64{24 * 3600, 3600, 60, 1} {
+		if n, err := strconv.ParseFloat(parts[i+1], 64); err == nil {
+			seconds += n * unit
+		}
+	}
+	return seconds
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"viddown/metrics"
+)
+
+var (
+	ErrNotMedia         = errors.New("URL is not a media file")
+	ErrMediaUnavailable = errors.New("media unavailable")
+)
+
+// directMediaTypes maps file extensions to the media type served for them,
+// for servers that send a generic application/octet-stream
+var directMediaTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+	".avi":  "video/x-msvideo",
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/opus",
+	".flac": "audio/flac",
+	".wav":  "audio/wav",
+}
+
+// directFormatID is the only format a direct link offers
+const directFormatID = "direct"
+
+// DirectService downloads plain media files (.mp4, .mp3, ...) without yt-dlp.
+// Files are probed with HEAD and streamed through as they are, with Range
+// support, from hosts allowed by its SafeFetcher only.
+// HLS and DASH manifests are handed to manifests.
+type DirectService struct {
+	fetcher   *SafeFetcher
+	manifests *ManifestService
+}
+
+// NewDirectService creates a backend for direct links fetched through fetcher
+func NewDirectService(fetcher *SafeFetcher, manifests *ManifestService) *DirectService {
+	return &DirectService{fetcher: fetcher, manifests: manifests}
+}
+
+// directMedia is what a probe or response tells about a media file
+type directMedia struct {
+	contentType string
+	ext         string
+	// size is the full size of the file, -1 when unknown
+	size     int64
+	filename string
+	// manifest is true for HLS playlists and DASH MPDs
+	manifest bool
+}
+
+func (s *DirectService) Analyze(ctx context.Context, rawURL string) (*VideoInfo, error) {
+	info, err := s.analyze(ctx, rawURL)
+	metrics.ObserveOperation("analyze", string(PlatformDirect), ClassifyError(err))
+	return info, err
+}
+
+func (s *DirectService) analyze(ctx context.Context, rawURL string) (*VideoInfo, error) {
+	if IsManifestURL(rawURL) {
+		return s.manifests.Analyze(ctx, rawURL)
+	}
+	media, err := s.probe(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if media.manifest {
+		return s.manifests.Analyze(ctx, rawURL)
+	}
+
+	formatType := "video"
+	if strings.HasPrefix(media.contentType, "audio/") {
+		formatType = "audio"
+	}
+	format := Format{
+		ID:      directFormatID,
+		Type:    formatType,
+		Quality: "original",
+		Ext:     strings.TrimPrefix(media.ext, "."),
+	}
+	if media.size > 0 {
+		format.Size = media.size
+	}
+
+	return &VideoInfo{
+		ID:       hashBytes([]byte(rawURL))[:16],
+		Platform: PlatformDirect,
+		Title:    strings.TrimSuffix(media.filename, media.ext),
+		Formats:  []Format{format},
+	}, nil
+}
+
+// probe reads type and size of the file at rawURL with a HEAD request, falling
+// back to a one-byte range request for servers that don't answer HEAD
+func (s *DirectService) probe(ctx context.Context, rawURL string) (*directMedia, error) {
+	resp, err := s.fetcher.Do(ctx, http.MethodHead, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			return nil, fmt.Errorf("%w: upstream returned %s", ErrMediaUnavailable, resp.Status)
+		}
+		header := http.Header{}
+		header.Set("Range", "bytes=0-0")
+		resp, err = s.fetcher.Get(ctx, rawURL, header)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			return nil, fmt.Errorf("%w: upstream returned %s", ErrMediaUnavailable, resp.Status)
+		}
+	}
+
+	return s.inspect(resp)
+}
+
+// inspect applies the media policy to an upstream response
+func (s *DirectService) inspect(resp *http.Response) (*directMedia, error) {
+	declared, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if manifestTypes[declared] {
+		return &directMedia{manifest: true}, nil
+	}
+
+	media := &directMedia{size: resp.ContentLength}
+	if resp.StatusCode == http.StatusPartialContent {
+		media.size = contentRangeSize(resp.Header.Get("Content-Range"))
+	}
+	if media.size > s.fetcher.MaxBytes() {
+		return nil, ErrResponseTooBig
+	}
+
+	media.filename = responseFilename(resp)
+	media.ext = strings.ToLower(path.Ext(media.filename))
+
+	switch {
+	case strings.HasPrefix(declared, "video/") || strings.HasPrefix(declared, "audio/"):
+		media.contentType = declared
+	case declared == "" || declared == "application/octet-stream" || declared == "binary/octet-stream":
+		// Generic types are trusted only for known media extensions
+		contentType, ok := directMediaTypes[media.ext]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNotMedia, resp.Header.Get("Content-Type"))
+		}
+		media.contentType = contentType
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrNotMedia, declared)
+	}
+
+	if _, ok := directMediaTypes[media.ext]; !ok {
+		if exts, _ := mime.ExtensionsByType(media.contentType); len(exts) > 0 {
+			media.ext = exts[0]
+		} else if strings.HasPrefix(media.contentType, "audio/") {
+			media.ext = ".mp3"
+		} else {
+			media.ext = ".mp4"
+		}
+		media.filename += media.ext
+	}
+	return media, nil
+}
+
+func (s *DirectService) BestFormats(info *VideoInfo) []Format {
+	return info.Formats
+}
+
+func (s *DirectService) Identify(url string) (Platform, string) {
+	return PlatformDirect, ""
+}
+
+// Canonicalize drops the fragment and tracking parameters of url; other
+// parameters may be part of a signed link and are kept
+func (s *DirectService) Canonicalize(rawURL string) (*CanonicalURL, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return &CanonicalURL{Platform: PlatformUnknown}, ErrInvalidURL
+	}
+	return &CanonicalURL{Platform: PlatformDirect, URL: cleanDirectURL(parsed)}, nil
+}
+
+// EstimateWork returns the cheapest work: files are relayed and manifests
+// only remuxed, never transcoded
+func (s *DirectService) EstimateWork(url, formatID string, isAudioOnly bool) DownloadWork {
+	return DownloadWork{Merge: strings.Contains(formatID, "+")}
+}
+
+// Stream relays the file at rawURL, forwarding Range and If-Range from header.
+// Manifests can't be relayed and fail with ErrNotStreamable.
+func (s *DirectService) Stream(ctx context.Context, rawURL, formatID string, header http.Header) (*MediaStream, error) {
+	if IsManifestURL(rawURL) || (formatID != directFormatID && formatID != "best") {
+		return nil, ErrNotStreamable
+	}
+
+	upstream := http.Header{}
+	for _, name := range []string{"Range", "If-Range"} {
+		if value := header.Get(name); value != "" {
+			upstream.Set(name, value)
+		}
+	}
+
+	resp, err := s.fetcher.Get(ctx, rawURL, upstream)
+	if err != nil {
+		metrics.ObserveOperation("download", string(PlatformDirect), ClassifyError(err))
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return &MediaStream{
+			Body:   http.NoBody,
+			Status: resp.StatusCode,
+			Header: http.Header{"Content-Range": resp.Header.Values("Content-Range")},
+		}, nil
+	default:
+		resp.Body.Close()
+		err := fmt.Errorf("%w: upstream returned %s", ErrMediaUnavailable, resp.Status)
+		metrics.ObserveOperation("download", string(PlatformDirect), ClassifyError(err))
+		return nil, err
+	}
+
+	media, err := s.inspect(resp)
+	if err == nil && media.manifest {
+		resp.Body.Close()
+		return nil, ErrNotStreamable
+	}
+	metrics.ObserveOperation("download", string(PlatformDirect), ClassifyError(err))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	relayed := http.Header{}
+	relayed.Set("Content-Type", media.contentType)
+	if resp.ContentLength >= 0 {
+		relayed.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	for _, name := range []string{"Content-Range", "Accept-Ranges", "ETag", "Last-Modified"} {
+		if value := resp.Header.Get(name); value != "" {
+			relayed.Set(name, value)
+		}
+	}
+
+	return &MediaStream{
+		Body:     resp.Body,
+		Status:   resp.StatusCode,
+		Header:   relayed,
+		Filename: media.filename,
+	}, nil
+}
+
+// DownloadToFile saves the file at rawURL into tempDir, or downloads and
+// remuxes the manifest at rawURL
+func (s *DirectService) DownloadToFile(ctx context.Context, rawURL, formatID, tempDir string, isAudioOnly bool) (string, string, error) {
+	stream, err := s.Stream(ctx, rawURL, formatID, nil)
+	if errors.Is(err, ErrNotStreamable) {
+		filePath, filename, err := s.manifests.DownloadToFile(ctx, rawURL, formatID, tempDir, isAudioOnly)
+		metrics.ObserveOperation("download", string(PlatformDirect), ClassifyError(err))
+		return filePath, filename, err
+	}
+	if err != nil {
+		return "", "", err
+	}
+	defer stream.Body.Close()
+
+	file, err := os.CreateTemp(tempDir, "direct-*"+path.Ext(stream.Filename))
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := io.Copy(file, stream.Body); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", "", err
+	}
+	return file.Name(), stream.Filename, nil
+}
+
+// responseFilename takes the filename from Content-Disposition, or else the
+// last segment of the final URL path
+func responseFilename(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := path.Base(strings.ReplaceAll(params["filename"], `\`, "/")); name != "" && name != "." && name != "/" {
+			return name
+		}
+	}
+	if resp.Request != nil {
+		if name, err := url.PathUnescape(path.Base(resp.Request.URL.Path)); err == nil && name != "." && name != "/" {
+			return name
+		}
+	}
+	return "download"
+}
+
+// contentRangeSize returns the complete length from "bytes 0-0/1234", or -1
+func contentRangeSize(value string) int64 {
+	_, total, ok := strings.Cut(value, "/")
+	if !ok {
+		return -1
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+)
+
+// errorCauses maps yt-dlp error output to a stable cause label.
+// The first matching entry wins, so more specific messages come first.
+var errorCauses = []struct {
+	cause    string
+	patterns []string
+}{
+	{"bot_check", []string{"confirm you're not a bot", "confirm you’re not a bot"}},
+	{"age_restricted", []string{"confirm your age", "age-restricted", "inappropriate for some users"}},
+	{"private", []string{"private video", "this video is private", "login required", "requires authentication", "restricted video", "you need to log in", "use --cookies"}},
+	{"geo_blocked", []string{"not available in your country", "geo restriction", "geo-restricted"}},
+	{"rate_limited", []string{"http error 429", "too many requests"}},
+	{"live", []string{"live event will begin", "is live", "premieres in"}},
+	{"unavailable", []string{"video unavailable", "this video is unavailable", "has been removed", "does not exist", "http error 404"}},
+	{"format_unavailable", []string{"requested format is not available"}},
+	{"unsupported", []string{"unsupported url"}},
+	{"network", []string{"unable to download webpage", "connection reset", "timed out", "name or service not known"}},
+	{"ffmpeg", []string{"ffmpeg", "postprocessing"}},
+}
+
+// ClassifyError reduces an analyze or download error to a short cause,
+// suitable for metrics labels and alerting. It returns "" for nil.
+func ClassifyError(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, ErrInvalidURL), errors.Is(err, ErrURLTooLong):
+		return "invalid_url"
+	case errors.Is(err, ErrUnsupportedURL):
+		return "unsupported_platform"
+	case errors.Is(err, ErrLiveManifest):
+		return "live"
+	case errors.Is(err, ErrNotManifest), errors.Is(err, ErrUnsupportedManifest):
+		return "unsupported"
+	case errors.Is(err, ErrNotMedia):
+		return "not_media"
+	case errors.Is(err, ErrMediaUnavailable):
+		return "unavailable"
+	case errors.Is(err, ErrResponseTooBig):
+		return "too_large"
+	case errors.Is(err, ErrInsecureScheme), errors.Is(err, ErrHostNotAllowed), errors.Is(err, ErrBlockedAddress):
+		return "blocked"
+	case errors.Is(err, ErrYtDlpUnavailable):
+		return "ytdlp_missing"
+	case errors.Is(err, ErrFFmpegUnavailable):
+		return "ffmpeg_missing"
+	case errors.Is(err, fs.ErrNotExist):
+		// A file of ours went missing: the cookie jar or a download output
+		return "internal"
+	}
+
+	message := strings.ToLower(err.Error())
+	for _, entry := range errorCauses {
+		for _, pattern := range entry.patterns {
+			if strings.Contains(message, pattern) {
+				return entry.cause
+			}
+		}
+	}
+	return "unknown"
+}
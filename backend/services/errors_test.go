@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failingYtDlp writes a script standing in for yt-dlp that exits with message
+func failingYtDlp(t *testing.T, message string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "yt-dlp")
+	script := "#!/bin/sh\necho \"ERROR: " + message + "\" >&2\nexit 1\n"
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestClassifyError(t *testing.T) {
+	logs := NewProcessLogStore(10, 64*1024)
+	missing := filepath.Join(t.TempDir(), "missing")
+	ctx := context.Background()
+
+	_, ytdlpMissing := NewYtDlpService(missing, nil, logs).extractInfo(ctx, "https://vimeo.com/123456")
+	ytdlpFailed := NewYtDlpService(failingYtDlp(t, "Private video. Sign in if you've been granted access"), nil, logs).
+		runDownload(ctx, "https://vimeo.com/123456")
+	_, _, cookiesMissing := cookieArgs(missing)
+	ffmpegMissing := NewManifestService(clientFetcher{}, missing, 1, 1<<20, logs).
+		remux(ctx, []string{filepath.Join(t.TempDir(), "video.ts")}, filepath.Join(t.TempDir(), "video.mp4"), false)
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"no error", nil, ""},
+		{"yt-dlp not installed", ytdlpMissing, "ytdlp_missing"},
+		{"yt-dlp reported an error", ytdlpFailed, "private"},
+		{"ffmpeg not installed", ffmpegMissing, "ffmpeg_missing"},
+		{"cookie file missing", cookiesMissing, "internal"},
+		{"canceled", context.Canceled, "canceled"},
+		{"unsupported platform", ErrUnsupportedURL, "unsupported_platform"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyError(tt.err); got != tt.want {
+				t.Errorf("ClassifyError(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	"viddown/metrics"
+)
+
+// Extractor turns a media URL into video information and download choices
+type Extractor interface {
+	// Analyze fetches title, duration and available formats of url
+	Analyze(ctx context.Context, url string) (*VideoInfo, error)
+	// BestFormats reduces the formats of an analyzed video to the choices offered to users
+	BestFormats(info *VideoInfo) []Format
+	// Identify returns the platform of url and, when it was analyzed recently, its video ID
+	Identify(url string) (Platform, string)
+	// Canonicalize returns the canonical form of url, which identifies the
+	// media in logs, caches and audit records
+	Canonicalize(url string) (*CanonicalURL, error)
+}
+
+// Downloader fetches media to local files
+type Downloader interface {
+	// EstimateWork predicts how expensive a download is, to weight its queue slots
+	EstimateWork(url, formatID string, isAudioOnly bool) DownloadWork
+	// DownloadToFile downloads formatID of url into tempDir and returns the
+	// file path and the filename to offer the client
+	DownloadToFile(ctx context.Context, url, formatID, tempDir string, isAudioOnly bool) (filePath string, filename string, err error)
+}
+
+// ErrNotStreamable is returned by Stream when the backend has to download to a file first
+var ErrNotStreamable = errors.New("backend does not support streaming")
+
+// MediaStream is an upstream response relayed to the client as-is
+type MediaStream struct {
+	Body io.ReadCloser
+	// Status is 200, 206 for a range or 416 for an unsatisfiable range
+	Status int
+	// Header holds the entity headers to relay (Content-Type, Content-Length,
+	// Content-Range, Accept-Ranges, ETag, Last-Modified)
+	Header   http.Header
+	Filename string
+}
+
+// Streamer is implemented by downloaders that can pass media through without
+// a temporary file. Range and If-Range in header are forwarded upstream.
+type Streamer interface {
+	Stream(ctx context.Context, url, formatID string, header http.Header) (*MediaStream, error)
+}
+
+// Backend is a source of media that can be both analyzed and downloaded
+type Backend interface {
+	Extractor
+	Downloader
+}
+
+// Registry routes each URL to the backend registered for its platform.
+// It is itself a Backend, so handlers don't need to know which backends exist.
+type Registry struct {
+	validator *Validator
+
+	mu       sync.RWMutex
+	backends map[Platform]Backend
+}
+
+// NewRegistry creates an empty registry; URLs are matched to platforms by validator
+func NewRegistry(validator *Validator) *Registry {
+	return &Registry{
+		validator: validator,
+		backends:  make(map[Platform]Backend),
+	}
+}
+
+// Register makes backend handle the given platforms, replacing any earlier registration
+func (r *Registry) Register(backend Backend, platforms ...Platform) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, platform := range platforms {
+		r.backends[platform] = backend
+	}
+}
+
+// Resolve returns the platform of url and the backend handling it
+func (r *Registry) Resolve(url string) (Platform, Backend, error) {
+	canonical, backend, err := r.resolve(url)
+	return canonical.Platform, backend, err
+}
+
+// resolve returns the canonical form of url, which backends are given in
+// place of url, and the backend handling it
+func (r *Registry) resolve(url string) (*CanonicalURL, Backend, error) {
+	canonical, err := r.validator.Canonicalize(url)
+	if err != nil {
+		return canonical, nil, err
+	}
+
+	r.mu.RLock()
+	backend, ok := r.backends[canonical.Platform]
+	r.mu.RUnlock()
+	if !ok {
+		return canonical, nil, ErrUnsupportedURL
+	}
+	return canonical, backend, nil
+}
+
+func (r *Registry) Analyze(ctx context.Context, url string) (*VideoInfo, error) {
+	canonical, backend, err := r.resolve(url)
+	if err != nil {
+		metrics.ObserveOperation("analyze", string(canonical.Platform), ClassifyError(err))
+		return nil, err
+	}
+	return backend.Analyze(ctx, canonical.URL)
+}
+
+func (r *Registry) BestFormats(info *VideoInfo) []Format {
+	r.mu.RLock()
+	backend, ok := r.backends[info.Platform]
+	r.mu.RUnlock()
+	if !ok {
+		return info.Formats
+	}
+	return backend.BestFormats(info)
+}
+
+func (r *Registry) Identify(url string) (Platform, string) {
+	canonical, backend, err := r.resolve(url)
+	if err != nil {
+		return canonical.Platform, ""
+	}
+	return backend.Identify(canonical.URL)
+}
+
+func (r *Registry) Canonicalize(url string) (*CanonicalURL, error) {
+	return r.validator.Canonicalize(url)
+}
+
+func (r *Registry) EstimateWork(url, formatID string, isAudioOnly bool) DownloadWork {
+	canonical, backend, err := r.resolve(url)
+	if err != nil {
+		return DownloadWork{Transcode: isAudioOnly}
+	}
+	return backend.EstimateWork(canonical.URL, formatID, isAudioOnly)
+}
+
+func (r *Registry) DownloadToFile(ctx context.Context, url, formatID, tempDir string, isAudioOnly bool) (string, string, error) {
+	canonical, backend, err := r.resolve(url)
+	if err != nil {
+		metrics.ObserveOperation("download", string(canonical.Platform), ClassifyError(err))
+		return "", "", err
+	}
+	return backend.DownloadToFile(ctx, canonical.URL, formatID, tempDir, isAudioOnly)
+}
+
+// Stream passes url through when its backend is a Streamer, and fails with
+// ErrNotStreamable otherwise
+func (r *Registry) Stream(ctx context.Context, url, formatID string, header http.Header) (*MediaStream, error) {
+	canonical, backend, err := r.resolve(url)
+	if err != nil {
+		metrics.ObserveOperation("download", string(canonical.Platform), ClassifyError(err))
+		return nil, err
+	}
+	streamer, ok := backend.(Streamer)
+	if !ok {
+		return nil, ErrNotStreamable
+	}
+	return streamer.Stream(ctx, canonical.URL, formatID, header)
+}
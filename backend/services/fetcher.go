@@ -0,0 +1,326 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrInsecureScheme   = errors.New("only https URLs are allowed")
+	ErrHostNotAllowed   = errors.New("host not allowed")
+	ErrBlockedAddress   = errors.New("address not allowed")
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrResponseTooBig   = errors.New("response too large")
+	ErrBodyStalled      = errors.New("response body stalled")
+)
+
+const maxFetchRedirects = 5
+
+// blockedNetworks are ranges the net/netip predicates don't cover:
+// shared address space, benchmarking, reserved and NAT64 (which can map to private IPv4)
+var blockedNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// HostAllowlist matches hosts against a list of domains and their subdomains
+type HostAllowlist struct {
+	entries []allowEntry
+}
+
+type allowEntry struct {
+	domain string
+	// port is empty when the entry only matches default ports
+	port string
+}
+
+// NewHostAllowlist creates an allowlist; "ytimg.com" allows ytimg.com and i.ytimg.com
+// on default ports, "files.internal:8080" allows files.internal and its subdomains on port 8080 only
+func NewHostAllowlist(domains []string) *HostAllowlist {
+	list := &HostAllowlist{}
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		var port string
+		if host, p, err := net.SplitHostPort(domain); err == nil {
+			domain, port = host, p
+		}
+		if domain = strings.Trim(domain, "."); domain != "" {
+			list.entries = append(list.entries, allowEntry{domain: domain, port: port})
+		}
+	}
+	return list
+}
+
+// Allows reports whether host (without port) is an allowed domain or a subdomain of one
+func (a *HostAllowlist) Allows(host string) bool {
+	return a.AllowsPort(host, "")
+}
+
+// AllowsPort is like Allows for a non-default port; an empty port is the scheme's default
+func (a *HostAllowlist) AllowsPort(host, port string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, entry := range a.entries {
+		if entry.port != port {
+			continue
+		}
+		if host == entry.domain || strings.HasSuffix(host, "."+entry.domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsURL reports whether the host and port of u are allowed
+func (a *HostAllowlist) AllowsURL(u *url.URL) bool {
+	return a.AllowsPort(u.Hostname(), explicitPort(u))
+}
+
+// explicitPort returns the port of u, or "" when it is the scheme's default
+func explicitPort(u *url.URL) string {
+	port := u.Port()
+	if (u.Scheme == "https" && port == "443") || (u.Scheme == "http" && port == "80") {
+		return ""
+	}
+	return port
+}
+
+// SafeFetcher fetches untrusted URLs without letting them reach internal services.
+// Only https URLs on allowlisted hosts are fetched, every redirect hop is checked
+// against the allowlist, and connections to private, loopback and link-local
+// addresses are refused at dial time, after DNS resolution.
+type SafeFetcher struct {
+	client      *http.Client
+	allowlist   atomic.Pointer[HostAllowlist]
+	maxBytes    int64
+	idleTimeout time.Duration
+	allowHTTP   bool
+}
+
+// FetcherOptions configures a SafeFetcher
+type FetcherOptions struct {
+	// Hosts are the allowed domains, see NewHostAllowlist
+	Hosts []string
+	// MaxBytes cuts off response bodies with ErrResponseTooBig
+	MaxBytes int64
+	// Timeout limits the whole request including the body; zero only limits
+	// connecting and waiting for response headers, for long streams
+	Timeout time.Duration
+	// IdleTimeout fails a body read with ErrBodyStalled when no data arrives
+	// for this long, bounding streams that have no overall Timeout
+	IdleTimeout time.Duration
+	// AllowHTTP permits plain http URLs in addition to https
+	AllowHTTP bool
+	// AllowPrivate permits private (RFC 1918, ULA and CGNAT) addresses, for
+	// servers on the internal network. Loopback and link-local stay blocked.
+	AllowPrivate bool
+}
+
+// NewSafeFetcher creates a fetcher for the allowlisted hosts in opts
+func NewSafeFetcher(opts FetcherOptions) *SafeFetcher {
+	f := &SafeFetcher{maxBytes: opts.MaxBytes, idleTimeout: opts.IdleTimeout, allowHTTP: opts.AllowHTTP}
+	f.SetAllowlist(opts.Hosts)
+
+	headerTimeout := opts.Timeout
+	if headerTimeout == 0 {
+		headerTimeout = 30 * time.Second
+	}
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return checkDialAddress(address, opts.AllowPrivate)
+		},
+	}
+	f.client = &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			// No proxy: the dial check must see the real destination
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          20,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: headerTimeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return ErrTooManyRedirects
+			}
+			return f.Check(req.URL)
+		},
+	}
+	return f
+}
+
+// SetAllowlist replaces the allowed domains, e.g. after a config reload
+func (f *SafeFetcher) SetAllowlist(domains []string) {
+	f.allowlist.Store(NewHostAllowlist(domains))
+}
+
+// MaxBytes is the largest response body the fetcher passes on
+func (f *SafeFetcher) MaxBytes() int64 {
+	return f.maxBytes
+}
+
+// Check reports whether u may be fetched: https (or http when allowed),
+// no credentials, allowlisted host and port
+func (f *SafeFetcher) Check(u *url.URL) error {
+	if u.Scheme != "https" && !(f.allowHTTP && u.Scheme == "http") {
+		return ErrInsecureScheme
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, u.Host)
+	}
+	if !f.allowlist.Load().AllowsURL(u) {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, u.Host)
+	}
+	return nil
+}
+
+// Get fetches rawURL with the given request headers.
+// Non-2xx responses are returned as-is; the caller must close the body.
+func (f *SafeFetcher) Get(ctx context.Context, rawURL string, header http.Header) (*http.Response, error) {
+	return f.Do(ctx, http.MethodGet, rawURL, header)
+}
+
+// Do is like Get for any body-less method, e.g. HEAD
+func (f *SafeFetcher) Do(ctx context.Context, method, rawURL string, header http.Header) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	if err := f.Check(u); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	if resp.ContentLength > f.maxBytes {
+		resp.Body.Close()
+		cancel(nil)
+		return nil, ErrResponseTooBig
+	}
+	body := &limitedBody{ReadCloser: resp.Body, remaining: f.maxBytes}
+	resp.Body = newIdleBody(ctx, body, f.idleTimeout, cancel)
+	return resp, nil
+}
+
+// idleBody cancels its request when a read makes no progress for timeout
+type idleBody struct {
+	io.ReadCloser
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	timer  *time.Timer
+	// timeout is zero when reads may stall indefinitely
+	timeout time.Duration
+}
+
+func newIdleBody(ctx context.Context, body io.ReadCloser, timeout time.Duration, cancel context.CancelCauseFunc) *idleBody {
+	b := &idleBody{ReadCloser: body, ctx: ctx, cancel: cancel, timeout: timeout}
+	if timeout > 0 {
+		b.timer = time.AfterFunc(timeout, func() { cancel(ErrBodyStalled) })
+	}
+	return b
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && errors.Is(context.Cause(b.ctx), ErrBodyStalled) {
+		return n, ErrBodyStalled
+	}
+	if n > 0 && b.timer != nil {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+	return err
+}
+
+// limitedBody fails with ErrResponseTooBig instead of silently truncating
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, ErrResponseTooBig
+	}
+	// Read one byte past the limit to tell "exactly at limit" from "over"
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), ErrResponseTooBig
+	}
+	return n, err
+}
+
+func checkDialAddress(address string, allowPrivate bool) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, address)
+	}
+	if !isPublicAddr(addrPort.Addr()) && !(allowPrivate && isPrivateAddr(addrPort.Addr())) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPrivateAddr reports whether addr is on a private network (RFC 1918, ULA or CGNAT)
+func isPrivateAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsPrivate() || sharedAddressSpace.Contains(addr)
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+)
+
+// mergedFormats reduces the formats of a video to the best audio and one
+// choice per resolution, pairing video-only streams with the audio stream.
+// It fits platforms that serve progressive files with sound next to DASH or
+// HLS video-only and audio-only streams (Instagram, X, Reddit): any stream
+// with sound is preferred over pairing.
+func mergedFormats(raw []ytdlpFormat) []Format {
+	return bestPerResolution(raw, func(f *ytdlpFormat) int {
+		if f.ACodec != "none" {
+			return 1
+		}
+		return 0
+	})
+}
+
+// vimeoFormats reduces Vimeo formats like mergedFormats. Vimeo serves each
+// resolution up to three times, as a progressive file and over HLS and
+// DASH; the progressive file is preferred, being a single download with
+// sound, then an HLS stream with sound, then video paired with audio.
+func vimeoFormats(raw []ytdlpFormat) []Format {
+	return bestPerResolution(raw, func(f *ytdlpFormat) int {
+		switch {
+		case f.ACodec == "none":
+			return 0
+		case f.Protocol == "https" || f.Protocol == "http":
+			return 2
+		default:
+			return 1
+		}
+	})
+}
+
+// twitchFormats offers the HLS variants of a Twitch VOD, or the files of a
+// clip, one per resolution, plus the audio-only variant. Every variant
+// carries its sound, so there is nothing to pair; the later variant of a
+// resolution has the higher frame rate.
+func twitchFormats(raw []ytdlpFormat) []Format {
+	muxed := make([]ytdlpFormat, 0, len(raw))
+	for _, f := range raw {
+		if f.ACodec != "none" {
+			muxed = append(muxed, f)
+		}
+	}
+	return bestPerResolution(muxed, func(f *ytdlpFormat) int { return 0 })
+}
+
+// bestPerResolution reduces formats to the best audio and, per resolution,
+// the format rank scores highest; yt-dlp lists formats from worst to best,
+// so later ones win ties. Video-only choices are paired with the audio
+// stream. Resolutions are named by the shorter side, as many videos are vertical.
+func bestPerResolution(raw []ytdlpFormat, rank func(f *ytdlpFormat) int) []Format {
+	var audio, progressive *ytdlpFormat
+	videos := make(map[int]*ytdlpFormat)
+	for i := range raw {
+		f := &raw[i]
+		switch {
+		case f.VCodec == "none" && f.ACodec != "none":
+			audio = f
+		case f.resolution() > 0:
+			if current := videos[f.resolution()]; current == nil || rank(f) >= rank(current) {
+				videos[f.resolution()] = f
+			}
+			if f.ACodec != "none" {
+				progressive = f
+			}
+		}
+	}
+
+	var formats []Format
+	switch {
+	case audio != nil:
+		formats = append(formats, Format{ID: audio.FormatID, Type: "audio", Quality: "Лучшее аудио", Ext: "m4a", Size: audio.Filesize})
+	case progressive != nil:
+		// Sound is extracted from the best file that has it
+		formats = append(formats, Format{ID: progressive.FormatID, Type: "audio", Quality: "Лучшее аудио", Ext: "m4a"})
+	}
+
+	resolutions := make([]int, 0, len(videos))
+	for resolution := range videos {
+		resolutions = append(resolutions, resolution)
+	}
+	sort.Ints(resolutions)
+
+	for _, resolution := range resolutions {
+		f := videos[resolution]
+		switch {
+		case f.ACodec != "none":
+			formats = append(formats, Format{ID: f.FormatID, Type: "video", Quality: fmt.Sprintf("%dp (видео + аудио)", resolution), Ext: "mp4", Size: f.Filesize})
+		case audio != nil:
+			formats = append(formats, Format{ID: f.FormatID + "+" + audio.FormatID, Type: "video", Quality: fmt.Sprintf("%dp (видео + аудио)", resolution), Ext: "mp4", Size: f.Filesize + audio.Filesize})
+		default:
+			formats = append(formats, Format{ID: f.FormatID, Type: "video_only", Quality: fmt.Sprintf("%dp (только видео)", resolution), Ext: "mp4", Size: f.Filesize})
+		}
+	}
+	return formats
+}
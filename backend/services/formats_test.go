@@ -0,0 +1,77 @@
+package services
+
+import (
+	"slices"
+	"testing"
+)
+
+func formatIDs(formats []Format) []string {
+	ids := make([]string, len(formats))
+	for i, f := range formats {
+		ids[i] = f.ID
+	}
+	return ids
+}
+
+func TestMergedFormats(t *testing.T) {
+	// Reddit: a low progressive file next to DASH video and audio streams
+	raw := []ytdlpFormat{
+		{FormatID: "hls-360", Height: 360, VCodec: "avc1", ACodec: "mp4a", Protocol: "m3u8_native"},
+		{FormatID: "dash-audio", VCodec: "none", ACodec: "mp4a", Filesize: 100},
+		{FormatID: "dash-360", Height: 360, VCodec: "avc1", ACodec: "none", Protocol: "http_dash_segments"},
+		{FormatID: "dash-720", Height: 720, VCodec: "avc1", ACodec: "none", Filesize: 1000, Protocol: "http_dash_segments"},
+	}
+	got := mergedFormats(raw)
+	if want := []string{"dash-audio", "hls-360", "dash-720+dash-audio"}; !slices.Equal(formatIDs(got), want) {
+		t.Errorf("formats = %v, want %v", formatIDs(got), want)
+	}
+	if got[2].Size != 1100 || got[2].Quality != "720p (видео + аудио)" {
+		t.Errorf("merged format = %+v", got[2])
+	}
+}
+
+func TestVimeoFormats(t *testing.T) {
+	raw := []ytdlpFormat{
+		{FormatID: "http-540p", Width: 960, Height: 540, VCodec: "avc1", ACodec: "mp4a", Protocol: "https"},
+		{FormatID: "hls-fastly-540p", Width: 960, Height: 540, VCodec: "avc1", ACodec: "mp4a", Protocol: "m3u8_native"},
+		{FormatID: "dash-fastly-540p", Width: 960, Height: 540, VCodec: "avc1", ACodec: "none", Protocol: "http_dash_segments"},
+		{FormatID: "hls-fastly-720p", Width: 1280, Height: 720, VCodec: "avc1", ACodec: "none", Protocol: "m3u8_native"},
+		{FormatID: "hls-fastly-audio-high", VCodec: "none", ACodec: "mp4a", Protocol: "m3u8_native"},
+		{FormatID: "http-1080p", Width: 1920, Height: 1080, VCodec: "avc1", ACodec: "mp4a", Protocol: "https"},
+		{FormatID: "hls-fastly-1080p", Width: 1920, Height: 1080, VCodec: "avc1", ACodec: "mp4a", Protocol: "m3u8_native"},
+		{FormatID: "dash-fastly-1080p", Width: 1920, Height: 1080, VCodec: "avc1", ACodec: "none", Protocol: "http_dash_segments"},
+	}
+	// The progressive file wins at each resolution it is served in
+	want := []string{"hls-fastly-audio-high", "http-540p", "hls-fastly-720p+hls-fastly-audio-high", "http-1080p"}
+	if got := vimeoFormats(raw); !slices.Equal(formatIDs(got), want) {
+		t.Errorf("formats = %v, want %v", formatIDs(got), want)
+	}
+}
+
+func TestTwitchFormats(t *testing.T) {
+	raw := []ytdlpFormat{
+		{FormatID: "Audio_Only", VCodec: "none", ACodec: "mp4a.40.2", Protocol: "m3u8_native"},
+		{FormatID: "360p30", Width: 640, Height: 360, VCodec: "avc1.4D401E", ACodec: "mp4a.40.2", Protocol: "m3u8_native"},
+		{FormatID: "720p30", Width: 1280, Height: 720, VCodec: "avc1.4D401F", ACodec: "mp4a.40.2", Protocol: "m3u8_native"},
+		{FormatID: "720p60", Width: 1280, Height: 720, VCodec: "avc1.4D4020", ACodec: "mp4a.40.2", Protocol: "m3u8_native"},
+		{FormatID: "1080p60", Width: 1920, Height: 1080, VCodec: "avc1.64002A", ACodec: "mp4a.40.2", Protocol: "m3u8_native"},
+	}
+	got := twitchFormats(raw)
+	if want := []string{"Audio_Only", "360p30", "720p60", "1080p60"}; !slices.Equal(formatIDs(got), want) {
+		t.Errorf("formats = %v, want %v", formatIDs(got), want)
+	}
+	for _, f := range got[1:] {
+		if f.Type != "video" {
+			t.Errorf("variant %s offered as %s, want video with sound", f.ID, f.Type)
+		}
+	}
+
+	// Clips come as files with sound and no audio-only variant
+	clip := []ytdlpFormat{
+		{FormatID: "360", Height: 360},
+		{FormatID: "1080", Height: 1080},
+	}
+	if want := []string{"1080", "360", "1080"}; !slices.Equal(formatIDs(twitchFormats(clip)), want) {
+		t.Errorf("clip formats = %v, want %v", formatIDs(twitchFormats(clip)), want)
+	}
+}
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// videoCodecs are the RFC 6381 codec prefixes that mark a stream as video
+var videoCodecs = []string{"avc1", "avc3", "hvc1", "hev1", "dvh1", "dvhe", "av01", "vp09", "vp8"}
+
+// parseHLS parses an HLS playlist. A master playlist yields a track per
+// variant and audio rendition, whose segments are loaded on download;
+// a media playlist yields a single track.
+func parseHLS(base *url.URL, body []byte) (*manifest, error) {
+	lines := playlistLines(body)
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
+			return parseHLSMaster(base, lines)
+		}
+	}
+
+	segments, duration, err := parseHLSMedia(base, body)
+	if err != nil {
+		return nil, err
+	}
+	return &manifest{
+		duration: duration,
+		tracks:   []*manifestTrack{{id: "v0", kind: "video", segments: segments}},
+	}, nil
+}
+
+func parseHLSMaster(base *url.URL, lines []string) (*manifest, error) {
+	m := &manifest{}
+
+	// Audio renditions with their own playlist; variants of their group carry no audio
+	groupsWithPlaylist := make(map[string]bool)
+	for _, line := range lines {
+		tag, ok := strings.CutPrefix(line, "#EXT-X-MEDIA:")
+		if !ok {
+			continue
+		}
+		attrs := parseAttributes(tag)
+		if attrs["TYPE"] != "AUDIO" || attrs["URI"] == "" {
+			continue
+		}
+		playlist, err := resolveReference(base, attrs["URI"])
+		if err != nil {
+			return nil, err
+		}
+		m.tracks = append(m.tracks, &manifestTrack{
+			id:         fmt.Sprintf("a%d", len(m.tracks)),
+			kind:       "audio",
+			name:       attrs["NAME"],
+			playlist:   playlist,
+			audioGroup: attrs["GROUP-ID"],
+			isDefault:  attrs["DEFAULT"] == "YES",
+		})
+		groupsWithPlaylist[attrs["GROUP-ID"]] = true
+	}
+
+	videos := 0
+	for i, line := range lines {
+		tag, ok := strings.CutPrefix(line, "#EXT-X-STREAM-INF:")
+		if !ok {
+			continue
+		}
+		// The variant URI is the next line that isn't a tag
+		uri := ""
+		for _, next := range lines[i+1:] {
+			if !strings.HasPrefix(next, "#") {
+				uri = next
+				break
+			}
+		}
+		if uri == "" {
+			return nil, fmt.Errorf("%w: variant without URI", ErrUnsupportedManifest)
+		}
+		playlist, err := resolveReference(base, uri)
+		if err != nil {
+			return nil, err
+		}
+
+		attrs := parseAttributes(tag)
+		track := &manifestTrack{
+			id:         fmt.Sprintf("v%d", videos),
+			kind:       "video",
+			playlist:   playlist,
+			audioGroup: attrs["AUDIO"],
+		}
+		track.bandwidth, _ = strconv.Atoi(attrs["BANDWIDTH"])
+		if _, height, ok := strings.Cut(attrs["RESOLUTION"], "x"); ok {
+			track.height, _ = strconv.Atoi(height)
+		}
+		switch {
+		case groupsWithPlaylist[track.audioGroup]:
+			track.kind = "video_only"
+		case attrs["CODECS"] != "" && attrs["RESOLUTION"] == "" && !hasVideoCodec(attrs["CODECS"]):
+			track.kind = "audio"
+		}
+		m.tracks = append(m.tracks, track)
+		videos++
+	}
+	return m, nil
+}
+
+// parseHLSMedia returns the segments of a VOD media playlist and their total duration
+func parseHLSMedia(base *url.URL, body []byte) ([]manifestSegment, float64, error) {
+	var (
+		segments []manifestSegment
+		duration float64
+		sequence uint64
+		key      *segmentKey
+		initURL  string
+		ended    bool
+		vod      bool
+		// byteRange applies to the next segment; nextOffset continues the previous range
+		byteRange  string
+		nextOffset = make(map[string]int64)
+	)
+
+	for _, line := range playlistLines(body) {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			sequence, _ = strconv.ParseUint(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+		case strings.HasPrefix(line, "#EXT-X-PLAYLIST-TYPE:"):
+			vod = strings.TrimPrefix(line, "#EXT-X-PLAYLIST-TYPE:") == "VOD"
+		case line == "#EXT-X-ENDLIST":
+			ended = true
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			seconds, _ := strconv.ParseFloat(value, 64)
+			duration += seconds
+		case strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
+			byteRange = strings.TrimPrefix(line, "#EXT-X-BYTERANGE:")
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-KEY:"))
+			switch attrs["METHOD"] {
+			case "NONE":
+				key = nil
+			case "AES-128":
+				keyURL, err := resolveReference(base, attrs["URI"])
+				if err != nil {
+					return nil, 0, err
+				}
+				key = &segmentKey{url: keyURL}
+				if iv := attrs["IV"]; iv != "" {
+					if key.iv, err = parseIV(iv); err != nil {
+						return nil, 0, err
+					}
+				}
+			default:
+				return nil, 0, fmt.Errorf("%w: encryption method %s", ErrUnsupportedManifest, attrs["METHOD"])
+			}
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-MAP:"))
+			mapURL, err := resolveReference(base, attrs["URI"])
+			if err != nil {
+				return nil, 0, err
+			}
+			// A new initialization section only matters when it changes
+			if mapURL+attrs["BYTERANGE"] == initURL {
+				continue
+			}
+			initURL = mapURL + attrs["BYTERANGE"]
+			segment := manifestSegment{url: mapURL, key: keyFor(key, sequence)}
+			if attrs["BYTERANGE"] != "" {
+				if segment.length, segment.offset, err = parseByteRange(attrs["BYTERANGE"], 0); err != nil {
+					return nil, 0, err
+				}
+			}
+			segments = append(segments, segment)
+		case strings.HasPrefix(line, "#"):
+			// Other tags and comments
+		default:
+			segmentURL, err := resolveReference(base, line)
+			if err != nil {
+				return nil, 0, err
+			}
+			segment := manifestSegment{url: segmentURL, key: keyFor(key, sequence)}
+			if byteRange != "" {
+				if segment.length, segment.offset, err = parseByteRange(byteRange, nextOffset[segmentURL]); err != nil {
+					return nil, 0, err
+				}
+				nextOffset[segmentURL] = segment.offset + segment.length
+				byteRange = ""
+			}
+			segments = append(segments, segment)
+			sequence++
+		}
+	}
+
+	if !ended && !vod {
+		return nil, 0, ErrLiveManifest
+	}
+	if len(segments) == 0 {
+		return nil, 0, fmt.Errorf("%w: playlist has no segments", ErrUnsupportedManifest)
+	}
+	return segments, duration, nil
+}
+
+// keyFor returns the key of a segment; without an explicit IV the
+// media sequence number is used, as the HLS spec requires
+func keyFor(key *segmentKey, sequence uint64) *segmentKey {
+	if key == nil || key.iv != nil {
+		return key
+	}
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint64(iv[8:], sequence)
+	return &segmentKey{url: key.url, iv: iv}
+}
+
+// playlistLines returns the trimmed, non-empty lines of a playlist
+func playlistLines(body []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))))
+	scanner.Buffer(make([]byte, 64*1024), maxManifestBytes)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// parseAttributes parses an HLS attribute list: KEY=value,KEY="quoted, value"
+func parseAttributes(list string) map[string]string {
+	attrs := make(map[string]string)
+	for list != "" {
+		name, rest, ok := strings.Cut(list, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+			rest = strings.TrimPrefix(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		attrs[strings.TrimSpace(name)] = value
+		list = strings.TrimSpace(rest)
+	}
+	return attrs
+}
+
+// parseByteRange parses "length[@offset]"; without an offset the range
+// continues at defaultOffset
+func parseByteRange(value string, defaultOffset int64) (length, offset int64, err error) {
+	lengthPart, offsetPart, hasOffset := strings.Cut(value, "@")
+	length, err = strconv.ParseInt(lengthPart, 10, 64)
+	if err != nil || length <= 0 {
+		return 0, 0, fmt.Errorf("%w: byte range %q", ErrUnsupportedManifest, value)
+	}
+	offset = defaultOffset
+	if hasOffset {
+		if offset, err = strconv.ParseInt(offsetPart, 10, 64); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("%w: byte range %q", ErrUnsupportedManifest, value)
+		}
+	}
+	return length, offset, nil
+}
+
+// parseIV parses a 128-bit hexadecimal IV like 0x00000000000000000000000000000001
+func parseIV(value string) ([]byte, error) {
+	digits := strings.TrimPrefix(strings.TrimPrefix(value, "0x"), "0X")
+	iv, err := hex.DecodeString(fmt.Sprintf("%032s", digits))
+	if err != nil || len(iv) != 16 {
+		return nil, fmt.Errorf("%w: IV %q", ErrUnsupportedManifest, value)
+	}
+	return iv, nil
+}
+
+func hasVideoCodec(codecs string) bool {
+	for _, codec := range strings.Split(codecs, ",") {
+		codec = strings.TrimSpace(codec)
+		for _, prefix := range videoCodecs {
+			if strings.HasPrefix(codec, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveReference resolves a manifest reference against the manifest's URL
+func resolveReference(base *url.URL, ref string) (string, error) {
+	u, err := base.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("%w: bad reference %q", ErrUnsupportedManifest, ref)
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+
+	"github.com/HugoSmits86/nativewebp"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// MaxThumbnailDimension caps requested thumbnail width and height
+	MaxThumbnailDimension = 2048
+	// maxSourcePixels caps the size of a decoded source image, 64 MiB as RGBA.
+	// A few kilobytes of PNG or JPEG can declare far larger images.
+	maxSourcePixels = 16 * 1024 * 1024
+)
+
+var ErrUnsupportedImage = errors.New("unsupported image format")
+
+// ThumbnailOptions describes how a thumbnail should be transformed.
+// The zero value serves the original image unchanged.
+type ThumbnailOptions struct {
+	// Width and Height bound the output size; the aspect ratio is kept and
+	// images are never enlarged. Zero leaves a dimension unconstrained.
+	Width  int
+	Height int
+	// Format is "jpeg", "png", "webp" or empty to keep the original format
+	Format string
+}
+
+// IsZero reports whether no transformation is requested
+func (o ThumbnailOptions) IsZero() bool {
+	return o.Width == 0 && o.Height == 0 && o.Format == ""
+}
+
+func (o ThumbnailOptions) key() string {
+	return strconv.Itoa(o.Width) + "x" + strconv.Itoa(o.Height) + "." + o.Format
+}
+
+// TransformImage decodes a JPEG, PNG, GIF or WebP image, scales it to fit
+// within opts and re-encodes it. Without an explicit format, WebP and GIF
+// sources become PNG and everything else JPEG.
+func TransformImage(data []byte, opts ThumbnailOptions) ([]byte, string, error) {
+	// The header is checked before anything is allocated for the pixels
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > maxSourcePixels {
+		return nil, "", fmt.Errorf("%w: %dx%d exceeds the size limit", ErrUnsupportedImage, config.Width, config.Height)
+	}
+
+	src, sourceFormat, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+
+	img := resizeToFit(src, opts.Width, opts.Height)
+
+	format := opts.Format
+	if format == "" {
+		format = "jpeg"
+		if sourceFormat == "png" || sourceFormat == "gif" || sourceFormat == "webp" {
+			format = "png"
+		}
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: 85})
+	case "png":
+		err = png.Encode(&buf, img)
+	case "webp":
+		err = nativewebp.Encode(&buf, img, nil)
+	default:
+		return nil, "", fmt.Errorf("%w: output %q", ErrUnsupportedImage, format)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode %s: %w", format, err)
+	}
+	return buf.Bytes(), "image/" + format, nil
+}
+
+// resizeToFit scales img down to fit within width x height
+func resizeToFit(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return img
+	}
+
+	scale := 1.0
+	if width > 0 && w > width {
+		scale = float64(width) / float64(w)
+	}
+	if height > 0 && float64(h)*scale > float64(height) {
+		scale = float64(height) / float64(h)
+	}
+	if scale >= 1 {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, max(1, int(float64(w)*scale+0.5)), max(1, int(float64(h)*scale+0.5))))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, xdraw.Over, nil)
+	return dst
+}
+
+// flatten draws img over white, since JPEG has no alpha channel
+func flatten(img image.Image) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,653 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+
+	"viddown/metrics"
+	"viddown/tracing"
+)
+
+var (
+	ErrNotManifest         = errors.New("not an HLS or DASH manifest")
+	ErrLiveManifest        = errors.New("live streams are not supported")
+	ErrUnsupportedManifest = errors.New("unsupported manifest")
+	ErrFormatNotAvailable  = errors.New("requested format is not available")
+)
+
+const (
+	// maxManifestBytes caps playlists and MPDs, which are small text files
+	maxManifestBytes = 16 * 1024 * 1024
+	// segmentAttempts is how often a segment is tried before the download fails
+	segmentAttempts = 3
+)
+
+// manifestTypes are the content types of HLS playlists and DASH MPDs
+var manifestTypes = map[string]bool{
+	"application/vnd.apple.mpegurl": true,
+	"application/x-mpegurl":         true,
+	"audio/mpegurl":                 true,
+	"audio/x-mpegurl":               true,
+	"application/dash+xml":          true,
+}
+
+// Fetcher performs HTTP GET requests. SafeFetcher is used in production;
+// a plain client against a local server can stand in for it in tests.
+type Fetcher interface {
+	Get(ctx context.Context, rawURL string, header http.Header) (*http.Response, error)
+}
+
+// manifest is a parsed HLS or DASH presentation
+type manifest struct {
+	// duration in seconds, 0 until known
+	duration float64
+	tracks   []*manifestTrack
+}
+
+// manifestTrack is one downloadable stream: an HLS variant or rendition,
+// or a DASH representation
+type manifestTrack struct {
+	id string
+	// kind is "video" (with audio), "video_only" or "audio"
+	kind      string
+	bandwidth int
+	height    int
+	name      string
+	// playlist is the HLS media playlist holding the segments, loaded on demand
+	playlist string
+	// audioGroup pairs an HLS variant with the audio renditions of the same group
+	audioGroup string
+	isDefault  bool
+	segments   []manifestSegment
+	// seconds is the duration of an HLS media playlist
+	seconds float64
+}
+
+type manifestSegment struct {
+	url string
+	// offset and length select a byte range; length 0 is the whole resource
+	offset int64
+	length int64
+	key    *segmentKey
+}
+
+// segmentKey is the AES-128 key location and IV of an encrypted HLS segment
+type segmentKey struct {
+	url string
+	iv  []byte
+}
+
+// ManifestService downloads HLS and DASH presentations natively: segments are
+// fetched concurrently with retries, decrypted when AES-128 encrypted, and
+// remuxed to MP4 by ffmpeg without re-encoding
+type ManifestService struct {
+	fetcher     Fetcher
+	ffmpegPath  string
+	concurrency int
+	maxBytes    int64
+	logs        *ProcessLogStore
+}
+
+// NewManifestService creates a manifest downloader fetching up to concurrency
+// segments at once, failing downloads larger than maxBytes in total
+func NewManifestService(fetcher Fetcher, ffmpegPath string, concurrency int, maxBytes int64, logs *ProcessLogStore) *ManifestService {
+	return &ManifestService{
+		fetcher:     fetcher,
+		ffmpegPath:  ffmpegPath,
+		concurrency: concurrency,
+		maxBytes:    maxBytes,
+		logs:        logs,
+	}
+}
+
+// IsManifestURL reports whether rawURL looks like an HLS or DASH manifest by its extension
+func IsManifestURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(u.Path))
+	return ext == ".m3u8" || ext == ".mpd"
+}
+
+// Analyze lists the variants of the manifest at rawURL as formats
+func (s *ManifestService) Analyze(ctx context.Context, rawURL string) (*VideoInfo, error) {
+	m, err := s.load(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// HLS master playlists don't state the duration; take it from a media playlist
+	if m.duration == 0 {
+		for _, track := range m.tracks {
+			if track.playlist != "" {
+				if err := s.loadSegments(ctx, track); err != nil {
+					return nil, err
+				}
+				m.duration = track.seconds
+				break
+			}
+		}
+	}
+
+	formats := m.formats()
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("%w: no playable tracks", ErrUnsupportedManifest)
+	}
+	return &VideoInfo{
+		ID:       hashBytes([]byte(rawURL))[:16],
+		Platform: PlatformDirect,
+		Title:    titleFromURL(rawURL),
+		Duration: int(m.duration),
+		Formats:  formats,
+	}, nil
+}
+
+// DownloadToFile downloads the tracks of formatID ("v0+a1", or "best") and
+// remuxes them into tempDir as MP4, or M4A when isAudioOnly
+func (s *ManifestService) DownloadToFile(ctx context.Context, rawURL, formatID, tempDir string, isAudioOnly bool) (string, string, error) {
+	m, err := s.load(ctx, rawURL)
+	if err != nil {
+		return "", "", err
+	}
+	tracks, err := m.selectTracks(formatID, isAudioOnly)
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix := filepath.Join(tempDir, fmt.Sprintf("%d_", time.Now().UnixNano()))
+	var inputs []string
+	defer func() {
+		for _, input := range inputs {
+			os.Remove(input)
+		}
+	}()
+
+	start := time.Now()
+	_, span := tracing.Start(ctx, "phase download", attribute.Int("manifest.tracks", len(tracks)))
+	budget := &atomic.Int64{}
+	budget.Store(s.maxBytes)
+	for i, track := range tracks {
+		if err = s.loadSegments(ctx, track); err != nil {
+			break
+		}
+		input := fmt.Sprintf("%strack%d", prefix, i)
+		inputs = append(inputs, input)
+		if err = s.downloadTrack(ctx, track, input, budget); err != nil {
+			break
+		}
+	}
+	tracing.End(span, err)
+	metrics.ObservePhase("download", time.Since(start), err)
+	if err != nil {
+		return "", "", err
+	}
+
+	ext := ".mp4"
+	if isAudioOnly {
+		ext = ".m4a"
+	}
+	output := prefix + "output" + ext
+	if err := s.remux(ctx, inputs, output, isAudioOnly); err != nil {
+		os.Remove(output)
+		return "", "", err
+	}
+	return output, titleFromURL(rawURL) + ext, nil
+}
+
+// load fetches and parses the manifest at rawURL
+func (s *ManifestService) load(ctx context.Context, rawURL string) (*manifest, error) {
+	body, base, err := s.fetchManifest(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")))
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("#EXTM3U")):
+		return parseHLS(base, body)
+	case bytes.Contains(trimmed, []byte("<MPD")):
+		return parseDASH(base, body)
+	}
+	return nil, ErrNotManifest
+}
+
+// loadSegments fetches the media playlist of an HLS track
+func (s *ManifestService) loadSegments(ctx context.Context, track *manifestTrack) error {
+	if track.playlist == "" || track.segments != nil {
+		return nil
+	}
+	body, base, err := s.fetchManifest(ctx, track.playlist)
+	if err != nil {
+		return err
+	}
+	track.segments, track.seconds, err = parseHLSMedia(base, body)
+	return err
+}
+
+// fetchManifest returns the body of a manifest and the URL it was served
+// from after redirects, which relative references resolve against
+func (s *ManifestService) fetchManifest(ctx context.Context, rawURL string) ([]byte, *url.URL, error) {
+	resp, err := s.fetcher.Get(ctx, rawURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%w: %w", ErrMediaUnavailable, &httpStatusError{status: resp.StatusCode})
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestBytes+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) > maxManifestBytes {
+		return nil, nil, ErrResponseTooBig
+	}
+
+	if resp.Request != nil && resp.Request.URL != nil {
+		return body, resp.Request.URL, nil
+	}
+	base, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, ErrInvalidURL
+	}
+	return body, base, nil
+}
+
+// formats offers each video quality, paired with audio where the audio is
+// separate, and each audio track
+func (m *manifest) formats() []Format {
+	var videos, audios []*manifestTrack
+	for _, track := range m.tracks {
+		if track.kind == "audio" {
+			audios = append(audios, track)
+		} else {
+			videos = append(videos, track)
+		}
+	}
+	sort.SliceStable(videos, func(i, j int) bool {
+		if videos[i].height != videos[j].height {
+			return videos[i].height > videos[j].height
+		}
+		return videos[i].bandwidth > videos[j].bandwidth
+	})
+	sort.SliceStable(audios, func(i, j int) bool { return audios[i].bandwidth > audios[j].bandwidth })
+
+	var formats []Format
+	seen := make(map[string]bool)
+	add := func(f Format) {
+		if !seen[f.Type+f.Quality] {
+			seen[f.Type+f.Quality] = true
+			formats = append(formats, f)
+		}
+	}
+
+	for _, video := range videos {
+		quality := video.quality()
+		if video.kind == "video" {
+			add(Format{ID: video.id, Type: "video", Quality: quality, Ext: "mp4", Size: m.estimateSize(video.bandwidth)})
+			continue
+		}
+		if audio := m.audioFor(video); audio != nil {
+			add(Format{
+				ID:      video.id + "+" + audio.id,
+				Type:    "video",
+				Quality: quality + " (видео + аудио)",
+				Ext:     "mp4",
+				Size:    m.estimateSize(video.bandwidth + audio.bandwidth),
+			})
+		}
+		add(Format{ID: video.id, Type: "video_only", Quality: quality + " (только видео)", Ext: "mp4", Size: m.estimateSize(video.bandwidth)})
+	}
+
+	for _, audio := range audios {
+		add(Format{ID: audio.id, Type: "audio", Quality: audio.quality(), Ext: "m4a", Size: m.estimateSize(audio.bandwidth)})
+	}
+	// Without separate audio, audio is extracted from the best muxed variant
+	if len(audios) == 0 {
+		for _, video := range videos {
+			if video.kind == "video" {
+				add(Format{ID: video.id, Type: "audio", Quality: "Лучшее аудио", Ext: "m4a"})
+				break
+			}
+		}
+	}
+	return formats
+}
+
+// audioFor returns the audio track to pair with a video-only track:
+// the default rendition of its HLS group, or else the best audio
+func (m *manifest) audioFor(video *manifestTrack) *manifestTrack {
+	var best *manifestTrack
+	for _, track := range m.tracks {
+		if track.kind != "audio" || track.audioGroup != video.audioGroup {
+			continue
+		}
+		if track.isDefault {
+			return track
+		}
+		if best == nil || track.bandwidth > best.bandwidth {
+			best = track
+		}
+	}
+	return best
+}
+
+// selectTracks returns the tracks of formatID; "best" is the first format offered
+func (m *manifest) selectTracks(formatID string, isAudioOnly bool) ([]*manifestTrack, error) {
+	if formatID == "" || formatID == "best" {
+		wanted := "video"
+		if isAudioOnly {
+			wanted = "audio"
+		}
+		for _, f := range m.formats() {
+			if f.Type == wanted {
+				formatID = f.ID
+				break
+			}
+		}
+	}
+
+	var tracks []*manifestTrack
+	for _, id := range strings.Split(formatID, "+") {
+		var found *manifestTrack
+		for _, track := range m.tracks {
+			if track.id == id {
+				found = track
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("%w: %s", ErrFormatNotAvailable, formatID)
+		}
+		tracks = append(tracks, found)
+	}
+	return tracks, nil
+}
+
+func (m *manifest) estimateSize(bandwidth int) int64 {
+	return int64(float64(bandwidth) / 8 * m.duration)
+}
+
+func (t *manifestTrack) quality() string {
+	switch {
+	case t.height > 0:
+		return fmt.Sprintf("%dp", t.height)
+	case t.kind == "audio" && t.bandwidth > 0:
+		return fmt.Sprintf("%dkbps", t.bandwidth/1000)
+	case t.name != "":
+		return t.name
+	case t.bandwidth > 0:
+		return fmt.Sprintf("%dkbps", t.bandwidth/1000)
+	}
+	return "original"
+}
+
+// downloadTrack fetches the segments of a track concurrently and writes them
+// to path in order. At most s.concurrency segments are held in memory.
+func (s *ManifestService) downloadTrack(ctx context.Context, track *manifestTrack, path string, budget *atomic.Int64) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(s.concurrency)
+
+	// A worker holds its slot until the writer has taken its segment,
+	// so finished segments can't pile up behind a slow one
+	results := make([]chan []byte, len(track.segments))
+	for i := range results {
+		results[i] = make(chan []byte)
+	}
+	keys := &keyCache{keys: make(map[string][]byte)}
+	launched := make(chan struct{})
+	go func() {
+		defer close(launched)
+		for i, segment := range track.segments {
+			group.Go(func() error {
+				data, err := s.fetchSegment(groupCtx, segment, keys)
+				if err != nil {
+					return err
+				}
+				select {
+				case results[i] <- data:
+					return nil
+				case <-groupCtx.Done():
+					return groupCtx.Err()
+				}
+			})
+		}
+	}()
+
+	var writeErr error
+	for i := range results {
+		select {
+		case data := <-results[i]:
+			if budget.Add(-int64(len(data))) < 0 {
+				writeErr = ErrResponseTooBig
+			} else if _, err := file.Write(data); err != nil {
+				writeErr = err
+			}
+		case <-groupCtx.Done():
+			writeErr = groupCtx.Err()
+		}
+		if writeErr != nil {
+			break
+		}
+	}
+	cancel()
+	<-launched
+	fetchErr := group.Wait()
+
+	switch {
+	case writeErr != nil && !errors.Is(writeErr, context.Canceled):
+		return writeErr
+	case fetchErr != nil:
+		return fetchErr
+	case writeErr != nil:
+		return writeErr
+	}
+	return file.Close()
+}
+
+// fetchSegment downloads and, when encrypted, decrypts a segment
+func (s *ManifestService) fetchSegment(ctx context.Context, segment manifestSegment, keys *keyCache) ([]byte, error) {
+	data, err := s.fetchWithRetries(ctx, segment.url, segment.offset, segment.length)
+	if err != nil || segment.key == nil {
+		return data, err
+	}
+	key, err := s.key(ctx, keys, segment.key.url)
+	if err != nil {
+		return nil, err
+	}
+	return decryptSegment(data, key, segment.key.iv)
+}
+
+// fetchWithRetries fetches a resource or byte range, retrying network
+// errors and server-side failures with a growing delay
+func (s *ManifestService) fetchWithRetries(ctx context.Context, rawURL string, offset, length int64) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		data, err := s.fetchBytes(ctx, rawURL, offset, length)
+		if err == nil || attempt == segmentAttempts || !retryable(err) {
+			return data, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(attempt) * 500 * time.Millisecond):
+		}
+	}
+}
+
+func (s *ManifestService) fetchBytes(ctx context.Context, rawURL string, offset, length int64) ([]byte, error) {
+	header := http.Header{}
+	if length > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+	resp, err := s.fetcher.Get(ctx, rawURL, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && length > 0:
+		return io.ReadAll(io.LimitReader(resp.Body, length))
+	case resp.StatusCode == http.StatusOK && length > 0:
+		// The server ignored the range: read up to its end and no further
+		data, err := io.ReadAll(io.LimitReader(resp.Body, offset+length))
+		if err != nil {
+			return nil, err
+		}
+		if offset+length > int64(len(data)) {
+			return nil, fmt.Errorf("%w: byte range beyond end of %s", ErrUnsupportedManifest, rawURL)
+		}
+		return data[offset:], nil
+	case resp.StatusCode == http.StatusOK:
+		return io.ReadAll(resp.Body)
+	}
+	return nil, &httpStatusError{status: resp.StatusCode}
+}
+
+// keyCache holds the AES-128 keys of one download
+type keyCache struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func (s *ManifestService) key(ctx context.Context, cache *keyCache, keyURL string) ([]byte, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if key, ok := cache.keys[keyURL]; ok {
+		return key, nil
+	}
+	key, err := s.fetchWithRetries(ctx, keyURL, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 16 {
+		return nil, fmt.Errorf("%w: AES-128 key must be 16 bytes, got %d", ErrUnsupportedManifest, len(key))
+	}
+	cache.keys[keyURL] = key
+	return key, nil
+}
+
+// decryptSegment decrypts AES-128-CBC with PKCS#7 padding
+func decryptSegment(data, key, iv []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("%w: encrypted segment of %d bytes", ErrUnsupportedManifest, len(data))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, fmt.Errorf("%w: bad padding, wrong key?", ErrUnsupportedManifest)
+	}
+	return plain[:len(plain)-padding], nil
+}
+
+// remux combines the downloaded tracks into output without re-encoding
+func (s *ManifestService) remux(ctx context.Context, inputs []string, output string, isAudioOnly bool) (err error) {
+	args := []string{"-hide_banner", "-loglevel", "error", "-y"}
+	for _, input := range inputs {
+		args = append(args, "-i", input)
+	}
+	switch {
+	case isAudioOnly:
+		args = append(args, "-map", fmt.Sprintf("%d:a:0", len(inputs)-1), "-vn")
+	case len(inputs) > 1:
+		args = append(args, "-map", "0:v:0", "-map", "1:a:0")
+	default:
+		args = append(args, "-map", "0:v?", "-map", "0:a?")
+	}
+	args = append(args, "-c", "copy", "-movflags", "+faststart", output)
+
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "phase merge",
+		attribute.String("process.executable.path", s.ffmpegPath),
+		attribute.StringSlice("process.command_args", args),
+	)
+	log := s.logs.Start(ctx, "remux", args)
+	defer func() {
+		tracing.End(span, err)
+		log.finish(err)
+		metrics.ObservePhase("merge", time.Since(start), err)
+	}()
+
+	cmd := exec.CommandContext(ctx, s.ffmpegPath, args...)
+	cmd.Stderr = log
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("ffmpeg remux failed: %s", log.Tail(5))
+		}
+		return fmt.Errorf("ffmpeg remux failed: %w", startError(cmd, err, ErrFFmpegUnavailable))
+	}
+	return nil
+}
+
+// httpStatusError is an unexpected upstream response status
+type httpStatusError struct {
+	status int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP Error %d: %s", e.status, http.StatusText(e.status))
+}
+
+// retryable reports whether a failed fetch may succeed when tried again
+func retryable(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.status >= 500 || statusErr.status == http.StatusRequestTimeout || statusErr.status == http.StatusTooManyRequests
+	}
+	for _, permanent := range []error{context.Canceled, context.DeadlineExceeded, ErrInvalidURL, ErrInsecureScheme, ErrHostNotAllowed, ErrBlockedAddress, ErrResponseTooBig} {
+		if errors.Is(err, permanent) {
+			return false
+		}
+	}
+	return true
+}
+
+// titleFromURL names a download after the last path segment of its URL
+func titleFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "video"
+	}
+	name, err := url.PathUnescape(path.Base(u.Path))
+	if err != nil || name == "." || name == "/" {
+		return "video"
+	}
+	if title := strings.TrimSuffix(name, path.Ext(name)); title != "" {
+		return title
+	}
+	return "video"
+}
@@ -0,0 +1,340 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// clientFetcher fetches with a plain client; SafeFetcher refuses loopback servers
+type clientFetcher struct{}
+
+func (clientFetcher) Get(ctx context.Context, rawURL string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// newMediaServer serves files by path, honoring Range requests unless ignoreRange
+func newMediaServer(t *testing.T, files map[string][]byte, ignoreRange bool) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if ignoreRange {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestManifestService() *ManifestService {
+	return NewManifestService(clientFetcher{}, "ffmpeg", 2, 1<<20, NewProcessLogStore(10, 64*1024))
+}
+
+// downloadTestTrack loads the segments of a track and returns the bytes downloadTrack writes
+func downloadTestTrack(t *testing.T, s *ManifestService, track *manifestTrack) []byte {
+	t.Helper()
+	ctx := context.Background()
+	if err := s.loadSegments(ctx, track); err != nil {
+		t.Fatalf("load segments of %s: %v", track.id, err)
+	}
+	path := filepath.Join(t.TempDir(), track.id)
+	budget := &atomic.Int64{}
+	budget.Store(s.maxBytes)
+	if err := s.downloadTrack(ctx, track, path, budget); err != nil {
+		t.Fatalf("download %s: %v", track.id, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func trackByID(t *testing.T, m *manifest, id string) *manifestTrack {
+	t.Helper()
+	for _, track := range m.tracks {
+		if track.id == id {
+			return track
+		}
+	}
+	t.Fatalf("no track %s", id)
+	return nil
+}
+
+func TestHLSMasterAndMediaPlaylists(t *testing.T) {
+	server := newMediaServer(t, map[string][]byte{
+		"/master.m3u8": []byte(`#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",NAME="English",DEFAULT=YES,URI="audio/en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,AUDIO="aud"
+low/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720,AUDIO="aud"
+high/index.m3u8
+`),
+		"/high/index.m3u8": []byte(`#EXTM3U
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:4.0,
+seg1.m4s
+#EXTINF:2.5,
+seg2.m4s
+#EXT-X-ENDLIST
+`),
+		"/low/index.m3u8": []byte("#EXTM3U\n#EXTINF:6.5,\nseg.m4s\n#EXT-X-ENDLIST\n"),
+		"/audio/en.m3u8":  []byte("#EXTM3U\n#EXTINF:6.5,\n/audio/a.m4s\n#EXT-X-ENDLIST\n"),
+		"/high/init.mp4":  []byte("init|"),
+		"/high/seg1.m4s":  []byte("one|"),
+		"/high/seg2.m4s":  []byte("two"),
+		"/low/seg.m4s":    []byte("low"),
+		"/audio/a.m4s":    []byte("audio"),
+	}, false)
+	s := newTestManifestService()
+
+	info, err := s.Analyze(context.Background(), server.URL+"/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Duration != 6 || info.Title != "master" {
+		t.Errorf("duration %d, title %q; want 6 and master", info.Duration, info.Title)
+	}
+	if len(info.Formats) == 0 || info.Formats[0].ID != "v1+a0" || info.Formats[0].Quality != "720p (видео + аудио)" {
+		t.Fatalf("formats = %+v, want 720p paired with the audio rendition first", info.Formats)
+	}
+
+	m, err := s.load(context.Background(), server.URL+"/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracks, err := m.selectTracks("best", false)
+	if err != nil || len(tracks) != 2 {
+		t.Fatalf("best tracks = %v, %v", tracks, err)
+	}
+	if got := string(downloadTestTrack(t, s, tracks[0])); got != "init|one|two" {
+		t.Errorf("video = %q, want the init section and segments in order", got)
+	}
+	if got := string(downloadTestTrack(t, s, tracks[1])); got != "audio" {
+		t.Errorf("audio = %q", got)
+	}
+}
+
+func TestHLSLivePlaylistRejected(t *testing.T) {
+	server := newMediaServer(t, map[string][]byte{
+		"/live.m3u8": []byte("#EXTM3U\n#EXTINF:2,\nseg.ts\n"),
+	}, false)
+	if _, err := newTestManifestService().Analyze(context.Background(), server.URL+"/live.m3u8"); err != ErrLiveManifest {
+		t.Errorf("err = %v, want %v", err, ErrLiveManifest)
+	}
+}
+
+// encryptSegment encrypts data with AES-128-CBC and PKCS#7 padding, as HLS packagers do
+func encryptSegment(t *testing.T, data, key, iv []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	padded := append(bytes.Clone(data), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+	return encrypted
+}
+
+func TestHLSAES128Decryption(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	// Without an IV attribute the media sequence number is the IV
+	sequenceIV := make([]byte, 16)
+	binary.BigEndian.PutUint64(sequenceIV[8:], 7)
+	explicitIV := bytes.Repeat([]byte{0xab}, 16)
+
+	server := newMediaServer(t, map[string][]byte{
+		"/enc.m3u8": []byte(`#EXTM3U
+#EXT-X-MEDIA-SEQUENCE:7
+#EXT-X-KEY:METHOD=AES-128,URI="key.bin"
+#EXTINF:4,
+a.ts
+#EXT-X-KEY:METHOD=AES-128,URI="key.bin",IV=0xabababababababababababababababab
+#EXTINF:4,
+b.ts
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:4,
+c.ts
+#EXT-X-ENDLIST
+`),
+		"/key.bin": key,
+		"/a.ts":    encryptSegment(t, []byte("first segment|"), key, sequenceIV),
+		"/b.ts":    encryptSegment(t, []byte("second, exactly 16"), key, explicitIV),
+		"/c.ts":    []byte("|clear"),
+	}, false)
+	s := newTestManifestService()
+
+	m, err := s.load(context.Background(), server.URL+"/enc.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(downloadTestTrack(t, s, m.tracks[0])); got != "first segment|second, exactly 16|clear" {
+		t.Errorf("decrypted = %q", got)
+	}
+}
+
+func TestHLSWrongKeyFails(t *testing.T) {
+	iv := make([]byte, 16)
+	server := newMediaServer(t, map[string][]byte{
+		"/enc.m3u8": []byte("#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"\n#EXTINF:4,\na.ts\n#EXT-X-ENDLIST\n"),
+		"/key.bin":  []byte("short"),
+		"/a.ts":     encryptSegment(t, []byte("data"), []byte("0123456789abcdef"), iv),
+	}, false)
+	s := newTestManifestService()
+
+	m, err := s.load(context.Background(), server.URL+"/enc.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	budget := &atomic.Int64{}
+	budget.Store(s.maxBytes)
+	err = s.downloadTrack(context.Background(), m.tracks[0], filepath.Join(t.TempDir(), "out"), budget)
+	if err == nil || !strings.Contains(err.Error(), "key must be 16 bytes") {
+		t.Errorf("err = %v, want a key length error", err)
+	}
+}
+
+func TestDASHSegmentTemplate(t *testing.T) {
+	server := newMediaServer(t, map[string][]byte{
+		"/dash/stream.mpd": []byte(`<?xml version="1.0"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static" mediaPresentationDuration="PT6S">
+  <Period>
+    <AdaptationSet mimeType="video/mp4" codecs="avc1.64001f">
+      <SegmentTemplate initialization="$RepresentationID$/init.mp4" media="$RepresentationID$/$Number%03d$.m4s" startNumber="1" timescale="1000" duration="2000"/>
+      <Representation id="720p" bandwidth="2000000" height="720"/>
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4" codecs="mp4a.40.2">
+      <SegmentTemplate initialization="audio/init.mp4" media="audio/$Time$.m4s" timescale="48000">
+        <SegmentTimeline>
+          <S t="0" d="144000" r="1"/>
+        </SegmentTimeline>
+      </SegmentTemplate>
+      <Representation id="aac" bandwidth="128000"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`),
+		"/dash/720p/init.mp4":    []byte("vinit|"),
+		"/dash/720p/001.m4s":     []byte("v1|"),
+		"/dash/720p/002.m4s":     []byte("v2|"),
+		"/dash/720p/003.m4s":     []byte("v3"),
+		"/dash/audio/init.mp4":   []byte("ainit|"),
+		"/dash/audio/0.m4s":      []byte("a0|"),
+		"/dash/audio/144000.m4s": []byte("a1"),
+	}, false)
+	s := newTestManifestService()
+
+	info, err := s.Analyze(context.Background(), server.URL+"/dash/stream.mpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Duration != 6 || len(info.Formats) == 0 || info.Formats[0].ID != "v0+a0" {
+		t.Fatalf("duration %d, formats %+v; want 6s and 720p paired with audio", info.Duration, info.Formats)
+	}
+
+	m, err := s.load(context.Background(), server.URL+"/dash/stream.mpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(downloadTestTrack(t, s, trackByID(t, m, "v0"))); got != "vinit|v1|v2|v3" {
+		t.Errorf("video = %q, want three numbered segments after the init section", got)
+	}
+	if got := string(downloadTestTrack(t, s, trackByID(t, m, "a0"))); got != "ainit|a0|a1" {
+		t.Errorf("audio = %q, want the timeline's segments", got)
+	}
+}
+
+func TestDASHSegmentList(t *testing.T) {
+	// One file holds the init section and both segments as byte ranges
+	media := []byte("INITseg-oneseg-two")
+	mpd := []byte(`<MPD type="static" mediaPresentationDuration="PT4S">
+  <BaseURL>media/</BaseURL>
+  <Period>
+    <AdaptationSet contentType="video" mimeType="video/mp4">
+      <Representation id="1" bandwidth="500000" height="360">
+        <BaseURL>all.mp4</BaseURL>
+        <SegmentList>
+          <Initialization range="0-3"/>
+          <SegmentURL mediaRange="4-10"/>
+          <SegmentURL mediaRange="11-17"/>
+        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`)
+
+	for _, ignoreRange := range []bool{false, true} {
+		name := "range honored"
+		if ignoreRange {
+			name = "range ignored"
+		}
+		t.Run(name, func(t *testing.T) {
+			server := newMediaServer(t, map[string][]byte{"/list.mpd": mpd, "/media/all.mp4": media}, ignoreRange)
+			s := newTestManifestService()
+			m, err := s.load(context.Background(), server.URL+"/list.mpd")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(downloadTestTrack(t, s, m.tracks[0])); got != string(media) {
+				t.Errorf("video = %q, want the byte ranges reassembled", got)
+			}
+		})
+	}
+}
+
+func TestFetchBytesRangeFallback(t *testing.T) {
+	t.Run("beyond end", func(t *testing.T) {
+		server := newMediaServer(t, map[string][]byte{"/short.ts": []byte("tiny")}, true)
+		_, err := newTestManifestService().fetchBytes(context.Background(), server.URL+"/short.ts", 2, 10)
+		if err == nil || !strings.Contains(err.Error(), "beyond end") {
+			t.Errorf("err = %v, want a byte range error", err)
+		}
+	})
+
+	t.Run("endless body", func(t *testing.T) {
+		// A server ignoring Range on a huge resource must not be read to its end
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			chunk := bytes.Repeat([]byte("x"), 32*1024)
+			for r.Context().Err() == nil {
+				if _, err := w.Write(chunk); err != nil {
+					return
+				}
+			}
+		}))
+		t.Cleanup(server.Close)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		data, err := newTestManifestService().fetchBytes(ctx, server.URL+"/stream.ts", 100, 50)
+		if err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		if len(data) != 50 {
+			t.Errorf("got %d bytes, want the 50 byte range", len(data))
+		}
+	})
+}
@@ -0,0 +1,346 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"viddown/metrics"
+)
+
+const (
+	// musicAlbumID is the format downloading a whole album as a ZIP
+	musicAlbumID = "album"
+	// musicSkippedName is the ZIP entry listing the album tracks that could not be downloaded
+	musicSkippedName = "Пропущенные треки.txt"
+	// musicTrackPrefix starts the format of one album track, "track2" for the second
+	musicTrackPrefix = "track"
+	// musicAudioFormat prefers AAC, which becomes m4a without re-encoding
+	musicAudioFormat = "bestaudio[ext=m4a]/bestaudio"
+)
+
+// musicArgs embed the track, artist, album, release year and track number
+// yt-dlp reports, and the cover art cropped to a square, as YouTube serves
+// album art inside 16:9 video thumbnails
+var musicArgs = []string{
+	"--embed-metadata",
+	"--parse-metadata", `release_year:(?P<meta_date>\d{4})`,
+	"--embed-thumbnail",
+	"--convert-thumbnails", "jpg",
+	"--postprocessor-args", `ThumbnailsConvertor+FFmpeg_o:-c:v mjpeg -vf crop="'if(gt(ih,iw),iw,ih)':'if(gt(iw,ih),ih,iw)'"`,
+}
+
+// MusicInfo is the music metadata of a track, or of an album with its tracks
+type MusicInfo struct {
+	Track       string `json:"track,omitempty"`
+	Artist      string `json:"artist,omitempty"`
+	Album       string `json:"album,omitempty"`
+	Year        int    `json:"year,omitempty"`
+	TrackNumber int    `json:"trackNumber,omitempty"`
+	// Tracks are the tracks of an album in order
+	Tracks []MusicTrack `json:"tracks,omitempty"`
+}
+
+// MusicTrack is one track of an album
+type MusicTrack struct {
+	// Index is the 1-based position in the album
+	Index    int    `json:"index"`
+	Title    string `json:"title"`
+	Artist   string `json:"artist,omitempty"`
+	Duration int    `json:"duration,omitempty"`
+	// FormatID downloads just this track
+	FormatID string `json:"formatId"`
+}
+
+// MusicService handles YouTube Music tracks and albums. Audio is saved as
+// m4a tagged with its music metadata and cover art and named
+// "NN - Artist - Title.m4a"; albums download as a ZIP of their tracks.
+type MusicService struct {
+	ytdlp *YtDlpService
+}
+
+// NewMusicService creates the YouTube Music backend
+func NewMusicService(ytdlp *YtDlpService) *MusicService {
+	return &MusicService{ytdlp: ytdlp}
+}
+
+func (s *MusicService) Analyze(ctx context.Context, url string) (*VideoInfo, error) {
+	info, err := s.ytdlp.sharedAnalysis(ctx, url, func(ctx context.Context) (*VideoInfo, error) {
+		return s.analyze(ctx, url)
+	})
+	metrics.ObserveOperation("analyze", string(PlatformYouTubeMusic), ClassifyError(err))
+	return info, err
+}
+
+func (s *MusicService) analyze(ctx context.Context, url string) (*VideoInfo, error) {
+	// Albums are listed flat: reading every track's page would take as long
+	// as the tracks are many, and the list has their titles and durations
+	raw, err := s.ytdlp.extractInfo(ctx, url, "--flat-playlist")
+	if err != nil {
+		return nil, err
+	}
+	if raw.Type == "playlist" {
+		return s.album(url, raw)
+	}
+	return s.track(url, raw), nil
+}
+
+func (s *MusicService) track(url string, raw *ytdlpInfo) *VideoInfo {
+	music := &MusicInfo{
+		Track:       firstNonEmpty(raw.Track, raw.Title),
+		Artist:      musicArtist(raw),
+		Album:       raw.Album,
+		Year:        raw.ReleaseYear,
+		TrackNumber: raw.TrackNumber,
+	}
+	info := &VideoInfo{
+		ID:        raw.ID,
+		Platform:  PlatformYouTubeMusic,
+		Title:     music.Track,
+		Duration:  int(raw.Duration),
+		Thumbnail: raw.Thumbnail,
+		Author:    music.Artist,
+		Music:     music,
+	}
+
+	// The audio first; tracks with a music video can also be saved as video
+	info.Formats = append(info.Formats, Format{ID: musicAudioFormat, Type: "audio", Quality: "Лучшее аудио с обложкой и тегами", Ext: "m4a"})
+	for _, f := range s.ytdlp.BestFormats(&VideoInfo{Formats: s.ytdlp.parseFormats(raw.Formats)}) {
+		if f.Type != "audio" {
+			info.Formats = append(info.Formats, f)
+		}
+	}
+
+	s.ytdlp.rememberAnalysis(url, raw, info)
+	return info
+}
+
+func (s *MusicService) album(url string, raw *ytdlpInfo) (*VideoInfo, error) {
+	if len(raw.Entries) == 0 {
+		return nil, fmt.Errorf("%w: album has no tracks", ErrMediaUnavailable)
+	}
+
+	music := &MusicInfo{
+		// yt-dlp titles album playlists "Album - NAME"
+		Album:  strings.TrimPrefix(raw.Title, "Album - "),
+		Artist: musicArtist(raw),
+		Year:   raw.ReleaseYear,
+	}
+	info := &VideoInfo{
+		ID:       raw.ID,
+		Platform: PlatformYouTubeMusic,
+		Title:    music.Album,
+		Author:   music.Artist,
+		Music:    music,
+	}
+	info.Thumbnail, _, _ = largestThumbnail(raw)
+
+	for i, entry := range raw.Entries {
+		track := MusicTrack{
+			Index:    i + 1,
+			Title:    firstNonEmpty(entry.Track, entry.Title),
+			Artist:   firstNonEmpty(musicArtist(&entry), music.Artist),
+			Duration: int(entry.Duration),
+			FormatID: musicTrackPrefix + strconv.Itoa(i+1),
+		}
+		music.Tracks = append(music.Tracks, track)
+		info.Duration += track.Duration
+		info.Formats = append(info.Formats, Format{
+			ID:      track.FormatID,
+			Type:    "audio",
+			Quality: fmt.Sprintf("%02d. %s", track.Index, track.Title),
+			Ext:     "m4a",
+		})
+	}
+	info.Formats = append(info.Formats, Format{
+		ID:      musicAlbumID,
+		Type:    "archive",
+		Quality: fmt.Sprintf("Альбом целиком (%d) в ZIP", len(music.Tracks)),
+		Ext:     "zip",
+	})
+
+	s.ytdlp.rememberAnalysis(url, raw, info)
+	return info, nil
+}
+
+// musicArtist returns the performing artist; the "Artist - Topic" channels
+// YouTube generates for music stand in when yt-dlp reports no artist
+func musicArtist(raw *ytdlpInfo) string {
+	return firstNonEmpty(raw.Artist, strings.Join(raw.Artists, ", "), strings.TrimSuffix(raw.author(), " - Topic"))
+}
+
+// musicFilename names a track "NN - Artist - Title.m4a", without the number
+// when it is unknown
+func musicFilename(number int, artist, title string) string {
+	name := title
+	if artist != "" {
+		name = artist + " - " + name
+	}
+	if number > 0 {
+		name = fmt.Sprintf("%02d - %s", number, name)
+	}
+	// Artist and title end up as a single file or ZIP entry name
+	return strings.NewReplacer("/", "_", `\`, "_").Replace(name) + ".m4a"
+}
+
+// BestFormats returns the formats as analyzed, which are already simplified
+func (s *MusicService) BestFormats(info *VideoInfo) []Format {
+	return info.Formats
+}
+
+func (s *MusicService) Identify(url string) (Platform, string) {
+	return s.ytdlp.Identify(url)
+}
+
+func (s *MusicService) Canonicalize(url string) (*CanonicalURL, error) {
+	return s.ytdlp.Canonicalize(url)
+}
+
+func (s *MusicService) EstimateWork(url, formatID string, isAudioOnly bool) DownloadWork {
+	if formatID != musicAlbumID && !strings.HasPrefix(formatID, musicTrackPrefix) {
+		return s.ytdlp.EstimateWork(url, formatID, isAudioOnly)
+	}
+
+	// Album audio is AAC stream-copied to m4a; only the length varies
+	var work DownloadWork
+	if cached := s.ytdlp.cachedAnalysis(url); cached != nil && cached.info.Music != nil {
+		for _, track := range cached.info.Music.Tracks {
+			if formatID == musicAlbumID || track.FormatID == formatID {
+				work.Duration += time.Duration(track.Duration) * time.Second
+			}
+		}
+	}
+	return work
+}
+
+// DownloadToFile downloads a track or a format of its video, one track of
+// an album ("track2") or the whole album as a ZIP ("album")
+func (s *MusicService) DownloadToFile(ctx context.Context, url, formatID, tempDir string, isAudioOnly bool) (filePath string, filename string, err error) {
+	defer func() {
+		metrics.ObserveOperation("download", string(PlatformYouTubeMusic), ClassifyError(err))
+	}()
+
+	info, err := s.info(ctx, url)
+	if err != nil {
+		return "", "", err
+	}
+
+	if info.Music.Tracks == nil {
+		if formatID != musicAudioFormat {
+			return s.ytdlp.download(ctx, url, formatID, tempDir, isAudioOnly, "--no-playlist")
+		}
+		if filePath, _, err = s.ytdlp.download(ctx, url, musicAudioFormat, tempDir, true, append([]string{"--no-playlist"}, musicArgs...)...); err != nil {
+			return "", "", err
+		}
+		return filePath, musicFilename(info.Music.TrackNumber, info.Music.Artist, info.Music.Track), nil
+	}
+
+	if formatID == musicAlbumID {
+		return s.downloadAlbum(ctx, url, info, tempDir)
+	}
+	for _, track := range info.Music.Tracks {
+		if track.FormatID == formatID {
+			args := append([]string{"--yes-playlist", "--playlist-items", strconv.Itoa(track.Index), "--parse-metadata", `playlist_index:(?P<track_number>\d+)`}, musicArgs...)
+			if filePath, _, err = s.ytdlp.download(ctx, url, musicAudioFormat, tempDir, true, args...); err != nil {
+				return "", "", err
+			}
+			return filePath, musicFilename(track.Index, track.Artist, track.Title), nil
+		}
+	}
+	return "", "", fmt.Errorf("%w: %s", ErrFormatNotAvailable, formatID)
+}
+
+// info returns the analysis of url, analyzing it unless it was analyzed recently
+func (s *MusicService) info(ctx context.Context, url string) (*VideoInfo, error) {
+	if cached := s.ytdlp.cachedAnalysis(url); cached != nil {
+		return cached.info, nil
+	}
+	return s.Analyze(ctx, url)
+}
+
+// downloadAlbum downloads every track of an album in one yt-dlp run and
+// packs them into a ZIP in album order. Unavailable tracks are skipped and
+// listed in the ZIP; the download fails only when no track is left.
+func (s *MusicService) downloadAlbum(ctx context.Context, url string, info *VideoInfo, tempDir string) (string, string, error) {
+	workDir, err := os.MkdirTemp(tempDir, "album-*")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(workDir)
+
+	args := []string{
+		"-f", musicAudioFormat,
+		"-o", filepath.Join(workDir, "%(playlist_index)03d.%(ext)s"),
+		"--no-warnings",
+		"--no-mtime",
+		"--yes-playlist",
+		"--ignore-errors",
+		"--extract-audio",
+		"--audio-format", "m4a",
+		"--audio-quality", "0",
+		"--parse-metadata", `playlist_index:(?P<track_number>\d+)`,
+	}
+	args = append(append(args, musicArgs...), url)
+	// yt-dlp fails after downloading the rest when some tracks are unavailable;
+	// which tracks made it is read from the files
+	runErr := s.ytdlp.runDownload(ctx, args...)
+	if ctx.Err() != nil {
+		return "", "", runErr
+	}
+
+	archive, err := os.CreateTemp(tempDir, "album-*.zip")
+	if err != nil {
+		return "", "", err
+	}
+	fail := func(err error) (string, string, error) {
+		archive.Close()
+		os.Remove(archive.Name())
+		return "", "", err
+	}
+
+	zipWriter := zip.NewWriter(archive)
+	var skipped []string
+	for _, track := range info.Music.Tracks {
+		trackPath := filepath.Join(workDir, fmt.Sprintf("%03d.m4a", track.Index))
+		if _, err := os.Stat(trackPath); err != nil {
+			skipped = append(skipped, strings.TrimSuffix(musicFilename(track.Index, track.Artist, track.Title), ".m4a"))
+			continue
+		}
+		if err := addToZip(zipWriter, musicFilename(track.Index, track.Artist, track.Title), trackPath); err != nil {
+			return fail(err)
+		}
+	}
+	switch {
+	case len(skipped) == len(info.Music.Tracks) && runErr != nil:
+		return fail(runErr)
+	case len(skipped) == len(info.Music.Tracks):
+		return fail(fmt.Errorf("%w: no track of the album could be downloaded", ErrMediaUnavailable))
+	case len(skipped) > 0:
+		entry, err := zipWriter.CreateHeader(&zip.FileHeader{Name: musicSkippedName, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return fail(err)
+		}
+		if _, err := io.WriteString(entry, "Недоступны и не скачаны:\n"+strings.Join(skipped, "\n")+"\n"); err != nil {
+			return fail(err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		return fail(err)
+	}
+	if err := archive.Close(); err != nil {
+		os.Remove(archive.Name())
+		return "", "", err
+	}
+
+	name := info.Music.Album
+	if info.Music.Artist != "" {
+		name = info.Music.Artist + " - " + name
+	}
+	return archive.Name(), name + ".zip", nil
+}
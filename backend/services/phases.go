@@ -0,0 +1,111 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"viddown/metrics"
+	"viddown/tracing"
+)
+
+// phaseMarkers map yt-dlp stdout prefixes to the ffmpeg phase they start.
+// Everything before the first marker is the download itself.
+var phaseMarkers = []struct {
+	prefix string
+	phase  string
+}{
+	{"[Merger]", "merge"},
+	{"[ExtractAudio]", "postprocess"},
+	{"[VideoConvertor]", "postprocess"},
+	{"[VideoRemuxer]", "postprocess"},
+	{"[Fixup", "postprocess"},
+	{"[FFmpeg", "postprocess"},
+}
+
+type phaseSpan struct {
+	phase string
+	start time.Time
+	span  trace.Span
+}
+
+// progressPattern matches intermediate download progress updates,
+// which would otherwise flood the process log
+var progressPattern = regexp.MustCompile(`^\[download\]\s+\d+(\.\d+)?% of`)
+
+// runPhased runs a yt-dlp download, following its stdout to time the
+// download, merge and post-processing phases separately.
+// Each phase is also recorded as a child span of ctx, and stdout is
+// copied to the invocation log without progress noise.
+func runPhased(ctx context.Context, inv *invocation) error {
+	cmd := inv.cmd
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	_, span := tracing.Start(ctx, "phase download")
+	spans := []phaseSpan{{phase: "download", start: time.Now(), span: span}}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(scanLinesOrCR)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !progressPattern.MatchString(line) || strings.Contains(line, "100%") {
+			inv.log.WriteLine(line)
+		}
+		for _, marker := range phaseMarkers {
+			if strings.HasPrefix(line, marker.prefix) {
+				if last := spans[len(spans)-1]; last.phase != marker.phase {
+					now := time.Now()
+					last.span.End(trace.WithTimestamp(now))
+					_, span := tracing.Start(ctx, "phase "+marker.phase, attribute.String("phase.marker", line))
+					spans = append(spans, phaseSpan{phase: marker.phase, start: now, span: span})
+				}
+				break
+			}
+		}
+	}
+
+	err = cmd.Wait()
+	end := time.Now()
+	for i, span := range spans {
+		spanEnd := end
+		if i+1 < len(spans) {
+			spanEnd = spans[i+1].start
+		}
+		// Only the last phase can have failed, the earlier ones completed
+		var phaseErr error
+		if i == len(spans)-1 {
+			phaseErr = err
+		}
+		metrics.ObservePhase(span.phase, spanEnd.Sub(span.start), phaseErr)
+	}
+	tracing.End(spans[len(spans)-1].span, err)
+	return err
+}
+
+// scanLinesOrCR splits on \n and on the \r yt-dlp uses for progress updates
+func scanLinesOrCR(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
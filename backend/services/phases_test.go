@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"viddown/tracing"
+)
+
+// fakeYtDlp writes a script standing in for yt-dlp that prints a download
+// followed by a merge, as yt-dlp does for separate video and audio streams
+func fakeYtDlp(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "yt-dlp")
+	script := `#!/bin/sh
+echo "[download] Destination: video.f137.mp4"
+printf "[download]  50.0%% of 10.00MiB\r[download] 100%% of 10.00MiB\n"
+echo '[Merger] Merging formats into "video.mp4"'
+echo "[FixupM3u8] Fixing container"
+`
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// The tracing package's tracer binds to the first global provider installed,
+// so every test in the process shares one provider and exporter
+var (
+	testTracing  sync.Once
+	testExporter *tracetest.InMemoryExporter
+	testProvider *sdktrace.TracerProvider
+)
+
+func installTestProvider() (*tracetest.InMemoryExporter, *sdktrace.TracerProvider) {
+	testTracing.Do(func() {
+		testExporter = tracetest.NewInMemoryExporter()
+		testProvider = tracing.NewProvider(testExporter, tracing.Config{SampleRatio: 1})
+		otel.SetTracerProvider(testProvider)
+	})
+	testExporter.Reset()
+	return testExporter, testProvider
+}
+
+func TestDownloadSpans(t *testing.T) {
+	exporter, provider := installTestProvider()
+
+	ytdlp := NewYtDlpService(fakeYtDlp(t), nil, NewProcessLogStore(10, 64*1024))
+	router := chi.NewRouter()
+	router.Use(tracing.Middleware)
+	router.Get("/api/download", func(w http.ResponseWriter, r *http.Request) {
+		if err := ytdlp.runDownload(r.Context(), "-f", "bv*+ba", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"); err != nil {
+			t.Errorf("download: %v", err)
+		}
+	})
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/download?url=x", nil))
+	if err := provider.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	byName := make(map[string]tracetest.SpanStub)
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	for _, name := range []string{"GET /api/download", "yt-dlp download", "phase download", "phase merge", "phase postprocess"} {
+		if _, ok := byName[name]; !ok {
+			t.Fatalf("missing span %q, got %d spans: %v", name, len(spans), spanNames(spans))
+		}
+	}
+
+	server := byName["GET /api/download"]
+	if server.SpanKind != trace.SpanKindServer {
+		t.Errorf("handler span kind = %v, want server", server.SpanKind)
+	}
+	if got := response.Header().Get(tracing.TraceIDHeader); got != server.SpanContext.TraceID().String() {
+		t.Errorf("%s = %q, want the trace ID %s", tracing.TraceIDHeader, got, server.SpanContext.TraceID())
+	}
+	wantAttribute(t, server, "http.route", attribute.StringValue("/api/download"))
+	wantAttribute(t, server, "http.response.status_code", attribute.IntValue(http.StatusOK))
+
+	command := byName["yt-dlp download"]
+	if command.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Error("yt-dlp span is not a child of the handler span")
+	}
+	wantAttribute(t, command, "process.command_args", attribute.StringSliceValue([]string{"-f", "bv*+ba", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"}))
+
+	for _, name := range []string{"phase download", "phase merge", "phase postprocess"} {
+		phase := byName[name]
+		if phase.Parent.SpanID() != command.SpanContext.SpanID() {
+			t.Errorf("%s is not a child of the yt-dlp span", name)
+		}
+		if phase.Status.Code == codes.Error {
+			t.Errorf("%s failed: %s", name, phase.Status.Description)
+		}
+	}
+	wantAttribute(t, byName["phase merge"], "phase.marker", attribute.StringValue(`[Merger] Merging formats into "video.mp4"`))
+
+	// Phases follow each other
+	if byName["phase merge"].StartTime.Before(byName["phase download"].EndTime) {
+		t.Error("merge phase starts before the download ends")
+	}
+}
+
+func wantAttribute(t *testing.T, span tracetest.SpanStub, key string, want attribute.Value) {
+	t.Helper()
+	for _, attr := range span.Attributes {
+		if string(attr.Key) == key {
+			if attr.Value != want {
+				t.Errorf("%s: %s = %v, want %v", span.Name, key, attr.Value.Emit(), want.Emit())
+			}
+			return
+		}
+	}
+	t.Errorf("%s: missing attribute %s", span.Name, key)
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name
+	}
+	return names
+}
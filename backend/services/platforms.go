@@ -0,0 +1,422 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// PlatformSpec declares how a platform's URLs are recognized and how the
+// platform is presented to users
+type PlatformSpec struct {
+	Platform Platform
+	// Name is shown to users, e.g. in the list of supported platforms
+	Name string
+	// Hosts are exact domains, each also matching its subdomains
+	Hosts []string
+	// Paths are the URL paths of media on those hosts; other pages are rejected.
+	// Empty accepts any path.
+	Paths []*regexp.Regexp
+	// ShortPaths are further paths accepted on one exact host only, such as
+	// the codes of a link shortener
+	ShortPaths map[string][]*regexp.Regexp
+	// ExcludedPaths are pages rejected even though Paths match them, for
+	// the reserved names Go's regexps can't exclude without lookahead
+	ExcludedPaths []*regexp.Regexp
+	// ExtractID returns the platform's media ID for a URL, or "" when it has none
+	ExtractID func(u *url.URL) string
+	// Canonicalize returns the canonical form of a URL with the given ID;
+	// nil only drops tracking parameters
+	Canonicalize func(u *url.URL, id string) string
+	// ThumbnailHosts are the domains serving the platform's thumbnails
+	ThumbnailHosts []string
+	// Enabled is whether the platform is on unless configured otherwise
+	Enabled bool
+}
+
+// youtubeID matches the 11-character YouTube video ID
+var youtubeID = regexp.MustCompile(`^[\w-]{11}$`)
+
+// DefaultPlatforms returns the built-in platform definitions
+func DefaultPlatforms() []PlatformSpec {
+	return []PlatformSpec{
+		{
+			Platform: PlatformYouTube,
+			Name:     "YouTube",
+			Hosts:    []string{"youtube.com", "youtu.be", "youtube-nocookie.com"},
+			Paths: []*regexp.Regexp{
+				regexp.MustCompile(`^/watch/?$`),
+				regexp.MustCompile(`^/(shorts|embed|live|v|e)/[\w-]{11}/?$`),
+				regexp.MustCompile(`^/[\w-]{11}/?$`),
+			},
+			ExtractID:      youtubeVideoID,
+			Canonicalize:   canonicalYouTube,
+			ThumbnailHosts: []string{"ytimg.com", "img.youtube.com", "ggpht.com"},
+			Enabled:        true,
+		},
+		{
+			// More specific than youtube.com, so music links land here
+			Platform: PlatformYouTubeMusic,
+			Name:     "YouTube Music",
+			Hosts:    []string{"music.youtube.com"},
+			Paths: []*regexp.Regexp{
+				regexp.MustCompile(`^/watch/?$`),
+				// Albums and playlists, albums also by their browse page
+				regexp.MustCompile(`^/playlist/?$`),
+				regexp.MustCompile(`^/browse/MPREb_[\w-]+/?$`),
+			},
+			ExtractID:      youtubeMusicID,
+			Canonicalize:   canonicalYouTubeMusic,
+			ThumbnailHosts: []string{"ytimg.com", "ggpht.com", "googleusercontent.com"},
+			Enabled:        true,
+		},
+		{
+			Platform: PlatformInstagram,
+			Name:     "Instagram",
+			Hosts:    []string{"instagram.com", "instagr.am"},
+			Paths: []*regexp.Regexp{
+				regexp.MustCompile(`^(/[\w.]+)?/(p|reel|reels|tv)/[\w-]+/?$`),
+			},
+			ExtractID:      pathSegmentAfter("p", "reel", "reels", "tv"),
+			Canonicalize:   canonicalInstagram,
+			ThumbnailHosts: []string{"cdninstagram.com", "instagram.com", "fbcdn.net"},
+			Enabled:        true,
+		},
+		{
+			Platform: PlatformTikTok,
+			Name:     "TikTok",
+			Hosts:    []string{"tiktok.com"},
+			Paths: []*regexp.Regexp{
+				regexp.MustCompile(`^/@[\w.-]+/video/\d+/?$`),
+				regexp.MustCompile(`^/(v|embed|embed/v2)/\d+(\.html)?/?$`),
+				// Short links (tiktok.com/t/ZT...)
+				regexp.MustCompile(`^/t/\w+/?$`),
+			},
+			// Short links (vm.tiktok.com/ZM..., vt.tiktok.com/ZS...)
+			ShortPaths: map[string][]*regexp.Regexp{
+				"vm.tiktok.com": {regexp.MustCompile(`^/\w+/?$`)},
+				"vt.tiktok.com": {regexp.MustCompile(`^/\w+/?$`)},
+			},
+			ExtractID:      tiktokVideoID,
+			Canonicalize:   canonicalTikTok,
+			ThumbnailHosts: []string{"tiktokcdn.com", "tiktokcdn-us.com", "tiktokcdn-eu.com"},
+			Enabled:        true,
+		},
+		{
+			// Media files and HLS/DASH manifests; its hosts are set by SetDirectHosts
+			Platform:     PlatformDirect,
+			Name:         "Direct links",
+			Canonicalize: canonicalDirect,
+			Enabled:      true,
+		},
+		// The platforms below are off unless listed in enabled_platforms
+		{
+			Platform: PlatformVimeo,
+			Name:     "Vimeo",
+			Hosts:    []string{"vimeo.com"},
+			Paths: []*regexp.Regexp{
+				// Unlisted videos are shared as vimeo.com/ID/HASH
+				regexp.MustCompile(`^/\d+(/[0-9a-f]+)?/?$`),
+				regexp.MustCompile(`^/video/\d+/?$`),
+				regexp.MustCompile(`^/(channels/[\w-]+|groups/[\w-]+/videos|album/\d+/video|showcase/\d+/video)/\d+/?$`),
+			},
+			ExtractID:      vimeoVideoID,
+			Canonicalize:   canonicalVimeo,
+			ThumbnailHosts: []string{"vimeocdn.com"},
+		},
+		{
+			Platform: PlatformX,
+			Name:     "X (Twitter)",
+			Hosts:    []string{"x.com", "twitter.com"},
+			Paths: []*regexp.Regexp{
+				regexp.MustCompile(`^/\w+/status(es)?/\d+(/(video|photo)/\d)?/?$`),
+				regexp.MustCompile(`^/i/(web/)?status/\d+/?$`),
+			},
+			ExtractID:      pathSegmentAfter("status", "statuses"),
+			Canonicalize:   canonicalX,
+			ThumbnailHosts: []string{"twimg.com"},
+		},
+		{
+			Platform: PlatformReddit,
+			Name:     "Reddit",
+			Hosts:    []string{"reddit.com", "redd.it"},
+			Paths: []*regexp.Regexp{
+				regexp.MustCompile(`^(/(r|u|user)/[\w-]+)?/comments/\w+(/[^/]*)?/?$`),
+				// Share links (reddit.com/r/sub/s/CODE)
+				regexp.MustCompile(`^/(r|u|user)/[\w-]+/s/\w+/?$`),
+			},
+			// Short links (redd.it/ID)
+			ShortPaths: map[string][]*regexp.Regexp{
+				"redd.it": {regexp.MustCompile(`^/\w+/?$`)},
+			},
+			ExtractID:      redditPostID,
+			Canonicalize:   canonicalReddit,
+			ThumbnailHosts: []string{"redd.it", "redditmedia.com"},
+		},
+		{
+			Platform: PlatformSoundCloud,
+			Name:     "SoundCloud",
+			Hosts:    []string{"soundcloud.com"},
+			Paths: []*regexp.Regexp{
+				// Tracks, private ones with their secret token (/s-TOKEN)
+				regexp.MustCompile(`^/[\w-]+/[\w-]+(/s-\w+)?/?$`),
+			},
+			// Short links (on.soundcloud.com/CODE)
+			ShortPaths: map[string][]*regexp.Regexp{
+				"on.soundcloud.com": {regexp.MustCompile(`^/\w+/?$`)},
+			},
+			// Profile pages that look like artist/track
+			ExcludedPaths: []*regexp.Regexp{
+				regexp.MustCompile(`^/[\w-]+/(sets|likes|reposts|tracks|albums|popular-tracks|followers|following)/?$`),
+			},
+			Canonicalize:   canonicalSoundCloud,
+			ThumbnailHosts: []string{"sndcdn.com"},
+		},
+		{
+			Platform: PlatformTwitch,
+			Name:     "Twitch",
+			Hosts:    []string{"twitch.tv"},
+			Paths: []*regexp.Regexp{
+				regexp.MustCompile(`^/videos/\d+/?$`),
+				regexp.MustCompile(`^/\w+/(v|video)/\d+/?$`),
+				regexp.MustCompile(`^/\w+/clip/[\w-]+/?$`),
+			},
+			ExtractID:      pathSegmentAfter("videos", "v", "video", "clip"),
+			Canonicalize:   canonicalTwitch,
+			ThumbnailHosts: []string{"jtvnw.net"},
+		},
+	}
+}
+
+// PlatformRegistry recognizes media URLs by the platforms declared to it
+type PlatformRegistry struct {
+	specs       []PlatformSpec
+	enabled     atomic.Pointer[map[Platform]bool]
+	directHosts atomic.Pointer[HostAllowlist]
+}
+
+// NewPlatformRegistry creates a registry with each platform's default enabled state
+func NewPlatformRegistry(specs []PlatformSpec) *PlatformRegistry {
+	r := &PlatformRegistry{specs: specs}
+	r.SetEnabled(nil)
+	r.SetDirectHosts(nil)
+	return r
+}
+
+// SetDirectHosts sets the hosts whose URLs are direct media links;
+// without any, direct links are not offered
+func (r *PlatformRegistry) SetDirectHosts(hosts []string) {
+	r.directHosts.Store(NewHostAllowlist(hosts))
+}
+
+// SetEnabled turns on exactly the named platforms; an empty list restores
+// the defaults. Unknown names are rejected and nothing changes.
+func (r *PlatformRegistry) SetEnabled(names []string) error {
+	if err := r.CheckNames(names); err != nil {
+		return err
+	}
+
+	enabled := make(map[Platform]bool)
+	for _, spec := range r.specs {
+		enabled[spec.Platform] = spec.Enabled
+		if len(names) > 0 {
+			enabled[spec.Platform] = slices.Contains(names, string(spec.Platform))
+		}
+	}
+	r.enabled.Store(&enabled)
+	return nil
+}
+
+// CheckNames reports platform names the registry doesn't know
+func (r *PlatformRegistry) CheckNames(names []string) error {
+	var errs []error
+	for _, name := range names {
+		if _, ok := r.Lookup(Platform(name)); !ok {
+			errs = append(errs, fmt.Errorf("unknown platform %q, known: %s", name, strings.Join(r.ids(), ", ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Lookup returns the definition of a platform
+func (r *PlatformRegistry) Lookup(platform Platform) (*PlatformSpec, bool) {
+	for i := range r.specs {
+		if r.specs[i].Platform == platform {
+			return &r.specs[i], true
+		}
+	}
+	return nil, false
+}
+
+// Enabled returns the enabled platforms in declaration order, leaving out
+// direct links while no direct hosts are set
+func (r *PlatformRegistry) Enabled() []PlatformSpec {
+	enabled := *r.enabled.Load()
+	var specs []PlatformSpec
+	for _, spec := range r.specs {
+		if spec.Platform == PlatformDirect && len(r.directHosts.Load().entries) == 0 {
+			continue
+		}
+		if enabled[spec.Platform] {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+// IsEnabled reports whether platform is enabled
+func (r *PlatformRegistry) IsEnabled(platform Platform) bool {
+	return (*r.enabled.Load())[platform]
+}
+
+// Names lists the display names of the enabled platforms, for messages
+func (r *PlatformRegistry) Names() string {
+	var names []string
+	for _, spec := range r.Enabled() {
+		names = append(names, spec.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// ThumbnailHosts returns the thumbnail domains of the enabled platforms
+func (r *PlatformRegistry) ThumbnailHosts() []string {
+	var hosts []string
+	for _, spec := range r.Enabled() {
+		hosts = append(hosts, spec.ThumbnailHosts...)
+	}
+	return hosts
+}
+
+// Match returns the platform whose hosts contain u's host, preferring the
+// most specific host when several match, and else direct links when u is on
+// a direct host. It returns nil when no platform claims the host, and
+// ErrUnsupportedURL when the platform is disabled or the path isn't a media page.
+func (r *PlatformRegistry) Match(u *url.URL) (*PlatformSpec, error) {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+
+	var match *PlatformSpec
+	matched := ""
+	for i := range r.specs {
+		for _, suffix := range r.specs[i].Hosts {
+			if (host == suffix || strings.HasSuffix(host, "."+suffix)) && len(suffix) > len(matched) {
+				match, matched = &r.specs[i], suffix
+			}
+		}
+	}
+	if match == nil && r.directHosts.Load().AllowsURL(u) {
+		match, _ = r.Lookup(PlatformDirect)
+	}
+	if match == nil {
+		return nil, nil
+	}
+
+	if !r.IsEnabled(match.Platform) {
+		return match, fmt.Errorf("%w: %s is disabled", ErrUnsupportedURL, match.Name)
+	}
+	paths := append(slices.Clip(match.Paths), match.ShortPaths[host]...)
+	matches := func(p *regexp.Regexp) bool { return p.MatchString(u.Path) }
+	if (len(paths) > 0 && !slices.ContainsFunc(paths, matches)) || slices.ContainsFunc(match.ExcludedPaths, matches) {
+		return match, fmt.Errorf("%w: not a %s media link", ErrUnsupportedURL, match.Name)
+	}
+	return match, nil
+}
+
+func (r *PlatformRegistry) ids() []string {
+	ids := make([]string, len(r.specs))
+	for i, spec := range r.specs {
+		ids[i] = string(spec.Platform)
+	}
+	return ids
+}
+
+// youtubeVideoID reads the ID from watch?v=, youtu.be/ID and /shorts/ID-style paths
+func youtubeVideoID(u *url.URL) string {
+	if id := u.Query().Get("v"); youtubeID.MatchString(id) {
+		return id
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	id := segments[len(segments)-1]
+	if len(segments) <= 2 && youtubeID.MatchString(id) {
+		return id
+	}
+	return ""
+}
+
+// youtubeMusicID reads the video ID of a track, or the playlist or browse
+// ID of an album
+func youtubeMusicID(u *url.URL) string {
+	switch {
+	case strings.HasPrefix(u.Path, "/playlist"):
+		return u.Query().Get("list")
+	case strings.HasPrefix(u.Path, "/browse/"):
+		return pathSegmentAfter("browse")(u)
+	default:
+		return youtubeVideoID(u)
+	}
+}
+
+// tiktokVideoID reads the numeric ID of /@user/video/ID and /v/ID links.
+// Short links carry no ID until resolved.
+func tiktokVideoID(u *url.URL) string {
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	id := strings.TrimSuffix(segments[len(segments)-1], ".html")
+	if len(segments) >= 2 && strings.Trim(id, "0123456789") == "" {
+		return id
+	}
+	return ""
+}
+
+// vimeoVideoID reads the numeric ID of vimeo.com/ID, player.vimeo.com/video/ID
+// and channel, group and showcase links. Unlisted videos get ID/HASH, with the
+// hash from vimeo.com/ID/HASH or player.vimeo.com's ?h=: without it the video
+// can't be opened, so it must not share a key with the bare ID.
+func vimeoVideoID(u *url.URL) string {
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	hash := u.Query().Get("h")
+
+	id := pathSegmentAfter("video", "videos")(u)
+	switch {
+	case id != "":
+	case strings.Trim(segments[0], "0123456789") == "":
+		id = segments[0]
+		if len(segments) == 2 {
+			hash = segments[1]
+		}
+	default:
+		id = segments[len(segments)-1]
+	}
+	if hash != "" {
+		return id + "/" + hash
+	}
+	return id
+}
+
+// redditPostID reads the post ID of /comments/ID links and redd.it/ID.
+// Share links carry no ID until resolved.
+func redditPostID(u *url.URL) string {
+	if id := pathSegmentAfter("comments")(u); id != "" {
+		return id
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "redd.it" {
+		return strings.Trim(u.Path, "/")
+	}
+	return ""
+}
+
+// pathSegmentAfter returns an extractor for the segment following any of markers
+func pathSegmentAfter(markers ...string) func(u *url.URL) string {
+	return func(u *url.URL) string {
+		segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+		for i := 0; i < len(segments)-1; i++ {
+			if slices.Contains(markers, segments[i]) {
+				return segments[i+1]
+			}
+		}
+		return ""
+	}
+}
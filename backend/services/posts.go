@@ -0,0 +1,373 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"viddown/metrics"
+)
+
+const (
+	// postArchiveID is the format downloading every item of a post as a ZIP
+	postArchiveID = "all"
+	// postItemPrefix starts the format of a single item, "item2" for the second
+	postItemPrefix = "item"
+	// postVideoFormat is the yt-dlp format of a video item of a carousel
+	postVideoFormat = "bv*+ba/b"
+)
+
+// MediaItem is one photo or video of a post with several of them
+type MediaItem struct {
+	// Index is the 1-based position in the post
+	Index     int    `json:"index"`
+	Type      string `json:"type"` // "video" or "image"
+	Thumbnail string `json:"thumbnail"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Duration  int    `json:"duration,omitempty"`
+	// FormatID downloads just this item
+	FormatID string `json:"formatId"`
+}
+
+// postItem is a photo or video of a post as reported by yt-dlp
+type postItem struct {
+	MediaItem
+	// imageURL is the full-size photo; videos are downloaded by yt-dlp
+	imageURL string
+}
+
+// PostService handles platforms whose posts hold one or several photos and
+// videos: Instagram reels and carousels, tweets with several videos.
+// Videos are downloaded by yt-dlp, photos straight from the platform's CDN
+// through fetcher. Login-gated content needs a cookie file.
+type PostService struct {
+	platform    Platform
+	ytdlp       *YtDlpService
+	fetcher     *SafeFetcher
+	cookiesFile string
+}
+
+// NewPostService creates the backend of a post platform; cookiesFile is a
+// Netscape cookie file of a logged-in session, or "" for public content only
+func NewPostService(platform Platform, ytdlp *YtDlpService, fetcher *SafeFetcher, cookiesFile string) *PostService {
+	return &PostService{platform: platform, ytdlp: ytdlp, fetcher: fetcher, cookiesFile: cookiesFile}
+}
+
+func (s *PostService) Analyze(ctx context.Context, url string) (*VideoInfo, error) {
+	info, err := s.ytdlp.sharedAnalysis(ctx, url, func(ctx context.Context) (*VideoInfo, error) {
+		return s.analyze(ctx, url)
+	})
+	metrics.ObserveOperation("analyze", string(s.platform), ClassifyError(err))
+	return info, err
+}
+
+func (s *PostService) analyze(ctx context.Context, url string) (*VideoInfo, error) {
+	cookies, cleanup, err := cookieArgs(s.cookiesFile)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	// Photos have no formats; without --ignore-no-formats-error yt-dlp
+	// rejects every post that contains one
+	raw, err := s.ytdlp.extractInfo(ctx, url, append([]string{"--yes-playlist", "--ignore-no-formats-error"}, cookies...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	// A post with a single video is offered in its qualities like a reel or
+	// a plain tweet
+	if raw.Type == "playlist" && len(raw.Entries) == 1 && len(raw.Entries[0].Formats) > 0 {
+		entry := raw.Entries[0]
+		entry.Title = firstNonEmpty(raw.Title, entry.Title)
+		raw = &entry
+	}
+
+	items := postItems(raw)
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: post has no photos or videos", ErrMediaUnavailable)
+	}
+
+	info := &VideoInfo{
+		ID:        raw.ID,
+		Platform:  s.platform,
+		Title:     raw.Title,
+		Duration:  int(raw.Duration),
+		Thumbnail: firstNonEmpty(raw.Thumbnail, items[0].Thumbnail),
+	}
+	if len(items) == 1 && items[0].Type == "video" {
+		info.Formats = mergedFormats(raw.Formats)
+	} else {
+		for _, item := range items {
+			info.Items = append(info.Items, item.MediaItem)
+			info.Formats = append(info.Formats, postItemFormat(item, len(items)))
+		}
+		if len(items) > 1 {
+			info.Formats = append(info.Formats, Format{
+				ID:      postArchiveID,
+				Type:    "archive",
+				Quality: fmt.Sprintf("Все файлы (%d) в ZIP", len(items)),
+				Ext:     "zip",
+			})
+		}
+	}
+
+	s.ytdlp.rememberAnalysis(url, raw, info)
+	return info, nil
+}
+
+// postItems lists the photos and videos of a post, or the post itself
+// when it is a single video or photo
+func postItems(raw *ytdlpInfo) []postItem {
+	entries := []ytdlpInfo{*raw}
+	if raw.Type == "playlist" {
+		entries = raw.Entries
+	}
+
+	var items []postItem
+	for i, entry := range entries {
+		item := postItem{MediaItem: MediaItem{
+			Index:     i + 1,
+			Type:      "video",
+			Thumbnail: entry.Thumbnail,
+			Width:     entry.Width,
+			Height:    entry.Height,
+			Duration:  int(entry.Duration),
+			FormatID:  postItemPrefix + strconv.Itoa(i+1),
+		}}
+		if len(entry.Formats) == 0 {
+			item.Type = "image"
+			item.Duration = 0
+			item.imageURL, item.Width, item.Height = largestThumbnail(&entry)
+			if item.imageURL == "" {
+				continue
+			}
+		}
+		if item.Thumbnail == "" {
+			item.Thumbnail = item.imageURL
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// largestThumbnail returns the biggest image yt-dlp reports for an entry,
+// which for a photo is the photo itself
+func largestThumbnail(entry *ytdlpInfo) (string, int, int) {
+	best := ytdlpThumbnail{URL: entry.Thumbnail, Width: entry.Width, Height: entry.Height}
+	for _, thumbnail := range entry.Thumbnails {
+		if thumbnail.URL != "" && thumbnail.Width*thumbnail.Height >= best.Width*best.Height {
+			best = thumbnail
+		}
+	}
+	return best.URL, best.Width, best.Height
+}
+
+func postItemFormat(item postItem, count int) Format {
+	if item.Type == "image" {
+		return Format{
+			ID:      item.FormatID,
+			Type:    "image",
+			Quality: fmt.Sprintf("Фото %d из %d", item.Index, count),
+			Ext:     strings.TrimPrefix(imageExt(item.imageURL), "."),
+		}
+	}
+	return Format{
+		ID:      item.FormatID,
+		Type:    "video",
+		Quality: fmt.Sprintf("Видео %d из %d", item.Index, count),
+		Ext:     "mp4",
+	}
+}
+
+// BestFormats returns the formats as analyzed, which are already simplified
+func (s *PostService) BestFormats(info *VideoInfo) []Format {
+	return info.Formats
+}
+
+func (s *PostService) Identify(url string) (Platform, string) {
+	return s.ytdlp.Identify(url)
+}
+
+func (s *PostService) Canonicalize(url string) (*CanonicalURL, error) {
+	return s.ytdlp.Canonicalize(url)
+}
+
+func (s *PostService) EstimateWork(url, formatID string, isAudioOnly bool) DownloadWork {
+	return s.ytdlp.EstimateWork(url, formatID, isAudioOnly)
+}
+
+// DownloadToFile downloads a format of a video, one item of a post
+// ("item2") or all items of a post as a ZIP ("all")
+func (s *PostService) DownloadToFile(ctx context.Context, url, formatID, tempDir string, isAudioOnly bool) (filePath string, filename string, err error) {
+	defer func() {
+		metrics.ObserveOperation("download", string(s.platform), ClassifyError(err))
+	}()
+
+	if formatID != postArchiveID && !strings.HasPrefix(formatID, postItemPrefix) {
+		return s.downloadVideo(ctx, url, formatID, tempDir, isAudioOnly, 1)
+	}
+
+	title, items, err := s.post(ctx, url)
+	if err != nil {
+		return "", "", err
+	}
+	if formatID == postArchiveID {
+		return s.downloadArchive(ctx, url, title, items, tempDir)
+	}
+	for _, item := range items {
+		if item.FormatID == formatID {
+			return s.downloadItem(ctx, url, title, item, tempDir)
+		}
+	}
+	return "", "", fmt.Errorf("%w: %s", ErrFormatNotAvailable, formatID)
+}
+
+// post returns the title and items of the post at url, analyzing it unless
+// it was analyzed recently
+func (s *PostService) post(ctx context.Context, url string) (string, []postItem, error) {
+	cached := s.ytdlp.cachedAnalysis(url)
+	if cached == nil {
+		if _, err := s.Analyze(ctx, url); err != nil {
+			return "", nil, err
+		}
+		if cached = s.ytdlp.cachedAnalysis(url); cached == nil {
+			return "", nil, fmt.Errorf("%w: post could not be analyzed", ErrMediaUnavailable)
+		}
+	}
+	return cached.info.Title, postItems(cached.raw), nil
+}
+
+// downloadVideo downloads formatID of the index-th item with yt-dlp
+func (s *PostService) downloadVideo(ctx context.Context, url, formatID, tempDir string, isAudioOnly bool, index int) (string, string, error) {
+	cookies, cleanup, err := cookieArgs(s.cookiesFile)
+	if err != nil {
+		return "", "", err
+	}
+	defer cleanup()
+
+	args := append([]string{"--yes-playlist", "--playlist-items", strconv.Itoa(index)}, cookies...)
+	return s.ytdlp.download(ctx, url, formatID, tempDir, isAudioOnly, args...)
+}
+
+func (s *PostService) downloadItem(ctx context.Context, url, title string, item postItem, tempDir string) (string, string, error) {
+	if item.Type == "video" {
+		return s.downloadVideo(ctx, url, postVideoFormat, tempDir, false, item.Index)
+	}
+	return s.downloadImage(ctx, title, item, tempDir)
+}
+
+// downloadImage saves a photo of a post into tempDir
+func (s *PostService) downloadImage(ctx context.Context, title string, item postItem, tempDir string) (string, string, error) {
+	resp, err := s.fetcher.Get(ctx, item.imageURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("%w: upstream returned %s", ErrMediaUnavailable, resp.Status)
+	}
+	if contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); !strings.HasPrefix(contentType, "image/") {
+		return "", "", fmt.Errorf("%w: %s", ErrNotMedia, resp.Header.Get("Content-Type"))
+	}
+
+	ext := imageExt(item.imageURL)
+	file, err := os.CreateTemp(tempDir, string(s.platform)+"-*"+ext)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", "", err
+	}
+	return file.Name(), fmt.Sprintf("%s (%d)%s", title, item.Index, ext), nil
+}
+
+// downloadArchive downloads every item of a post and packs them into a ZIP
+// named by their positions: 01.mp4, 02.jpg, ...
+func (s *PostService) downloadArchive(ctx context.Context, url, title string, items []postItem, tempDir string) (string, string, error) {
+	workDir, err := os.MkdirTemp(tempDir, string(s.platform)+"-*")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(workDir)
+
+	archive, err := os.CreateTemp(tempDir, string(s.platform)+"-*.zip")
+	if err != nil {
+		return "", "", err
+	}
+	fail := func(err error) (string, string, error) {
+		archive.Close()
+		os.Remove(archive.Name())
+		return "", "", err
+	}
+
+	zipWriter := zip.NewWriter(archive)
+	for _, item := range items {
+		filePath, _, err := s.downloadItem(ctx, url, title, item, workDir)
+		if err != nil {
+			return fail(fmt.Errorf("item %d: %w", item.Index, err))
+		}
+		name := fmt.Sprintf("%02d%s", item.Index, filepath.Ext(filePath))
+		if err := addToZip(zipWriter, name, filePath); err != nil {
+			return fail(err)
+		}
+		// Items are packed one by one, so the work directory holds at most one
+		os.Remove(filePath)
+	}
+	if err := zipWriter.Close(); err != nil {
+		return fail(err)
+	}
+	if err := archive.Close(); err != nil {
+		os.Remove(archive.Name())
+		return "", "", err
+	}
+	return archive.Name(), title + ".zip", nil
+}
+
+// addToZip stores the file at filePath uncompressed, as media is compressed already
+func addToZip(zipWriter *zip.Writer, name, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entry, err := zipWriter.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, file)
+	return err
+}
+
+// imageExt returns the extension of an image URL, .jpg when it has none
+func imageExt(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ".jpg"
+	}
+	switch ext := strings.ToLower(path.Ext(u.Path)); ext {
+	case ".jpg", ".jpeg", ".png", ".webp", ".heic":
+		return ext
+	default:
+		return ".jpg"
+	}
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+// ProcessLog is the captured output of a single yt-dlp invocation.
+// Only the last maxBytes of output are kept.
+type ProcessLog struct {
+	mu        sync.Mutex
+	operation string
+	args      []string
+	started   time.Time
+	finished  time.Time
+	err       string
+	buf       []byte
+	maxBytes  int
+	dropped   int64
+}
+
+// ProcessLogEntry is a snapshot of a ProcessLog
+type ProcessLogEntry struct {
+	Operation string    `json:"operation"`
+	Args      []string  `json:"args"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	// DroppedBytes counts output discarded from the start of the ring buffer
+	DroppedBytes int64    `json:"droppedBytes,omitempty"`
+	Output       []string `json:"output"`
+}
+
+// Write appends output, discarding the oldest bytes beyond the buffer size
+func (l *ProcessLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := len(p)
+	if len(p) >= l.maxBytes {
+		l.dropped += int64(len(l.buf) + len(p) - l.maxBytes)
+		l.buf = append(l.buf[:0], p[len(p)-l.maxBytes:]...)
+		return n, nil
+	}
+	if overflow := len(l.buf) + len(p) - l.maxBytes; overflow > 0 {
+		l.dropped += int64(overflow)
+		l.buf = append(l.buf[:0], l.buf[overflow:]...)
+	}
+	l.buf = append(l.buf, p...)
+	return n, nil
+}
+
+// WriteLine appends a single line of output
+func (l *ProcessLog) WriteLine(line string) {
+	l.Write([]byte(line + "\n"))
+}
+
+// Tail returns up to n last non-empty lines of output, joined by newlines
+func (l *ProcessLog) Tail(n int) string {
+	lines := l.lines()
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (l *ProcessLog) finish(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.finished = time.Now()
+	if err != nil {
+		l.err = err.Error()
+	}
+}
+
+func (l *ProcessLog) lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var lines []string
+	for _, line := range bytes.Split(l.buf, []byte("\n")) {
+		if line := strings.TrimSpace(string(line)); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func (l *ProcessLog) snapshot() ProcessLogEntry {
+	lines := l.lines()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return ProcessLogEntry{
+		Operation:    l.operation,
+		Args:         l.args,
+		Started:      l.started,
+		Finished:     l.finished,
+		Error:        l.err,
+		DroppedBytes: l.dropped,
+		Output:       lines,
+	}
+}
+
+type requestLogs struct {
+	requestID string
+	logs      []*ProcessLog
+}
+
+// ProcessLogStore keeps captured yt-dlp output for the most recent requests,
+// keyed by request ID, so support can look up exactly what happened
+type ProcessLogStore struct {
+	mu          sync.Mutex
+	maxRequests int
+	maxBytes    int
+	order       *list.List
+	requests    map[string]*list.Element
+}
+
+// NewProcessLogStore keeps logs for up to maxRequests requests,
+// with at most maxBytes of output per invocation
+func NewProcessLogStore(maxRequests, maxBytes int) *ProcessLogStore {
+	return &ProcessLogStore{
+		maxRequests: maxRequests,
+		maxBytes:    maxBytes,
+		order:       list.New(),
+		requests:    make(map[string]*list.Element),
+	}
+}
+
+// Start creates a log for a new invocation belonging to the request in ctx.
+// Invocations outside a request are kept under "background".
+func (s *ProcessLogStore) Start(ctx context.Context, operation string, args []string) *ProcessLog {
+	log := &ProcessLog{
+		operation: operation,
+		args:      args,
+		started:   time.Now(),
+		maxBytes:  s.maxBytes,
+	}
+
+	requestID := chimiddleware.GetReqID(ctx)
+	if requestID == "" {
+		requestID = "background"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.requests[requestID]; ok {
+		entry := elem.Value.(*requestLogs)
+		entry.logs = append(entry.logs, log)
+		s.order.MoveToFront(elem)
+		return log
+	}
+
+	s.requests[requestID] = s.order.PushFront(&requestLogs{requestID: requestID, logs: []*ProcessLog{log}})
+	for s.order.Len() > s.maxRequests {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.requests, oldest.Value.(*requestLogs).requestID)
+	}
+	return log
+}
+
+// Get returns the captured invocations for a request, oldest first
+func (s *ProcessLogStore) Get(requestID string) ([]ProcessLogEntry, bool) {
+	s.mu.Lock()
+	elem, ok := s.requests[requestID]
+	var logs []*ProcessLog
+	if ok {
+		logs = append(logs, elem.Value.(*requestLogs).logs...)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	entries := make([]ProcessLogEntry, 0, len(logs))
+	for _, log := range logs {
+		entries = append(entries, log.snapshot())
+	}
+	return entries, true
+}
@@ -0,0 +1,368 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrQueueFull = errors.New("download queue is full")
+
+// QueueStatus describes a waiting request
+type QueueStatus struct {
+	// Position is the number of requests that will be served before this one
+	Position int
+	// EstimatedWait is a rough estimate based on recent download durations
+	EstimatedWait time.Duration
+	// Waiting is how long the request has been queued so far
+	Waiting time.Duration
+}
+
+// QueueStats is a point-in-time view of the queue
+type QueueStats struct {
+	Waiting     int
+	LongestWait time.Duration
+}
+
+type queueTicket struct {
+	identity string
+	weight   int
+	enqueued time.Time
+	// ready is closed when the ticket becomes the gate
+	ready    chan struct{}
+	admitted bool
+}
+
+// DownloadQueue hands out semaphore slots fairly between identities.
+// Each identity may hold at most perIdentity slots; when the server is busy,
+// requests wait and identities are served round-robin, so one user starting
+// many downloads cannot lock everyone else out. The queue picks whose turn it
+// is and lets only that request, the gate, wait on the semaphore itself.
+type DownloadQueue struct {
+	mu          sync.Mutex
+	semaphore   *Semaphore
+	perIdentity int
+	maxWaiting  int
+
+	// active counts the requests of each identity holding or waiting at the gate
+	active  map[string]int
+	waiting map[string][]*queueTicket
+	// order is the round-robin ring of identities that have waiters
+	order []string
+	next  int
+	// served is the identity that had the last turn, even if it left the ring
+	served string
+	// gate is the request waiting in Semaphore.AcquireContext, nil when none is
+	gate *queueTicket
+	// total counts the waiting requests including the gate
+	total int
+
+	// avgHold is a moving average of how long a slot is held, used for estimates
+	avgHold time.Duration
+}
+
+// NewDownloadQueue creates a queue on top of semaphore.
+// perIdentity caps concurrent downloads per identity, maxWaiting caps the queue length.
+func NewDownloadQueue(semaphore *Semaphore, perIdentity, maxWaiting int) *DownloadQueue {
+	return &DownloadQueue{
+		semaphore:   semaphore,
+		perIdentity: perIdentity,
+		maxWaiting:  maxWaiting,
+		active:      make(map[string]int),
+		waiting:     make(map[string][]*queueTicket),
+		avgHold:     time.Minute,
+	}
+}
+
+// Acquire waits for weight semaphore slots for identity.
+// onQueued, if not nil, is called once with the initial status when the request has to wait.
+// The returned release function must be called when the download finishes.
+// Waiting stops when ctx is cancelled, giving up the place in the queue.
+func (q *DownloadQueue) Acquire(ctx context.Context, identity string, weight int, onQueued func(QueueStatus)) (release func(), err error) {
+	q.mu.Lock()
+	if q.total >= q.maxWaiting && !q.canStart(identity, weight) {
+		q.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+
+	ticket := &queueTicket{
+		identity: identity,
+		weight:   weight,
+		enqueued: time.Now(),
+		ready:    make(chan struct{}),
+	}
+	q.push(ticket)
+	q.dispatch()
+
+	// Its turn right away with the slots free: the request never waits
+	if ticket.admitted {
+		if granted, ok := q.semaphore.tryAcquire(weight); ok {
+			q.leaveGate(ticket, true)
+			q.mu.Unlock()
+			return q.releaser(identity, granted), nil
+		}
+	}
+	status := q.statusOf(ticket)
+	q.mu.Unlock()
+
+	if onQueued != nil {
+		onQueued(status)
+	}
+
+	select {
+	case <-ticket.ready:
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if ticket.admitted {
+			// Became the gate while we were giving up, pass the turn on
+			q.leaveGate(ticket, false)
+		} else {
+			q.remove(ticket)
+		}
+		return nil, ctx.Err()
+	}
+
+	granted, err := q.semaphore.acquire(ctx, weight)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.leaveGate(ticket, err == nil)
+	if err != nil {
+		return nil, err
+	}
+	return q.releaser(identity, granted), nil
+}
+
+// SetLimits changes the per-identity cap and the queue length limit.
+// Requests already queued beyond a lowered maxWaiting keep their place.
+// It also hands out any slots freed by a semaphore Resize.
+func (q *DownloadQueue) SetLimits(perIdentity, maxWaiting int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.perIdentity = perIdentity
+	q.maxWaiting = maxWaiting
+	q.dispatch()
+}
+
+// Status returns the status of every request identity has waiting
+func (q *DownloadQueue) Status(identity string) []QueueStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var statuses []QueueStatus
+	if q.gate != nil && q.gate.identity == identity {
+		statuses = append(statuses, q.statusOf(q.gate))
+	}
+	for _, ticket := range q.waiting[identity] {
+		statuses = append(statuses, q.statusOf(ticket))
+	}
+	return statuses
+}
+
+// Waiting returns the total number of queued requests
+func (q *DownloadQueue) Waiting() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.total
+}
+
+// Stats returns the queue length and the wait of the oldest queued request
+func (q *DownloadQueue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := QueueStats{Waiting: q.total}
+	if q.gate != nil {
+		stats.LongestWait = time.Since(q.gate.enqueued)
+	}
+	for _, tickets := range q.waiting {
+		if len(tickets) > 0 {
+			stats.LongestWait = max(stats.LongestWait, time.Since(tickets[0].enqueued))
+		}
+	}
+	return stats
+}
+
+// EstimateWait estimates how long a new request would wait
+func (q *DownloadQueue) EstimateWait() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.estimate(q.total)
+}
+
+func (q *DownloadQueue) releaser(identity string, weight int) func() {
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			q.releaseLocked(identity, weight, time.Since(start))
+		})
+	}
+}
+
+func (q *DownloadQueue) releaseLocked(identity string, weight int, held time.Duration) {
+	q.semaphore.ReleaseWeight(weight)
+	q.deactivate(identity)
+	q.avgHold = (q.avgHold*4 + held) / 5
+	q.dispatch()
+}
+
+// leaveGate ends the gate's turn, with its slots or without when it gave up,
+// and lets the next request in
+func (q *DownloadQueue) leaveGate(ticket *queueTicket, acquired bool) {
+	q.gate = nil
+	q.total--
+	if !acquired {
+		q.deactivate(ticket.identity)
+	}
+	q.dispatch()
+}
+
+func (q *DownloadQueue) deactivate(identity string) {
+	if q.active[identity]--; q.active[identity] <= 0 {
+		delete(q.active, identity)
+	}
+}
+
+func (q *DownloadQueue) canStart(identity string, weight int) bool {
+	return q.gate == nil && q.active[identity] < q.perIdentity && len(q.waiting[identity]) == 0 && q.semaphore.Available() >= min(weight, q.semaphore.Capacity())
+}
+
+func (q *DownloadQueue) push(ticket *queueTicket) {
+	if len(q.waiting[ticket.identity]) == 0 {
+		q.join(ticket.identity)
+	}
+	q.waiting[ticket.identity] = append(q.waiting[ticket.identity], ticket)
+	q.total++
+}
+
+// join adds identity to the ring at the end of the current round: right
+// before the identity that had the last turn, or before the next one up when
+// that identity has left the ring.
+func (q *DownloadQueue) join(identity string) {
+	if i := slices.Index(q.order, q.served); i >= 0 {
+		q.order = slices.Insert(q.order, i, identity)
+		if i < q.next {
+			q.next++
+		}
+		return
+	}
+	q.order = slices.Insert(q.order, q.next, identity)
+	q.next = (q.next + 1) % len(q.order)
+}
+
+func (q *DownloadQueue) remove(ticket *queueTicket) {
+	tickets := q.waiting[ticket.identity]
+	for i, t := range tickets {
+		if t == ticket {
+			q.waiting[ticket.identity] = append(tickets[:i], tickets[i+1:]...)
+			q.total--
+			break
+		}
+	}
+	if len(q.waiting[ticket.identity]) == 0 {
+		q.dropIdentity(ticket.identity)
+	}
+	// Removing a waiter can unblock others that were behind it
+	q.dispatch()
+}
+
+func (q *DownloadQueue) dropIdentity(identity string) {
+	delete(q.waiting, identity)
+	for i, id := range q.order {
+		if id == identity {
+			q.order = append(q.order[:i], q.order[i+1:]...)
+			if q.next > i {
+				q.next--
+			}
+			break
+		}
+	}
+	if q.next >= len(q.order) {
+		q.next = 0
+	}
+}
+
+// dispatch makes the next waiter the gate once the previous one has its
+// slots, visiting identities round-robin and skipping those already at their
+// concurrency cap. The gate waits until its weight fits rather than letting
+// lighter downloads overtake it indefinitely.
+func (q *DownloadQueue) dispatch() {
+	if q.gate != nil || len(q.order) == 0 {
+		return
+	}
+	picked := -1
+	for i := 0; i < len(q.order); i++ {
+		idx := (q.next + i) % len(q.order)
+		if q.active[q.order[idx]] < q.perIdentity {
+			picked = idx
+			break
+		}
+	}
+	if picked < 0 {
+		return
+	}
+
+	identity := q.order[picked]
+	ticket := q.waiting[identity][0]
+	q.waiting[identity] = q.waiting[identity][1:]
+	q.active[identity]++
+	q.gate = ticket
+	ticket.admitted = true
+	close(ticket.ready)
+
+	q.served = identity
+	q.next = picked + 1
+	if len(q.waiting[identity]) == 0 {
+		q.dropIdentity(identity)
+	} else if q.next >= len(q.order) {
+		q.next = 0
+	}
+}
+
+// statusOf approximates the position of a ticket under round-robin service:
+// identities up before this one in the round get a turn for each ticket ahead
+// of it plus one for its own, the others one turn fewer
+func (q *DownloadQueue) statusOf(ticket *queueTicket) QueueStatus {
+	if ticket == q.gate {
+		return QueueStatus{EstimatedWait: q.estimate(0), Waiting: time.Since(ticket.enqueued)}
+	}
+
+	index := slices.Index(q.waiting[ticket.identity], ticket)
+	// round gives how far into the current round an identity's turn comes
+	round := func(identity string) int {
+		return (slices.Index(q.order, identity) - q.next + len(q.order)) % len(q.order)
+	}
+
+	position := index
+	if q.gate != nil {
+		position++
+	}
+	own := round(ticket.identity)
+	for identity, tickets := range q.waiting {
+		if identity == ticket.identity {
+			continue
+		}
+		turns := index
+		if round(identity) < own {
+			turns++
+		}
+		position += min(len(tickets), turns)
+	}
+
+	return QueueStatus{
+		Position:      position,
+		EstimatedWait: q.estimate(position),
+		Waiting:       time.Since(ticket.enqueued),
+	}
+}
+
+func (q *DownloadQueue) estimate(position int) time.Duration {
+	capacity := max(1, q.semaphore.Capacity()/WeightUnit)
+	rounds := position/capacity + 1
+	return time.Duration(rounds) * q.avgHold
+}
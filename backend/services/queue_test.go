@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+type queueResult struct {
+	name    string
+	release func()
+	err     error
+}
+
+// enqueue runs Acquire in the background, reporting the result on results
+// under name, and waits until the request is queued
+func enqueue(t *testing.T, ctx context.Context, q *DownloadQueue, name, identity string, weight int, results chan<- queueResult) {
+	t.Helper()
+	queued := q.Waiting()
+	go func() {
+		release, err := q.Acquire(ctx, identity, weight, nil)
+		results <- queueResult{name: name, release: release, err: err}
+	}()
+	waitFor(t, name+" to queue", func() bool { return q.Waiting() == queued+1 })
+}
+
+func nextResult(t *testing.T, results <-chan queueResult) queueResult {
+	t.Helper()
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(time.Second):
+		t.Fatal("no request was served")
+		return queueResult{}
+	}
+}
+
+func expectNoResult(t *testing.T, results <-chan queueResult) {
+	t.Helper()
+	select {
+	case result := <-results:
+		t.Fatalf("%s was served early (err %v)", result.name, result.err)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestDownloadQueueStartsImmediately(t *testing.T) {
+	semaphore := NewSemaphore(2 * WeightUnit)
+	q := NewDownloadQueue(semaphore, 2, 10)
+
+	release, err := q.Acquire(context.Background(), "a", WeightUnit, func(QueueStatus) {
+		t.Error("onQueued called for a request with free slots")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := semaphore.Stats(); stats.InUse != WeightUnit || stats.Holders != 1 {
+		t.Errorf("semaphore stats = %+v, want one unit held", stats)
+	}
+	release()
+	release()
+	if stats := semaphore.Stats(); stats.InUse != 0 {
+		t.Errorf("semaphore stats = %+v after release, want nothing held", stats)
+	}
+}
+
+func TestDownloadQueueRoundRobin(t *testing.T) {
+	q := NewDownloadQueue(NewSemaphore(WeightUnit), 5, 10)
+	holder, err := q.Acquire(context.Background(), "x", WeightUnit, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a starts three downloads before b starts one; b must not wait for all of a's
+	results := make(chan queueResult, 4)
+	enqueue(t, context.Background(), q, "a1", "a", WeightUnit, results)
+	enqueue(t, context.Background(), q, "a2", "a", WeightUnit, results)
+	enqueue(t, context.Background(), q, "a3", "a", WeightUnit, results)
+	enqueue(t, context.Background(), q, "b1", "b", WeightUnit, results)
+
+	statuses := q.Status("b")
+	if len(statuses) != 1 || statuses[0].Position != 1 {
+		t.Errorf("b status = %+v, want position 1 behind a's first download", statuses)
+	}
+	if stats := q.Stats(); stats.Waiting != 4 || stats.LongestWait <= 0 {
+		t.Errorf("queue stats = %+v, want 4 waiting", stats)
+	}
+
+	holder()
+	var order []string
+	for range 4 {
+		result := nextResult(t, results)
+		if result.err != nil {
+			t.Fatalf("%s: %v", result.name, result.err)
+		}
+		order = append(order, result.name)
+		expectNoResult(t, results)
+		result.release()
+	}
+	if want := []string{"a1", "b1", "a2", "a3"}; !slices.Equal(order, want) {
+		t.Errorf("served %v, want %v", order, want)
+	}
+}
+
+func TestDownloadQueuePerIdentityCap(t *testing.T) {
+	q := NewDownloadQueue(NewSemaphore(3*WeightUnit), 1, 10)
+	first, err := q.Acquire(context.Background(), "a", WeightUnit, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := make(chan queueResult, 2)
+	enqueue(t, context.Background(), q, "a2", "a", WeightUnit, results)
+	// Slots are free, so another identity is not held up by a's cap
+	other, err := q.Acquire(context.Background(), "b", WeightUnit, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+	expectNoResult(t, results)
+
+	first()
+	if result := nextResult(t, results); result.name != "a2" || result.err != nil {
+		t.Fatalf("got %s (%v), want a2 once a's first download ended", result.name, result.err)
+	} else {
+		result.release()
+	}
+}
+
+func TestDownloadQueueHeavyRequestIsNotOvertaken(t *testing.T) {
+	semaphore := NewSemaphore(2 * WeightUnit)
+	q := NewDownloadQueue(semaphore, 5, 10)
+	holder, err := q.Acquire(context.Background(), "x", WeightUnit, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := make(chan queueResult, 2)
+	enqueue(t, context.Background(), q, "heavy", "a", 2*WeightUnit, results)
+	enqueue(t, context.Background(), q, "light", "b", WeightUnit, results)
+	// The heavy request waits on the semaphore itself for the slot to free up
+	waitFor(t, "the heavy request to wait on the semaphore", func() bool { return semaphore.Stats().Waiters == 1 })
+	expectNoResult(t, results)
+
+	holder()
+	heavy := nextResult(t, results)
+	if heavy.name != "heavy" {
+		t.Fatalf("%s served before the heavy request", heavy.name)
+	}
+	expectNoResult(t, results)
+	heavy.release()
+	if light := nextResult(t, results); light.name != "light" {
+		t.Fatalf("got %s, want the light request", light.name)
+	} else {
+		light.release()
+	}
+}
+
+func TestDownloadQueueCancellation(t *testing.T) {
+	semaphore := NewSemaphore(WeightUnit)
+	q := NewDownloadQueue(semaphore, 5, 10)
+	holder, err := q.Acquire(context.Background(), "x", WeightUnit, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := make(chan queueResult, 3)
+	gateCtx, cancelGate := context.WithCancel(context.Background())
+	queuedCtx, cancelQueued := context.WithCancel(context.Background())
+	enqueue(t, gateCtx, q, "gate", "a", WeightUnit, results)
+	enqueue(t, queuedCtx, q, "queued", "b", WeightUnit, results)
+	enqueue(t, context.Background(), q, "last", "c", WeightUnit, results)
+
+	// Leaving from the middle of the queue
+	cancelQueued()
+	if result := nextResult(t, results); result.name != "queued" || !errors.Is(result.err, context.Canceled) {
+		t.Fatalf("got %s (%v), want queued cancelled", result.name, result.err)
+	}
+	// Leaving while waiting on the semaphore passes the turn on
+	cancelGate()
+	if result := nextResult(t, results); result.name != "gate" || !errors.Is(result.err, context.Canceled) {
+		t.Fatalf("got %s (%v), want gate cancelled", result.name, result.err)
+	}
+	if stats := q.Stats(); stats.Waiting != 1 {
+		t.Errorf("queue stats = %+v, want only the last request waiting", stats)
+	}
+
+	holder()
+	result := nextResult(t, results)
+	if result.name != "last" || result.err != nil {
+		t.Fatalf("got %s (%v), want the last request served", result.name, result.err)
+	}
+	result.release()
+	if stats := semaphore.Stats(); stats.InUse != 0 || stats.Holders != 0 || stats.Waiters != 0 {
+		t.Errorf("semaphore stats = %+v, want it idle", stats)
+	}
+}
+
+func TestDownloadQueueFull(t *testing.T) {
+	q := NewDownloadQueue(NewSemaphore(WeightUnit), 5, 1)
+	holder, err := q.Acquire(context.Background(), "x", WeightUnit, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder()
+
+	results := make(chan queueResult, 1)
+	var status QueueStatus
+	go func() {
+		release, err := q.Acquire(context.Background(), "a", WeightUnit, func(s QueueStatus) { status = s })
+		results <- queueResult{name: "a", release: release, err: err}
+	}()
+	waitFor(t, "a to queue", func() bool { return q.Waiting() == 1 })
+
+	if _, err := q.Acquire(context.Background(), "b", WeightUnit, nil); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("err = %v, want %v", err, ErrQueueFull)
+	}
+
+	holder()
+	result := nextResult(t, results)
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	result.release()
+	if status.EstimatedWait <= 0 {
+		t.Errorf("onQueued status = %+v, want an estimate", status)
+	}
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"viddown/metrics"
+)
+
+const (
+	// searchTTL is how long search results are reused for the same query
+	searchTTL = 5 * time.Minute
+	// searchTimeout bounds a search shared by concurrent requests, which no
+	// longer ends with the request that started it
+	searchTimeout = time.Minute
+	// DefaultSearchResults is the number of results when no limit is asked for
+	DefaultSearchResults = 10
+	// MaxSearchResults caps the results of one search
+	MaxSearchResults = 25
+)
+
+// ErrSearchUnsupported is returned for platforms that can't be searched or are disabled
+var ErrSearchUnsupported = errors.New("search is not supported for this platform")
+
+// searchPrefixes are the yt-dlp search extractors of the searchable platforms
+var searchPrefixes = map[Platform]string{
+	PlatformYouTube:    "ytsearch",
+	PlatformSoundCloud: "scsearch",
+}
+
+// SearchResult is a video found by a search. URL is canonical and can be
+// passed to analyze as is.
+type SearchResult struct {
+	ID        string   `json:"id"`
+	Platform  Platform `json:"platform"`
+	Title     string   `json:"title"`
+	Channel   string   `json:"channel,omitempty"`
+	Duration  int      `json:"duration,omitempty"`
+	Thumbnail string   `json:"thumbnail,omitempty"`
+	URL       string   `json:"url"`
+}
+
+// SearchService finds videos by text through yt-dlp's search extractors.
+// Results are listed flat, without opening every video, and kept briefly
+// so repeated searches don't run yt-dlp again.
+type SearchService struct {
+	ytdlp     *YtDlpService
+	platforms *PlatformRegistry
+
+	mu      sync.Mutex
+	results map[string]*searchEntry
+	// inflight shares a running search between identical requests
+	inflight singleflight.Group
+}
+
+type searchEntry struct {
+	results []SearchResult
+	expires time.Time
+}
+
+// NewSearchService creates a search over the enabled searchable platforms
+func NewSearchService(ytdlp *YtDlpService, platforms *PlatformRegistry) *SearchService {
+	return &SearchService{
+		ytdlp:     ytdlp,
+		platforms: platforms,
+		results:   make(map[string]*searchEntry),
+	}
+}
+
+// Search returns up to limit videos of platform matching query; limit is
+// clamped to 1..MaxSearchResults
+func (s *SearchService) Search(ctx context.Context, query string, platform Platform, limit int) (results []SearchResult, err error) {
+	prefix, ok := searchPrefixes[platform]
+	if !ok || !s.platforms.IsEnabled(platform) {
+		return nil, fmt.Errorf("%w: %s", ErrSearchUnsupported, platform)
+	}
+	// Recorded only for searchable platforms: the name comes from the client
+	defer func() {
+		metrics.ObserveOperation("search", string(platform), ClassifyError(err))
+	}()
+	limit = min(max(limit, 1), MaxSearchResults)
+
+	key := fmt.Sprintf("%s:%d:%s", platform, limit, strings.ToLower(query))
+	if cached := s.cached(key); cached != nil {
+		return cached, nil
+	}
+
+	// The search gets a context of its own, so a client disconnecting doesn't
+	// fail it for the others waiting on it
+	shared := s.inflight.DoChan(key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), searchTimeout)
+		defer cancel()
+		results, err := s.search(ctx, prefix+strconv.Itoa(limit)+":"+query, platform)
+		if err != nil {
+			return nil, err
+		}
+		s.remember(key, results)
+		return results, nil
+	})
+	select {
+	case result := <-shared:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.([]SearchResult), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *SearchService) search(ctx context.Context, target string, platform Platform) ([]SearchResult, error) {
+	raw, err := s.ytdlp.extractInfo(ctx, target, "--flat-playlist")
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(raw.Entries))
+	for i := range raw.Entries {
+		entry := &raw.Entries[i]
+		// Channels and playlists among the results can't be analyzed
+		canonical, err := s.ytdlp.Canonicalize(firstNonEmpty(entry.WebpageURL, entry.URL))
+		if err != nil || canonical.Platform != platform {
+			continue
+		}
+		thumbnail, _, _ := largestThumbnail(entry)
+		results = append(results, SearchResult{
+			ID:        firstNonEmpty(canonical.ID, entry.ID),
+			Platform:  platform,
+			Title:     entry.Title,
+			Channel:   entry.author(),
+			Duration:  int(entry.Duration),
+			Thumbnail: thumbnail,
+			URL:       canonical.URL,
+		})
+	}
+	return results, nil
+}
+
+func (s *SearchService) cached(key string) []SearchResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.results[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil
+	}
+	return entry.results
+}
+
+func (s *SearchService) remember(key string, results []SearchResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range s.results {
+		if now.After(entry.expires) {
+			delete(s.results, k)
+		}
+	}
+	s.results[key] = &searchEntry{results: results, expires: now.Add(searchTTL)}
+}
@@ -1,40 +1,209 @@
 package services
 
-// Semaphore limits concurrent operations
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// SemaphoreStats is a point-in-time view of a semaphore
+type SemaphoreStats struct {
+	Capacity int
+	InUse    int
+	Holders  int
+	Waiters  int
+	// LongestWait is how long the oldest current waiter has been waiting
+	LongestWait time.Duration
+}
+
+type semaphoreWaiter struct {
+	weight int
+	since  time.Time
+	ready  chan struct{}
+}
+
+// Semaphore limits concurrent operations.
+// Each holder takes a weight, so expensive operations can take several slots.
+// Waiters are served in FIFO order; DownloadQueue decides which download
+// waits here next, so identities take turns.
 type Semaphore struct {
-	ch chan struct{}
+	mu       sync.Mutex
+	capacity int
+	inUse    int
+	holders  int
+	waiters  list.List
 }
 
 // NewSemaphore creates a new semaphore with the given capacity
 func NewSemaphore(max int) *Semaphore {
 	return &Semaphore{
-		ch: make(chan struct{}, max),
+		capacity: max,
 	}
 }
 
 // Acquire blocks until a slot is available
 func (s *Semaphore) Acquire() {
-	s.ch <- struct{}{}
+	s.AcquireContext(context.Background(), 1)
+}
+
+// AcquireContext blocks until weight slots are available or ctx is done.
+// Weights above the capacity are clamped, so such an operation runs alone.
+func (s *Semaphore) AcquireContext(ctx context.Context, weight int) error {
+	_, err := s.acquire(ctx, weight)
+	return err
+}
+
+// acquire is AcquireContext returning the weight granted, which is what
+// must be released even if a Resize has since changed the clamp
+func (s *Semaphore) acquire(ctx context.Context, weight int) (int, error) {
+	s.mu.Lock()
+	weight = min(max(weight, 1), s.capacity)
+	if s.fits(weight) && s.waiters.Len() == 0 {
+		s.take(weight)
+		s.mu.Unlock()
+		return weight, nil
+	}
+
+	w := &semaphoreWaiter{weight: weight, since: time.Now(), ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return weight, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-w.ready:
+			// Acquired while being cancelled, give it back
+			s.release(weight)
+		default:
+			isFront := s.waiters.Front() == elem
+			s.waiters.Remove(elem)
+			// A heavy waiter at the front may have been blocking lighter ones behind it
+			if isFront {
+				s.notifyWaiters()
+			}
+		}
+		return 0, ctx.Err()
+	}
 }
 
 // TryAcquire returns true if a slot was acquired, false otherwise (non-blocking)
 func (s *Semaphore) TryAcquire() bool {
-	select {
-	case s.ch <- struct{}{}:
-		return true
-	default:
-		return false
+	return s.TryAcquireWeight(1)
+}
+
+// TryAcquireWeight acquires weight slots without blocking
+func (s *Semaphore) TryAcquireWeight(weight int) bool {
+	_, ok := s.tryAcquire(weight)
+	return ok
+}
+
+// tryAcquire is TryAcquireWeight returning the weight granted
+func (s *Semaphore) tryAcquire(weight int) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	weight = min(max(weight, 1), s.capacity)
+	if s.fits(weight) && s.waiters.Len() == 0 {
+		s.take(weight)
+		return weight, true
 	}
+	return 0, false
 }
 
 // Release frees a slot
 func (s *Semaphore) Release() {
-	<-s.ch
+	s.ReleaseWeight(1)
+}
+
+// ReleaseWeight frees weight slots taken by AcquireContext or TryAcquireWeight.
+// weight must be what was actually granted: since Resize can change the capacity,
+// callers passing weights near the capacity should clamp them before acquiring.
+func (s *Semaphore) ReleaseWeight(weight int) {
+	weight = max(weight, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.release(weight)
+}
+
+// Resize changes the total number of slots.
+// When shrinking, current holders keep their slots and new ones are
+// only handed out once usage drops below the new capacity.
+func (s *Semaphore) Resize(capacity int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.capacity = capacity
+	s.notifyWaiters()
+}
+
+// Capacity returns the total number of slots
+func (s *Semaphore) Capacity() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.capacity
 }
 
 // Available returns the number of available slots
 func (s *Semaphore) Available() int {
-	return cap(s.ch) - len(s.ch)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return max(0, s.capacity-s.inUse)
+}
+
+// Stats returns current usage, holders and waiters
+func (s *Semaphore) Stats() SemaphoreStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := SemaphoreStats{
+		Capacity: s.capacity,
+		InUse:    s.inUse,
+		Holders:  s.holders,
+		Waiters:  s.waiters.Len(),
+	}
+	if front := s.waiters.Front(); front != nil {
+		stats.LongestWait = time.Since(front.Value.(*semaphoreWaiter).since)
+	}
+	return stats
+}
+
+func (s *Semaphore) fits(weight int) bool {
+	return s.capacity-s.inUse >= weight
+}
+
+func (s *Semaphore) take(weight int) {
+	s.inUse += weight
+	s.holders++
 }
 
+func (s *Semaphore) release(weight int) {
+	s.inUse -= weight
+	s.holders--
+	if s.inUse < 0 {
+		panic("semaphore: released more than held")
+	}
+	s.notifyWaiters()
+}
 
+// notifyWaiters wakes waiters in order while they fit.
+// It stops at the first waiter that doesn't fit, so heavy waiters aren't starved.
+func (s *Semaphore) notifyWaiters() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(*semaphoreWaiter)
+		if !s.fits(w.weight) {
+			return
+		}
+		s.take(w.weight)
+		s.waiters.Remove(front)
+		close(w.ready)
+	}
+}
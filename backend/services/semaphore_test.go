@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// acquireAsync runs AcquireContext in the background and reports its result
+func acquireAsync(ctx context.Context, s *Semaphore, weight int) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- s.AcquireContext(ctx, weight) }()
+	return done
+}
+
+// waitFor polls cond until it holds, failing the test after a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func expectPending(t *testing.T, done <-chan error, what string) {
+	t.Helper()
+	select {
+	case err := <-done:
+		t.Fatalf("%s returned early: %v", what, err)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func expectAcquired(t *testing.T, done <-chan error, what string) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("%s: %v", what, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("%s still waiting", what)
+	}
+}
+
+func TestSemaphoreWeights(t *testing.T) {
+	s := NewSemaphore(4)
+	if !s.TryAcquireWeight(3) {
+		t.Fatal("weight 3 of 4 not granted")
+	}
+	if s.TryAcquireWeight(2) {
+		t.Fatal("weight 2 granted with 1 slot free")
+	}
+	if !s.TryAcquire() {
+		t.Fatal("last slot not granted")
+	}
+	if got := s.Stats(); got.InUse != 4 || got.Holders != 2 || s.Available() != 0 {
+		t.Errorf("stats = %+v, want 4 slots in use by 2 holders", got)
+	}
+
+	s.ReleaseWeight(3)
+	s.Release()
+	// Weights above the capacity are clamped, so the operation runs alone
+	if err := s.AcquireContext(context.Background(), 10); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Stats(); got.InUse != 4 || got.Holders != 1 {
+		t.Errorf("stats = %+v, want the whole capacity held once", got)
+	}
+}
+
+func TestSemaphoreWaitersInOrder(t *testing.T) {
+	s := NewSemaphore(2)
+	if err := s.AcquireContext(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	heavy := acquireAsync(context.Background(), s, 2)
+	waitFor(t, "the heavy waiter", func() bool { return s.Stats().Waiters == 1 })
+	// A light request would fit, but must not overtake the heavy one
+	light := acquireAsync(context.Background(), s, 1)
+	waitFor(t, "the light waiter", func() bool { return s.Stats().Waiters == 2 })
+	if s.TryAcquire() {
+		t.Fatal("TryAcquire jumped the queue")
+	}
+	expectPending(t, light, "light waiter")
+
+	if stats := s.Stats(); stats.LongestWait <= 0 {
+		t.Errorf("longest wait = %v with waiters", stats.LongestWait)
+	}
+
+	s.Release()
+	expectAcquired(t, heavy, "heavy waiter")
+	expectPending(t, light, "light waiter")
+	s.ReleaseWeight(2)
+	expectAcquired(t, light, "light waiter")
+	if stats := s.Stats(); stats.Waiters != 0 || stats.LongestWait != 0 {
+		t.Errorf("stats = %+v, want no waiters", stats)
+	}
+}
+
+func TestSemaphoreCancelledWaiter(t *testing.T) {
+	s := NewSemaphore(2)
+	if err := s.AcquireContext(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	heavy := acquireAsync(ctx, s, 2)
+	waitFor(t, "the heavy waiter", func() bool { return s.Stats().Waiters == 1 })
+	light := acquireAsync(context.Background(), s, 1)
+	waitFor(t, "the light waiter", func() bool { return s.Stats().Waiters == 2 })
+
+	// Giving up at the front lets the waiter behind it in
+	cancel()
+	if err := <-heavy; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled waiter returned %v", err)
+	}
+	expectAcquired(t, light, "light waiter")
+	if stats := s.Stats(); stats.InUse != 2 || stats.Waiters != 0 {
+		t.Errorf("stats = %+v, want both slots held and nobody waiting", stats)
+	}
+}
+
+func TestSemaphoreResizeWakesWaiters(t *testing.T) {
+	s := NewSemaphore(1)
+	if err := s.AcquireContext(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	waiter := acquireAsync(context.Background(), s, 1)
+	waitFor(t, "the waiter", func() bool { return s.Stats().Waiters == 1 })
+
+	s.Resize(2)
+	expectAcquired(t, waiter, "waiter after growing")
+
+	// Shrinking keeps current holders; new slots wait until usage drops
+	s.Resize(1)
+	waiter = acquireAsync(context.Background(), s, 1)
+	waitFor(t, "the waiter", func() bool { return s.Stats().Waiters == 1 })
+	s.Release()
+	expectPending(t, waiter, "waiter over the shrunk capacity")
+	s.Release()
+	expectAcquired(t, waiter, "waiter after usage dropped")
+}
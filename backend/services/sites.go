@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"viddown/metrics"
+)
+
+const (
+	// chapterPrefix starts the format of a single chapter, "chapter2" for the second
+	chapterPrefix = "chapter"
+	// chapterVideoFormat is the yt-dlp format a chapter is cut from
+	chapterVideoFormat = "bv*+ba/b"
+)
+
+// Chapter is a titled section of a long video, downloadable on its own
+type Chapter struct {
+	// Index is the 1-based position in the video
+	Index int    `json:"index"`
+	Title string `json:"title"`
+	// Start and End are offsets in seconds
+	Start int `json:"start"`
+	End   int `json:"end"`
+	// FormatID downloads just this chapter
+	FormatID string `json:"formatId"`
+}
+
+// SiteService handles platforms whose links point to a single video or
+// track that yt-dlp extracts as is. Platforms differ in how their formats
+// are reduced to the choices offered; chapters, when a video has them,
+// are offered as downloads of their own.
+type SiteService struct {
+	platform Platform
+	ytdlp    *YtDlpService
+	// formats reduces the formats reported by yt-dlp to the choices offered
+	formats func(raw []ytdlpFormat) []Format
+	// keepAudio downloads audio formats as served instead of converting
+	// them to m4a, for platforms whose audio formats are the files themselves
+	keepAudio bool
+}
+
+// NewVimeoService creates the Vimeo backend. Unlisted videos only play
+// with the hash of their link, which canonicalization keeps.
+func NewVimeoService(ytdlp *YtDlpService) *SiteService {
+	return &SiteService{platform: PlatformVimeo, ytdlp: ytdlp, formats: vimeoFormats}
+}
+
+// NewRedditService creates the Reddit backend. Reddit serves the video and
+// its sound as separate streams, merged on download.
+func NewRedditService(ytdlp *YtDlpService) *SiteService {
+	return &SiteService{platform: PlatformReddit, ytdlp: ytdlp, formats: mergedFormats}
+}
+
+// NewTwitchService creates the Twitch backend for VODs and clips; VOD
+// chapters can be downloaded one by one
+func NewTwitchService(ytdlp *YtDlpService) *SiteService {
+	return &SiteService{platform: PlatformTwitch, ytdlp: ytdlp, formats: twitchFormats}
+}
+
+// NewSoundCloudService creates the SoundCloud backend, which offers the
+// audio files of a track as they are served
+func NewSoundCloudService(ytdlp *YtDlpService) *SiteService {
+	return &SiteService{platform: PlatformSoundCloud, ytdlp: ytdlp, formats: soundcloudFormats, keepAudio: true}
+}
+
+func (s *SiteService) Analyze(ctx context.Context, url string) (*VideoInfo, error) {
+	info, err := s.ytdlp.sharedAnalysis(ctx, url, func(ctx context.Context) (*VideoInfo, error) {
+		return s.analyze(ctx, url)
+	})
+	metrics.ObserveOperation("analyze", string(s.platform), ClassifyError(err))
+	return info, err
+}
+
+func (s *SiteService) analyze(ctx context.Context, url string) (*VideoInfo, error) {
+	raw, err := s.ytdlp.extractInfo(ctx, url, "--no-playlist")
+	if err != nil {
+		return nil, err
+	}
+	// Profiles, channels and sets resolve to playlists
+	if raw.Type == "playlist" {
+		return nil, fmt.Errorf("%w: link is a playlist, not a single video", ErrMediaUnavailable)
+	}
+
+	info := &VideoInfo{
+		ID:          raw.ID,
+		Platform:    s.platform,
+		Title:       raw.Title,
+		Duration:    int(raw.Duration),
+		Thumbnail:   raw.Thumbnail,
+		Author:      raw.author(),
+		Description: raw.Description,
+		Formats:     s.formats(raw.Formats),
+		Chapters:    chapters(raw),
+	}
+	if len(info.Formats) == 0 {
+		return nil, fmt.Errorf("%w: no downloadable formats", ErrMediaUnavailable)
+	}
+	for _, chapter := range info.Chapters {
+		info.Formats = append(info.Formats, Format{
+			ID:      chapter.FormatID,
+			Type:    "video",
+			Quality: fmt.Sprintf("Глава %d: %s (%s–%s)", chapter.Index, chapter.Title, clock(chapter.Start), clock(chapter.End)),
+			Ext:     "mp4",
+		})
+	}
+
+	s.ytdlp.rememberAnalysis(url, raw, info)
+	return info, nil
+}
+
+// chapters lists the chapters of a video; a single chapter spanning the
+// whole video is no choice and is left out
+func chapters(raw *ytdlpInfo) []Chapter {
+	if len(raw.Chapters) < 2 {
+		return nil
+	}
+	chapters := make([]Chapter, len(raw.Chapters))
+	for i, c := range raw.Chapters {
+		chapters[i] = Chapter{
+			Index:    i + 1,
+			Title:    firstNonEmpty(c.Title, fmt.Sprintf("Глава %d", i+1)),
+			Start:    int(c.StartTime),
+			End:      int(c.EndTime + 0.5),
+			FormatID: chapterPrefix + strconv.Itoa(i+1),
+		}
+	}
+	return chapters
+}
+
+// clock formats seconds as 1:02:03, or 2:03 under an hour
+func clock(seconds int) string {
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// soundcloudFormats offers the audio files of a SoundCloud track, the best
+// bitrate of each container, highest first. The 30-second previews served
+// for paywalled tracks are skipped.
+func soundcloudFormats(raw []ytdlpFormat) []Format {
+	best := make(map[string]*ytdlpFormat)
+	// yt-dlp lists formats from worst to best, so later ones win ties
+	for i := range raw {
+		f := &raw[i]
+		preview := strings.Contains(strings.ToLower(f.FormatID+" "+f.FormatNote), "preview")
+		if f.ACodec == "none" || preview || f.Ext == "" {
+			continue
+		}
+		if current := best[f.Ext]; current == nil || f.ABR >= current.ABR {
+			best[f.Ext] = f
+		}
+	}
+
+	formats := make([]Format, 0, len(best))
+	for ext, f := range best {
+		quality := strings.ToUpper(ext)
+		if f.ABR > 0 {
+			quality = fmt.Sprintf("%s %.0f kbps", quality, f.ABR)
+		}
+		formats = append(formats, Format{ID: f.FormatID, Type: "audio", Quality: quality, Ext: ext, Size: f.Filesize})
+	}
+	sort.Slice(formats, func(i, j int) bool {
+		return best[formats[i].Ext].ABR > best[formats[j].Ext].ABR
+	})
+	return formats
+}
+
+// BestFormats returns the formats as analyzed, which are already simplified
+func (s *SiteService) BestFormats(info *VideoInfo) []Format {
+	return info.Formats
+}
+
+func (s *SiteService) Identify(url string) (Platform, string) {
+	return s.ytdlp.Identify(url)
+}
+
+func (s *SiteService) Canonicalize(url string) (*CanonicalURL, error) {
+	return s.ytdlp.Canonicalize(url)
+}
+
+func (s *SiteService) EstimateWork(url, formatID string, isAudioOnly bool) DownloadWork {
+	if s.keepAudio {
+		isAudioOnly = false
+	}
+	if !strings.HasPrefix(formatID, chapterPrefix) {
+		return s.ytdlp.EstimateWork(url, formatID, isAudioOnly)
+	}
+
+	work := s.ytdlp.EstimateWork(url, chapterVideoFormat, false)
+	if cached := s.ytdlp.cachedAnalysis(url); cached != nil {
+		for _, chapter := range cached.info.Chapters {
+			if chapter.FormatID == formatID {
+				work.Duration = time.Duration(chapter.End-chapter.Start) * time.Second
+			}
+		}
+	}
+	return work
+}
+
+// DownloadToFile downloads a format of a video, or one of its chapters
+// ("chapter2") in the best quality
+func (s *SiteService) DownloadToFile(ctx context.Context, url, formatID, tempDir string, isAudioOnly bool) (filePath string, filename string, err error) {
+	defer func() {
+		metrics.ObserveOperation("download", string(s.platform), ClassifyError(err))
+	}()
+
+	if !strings.HasPrefix(formatID, chapterPrefix) {
+		return s.ytdlp.download(ctx, url, formatID, tempDir, isAudioOnly && !s.keepAudio, "--no-playlist")
+	}
+
+	chapter, err := s.chapter(ctx, url, formatID)
+	if err != nil {
+		return "", "", err
+	}
+	section := fmt.Sprintf("*%d-%d", chapter.Start, chapter.End)
+	filePath, filename, err = s.ytdlp.download(ctx, url, chapterVideoFormat, tempDir, false, "--no-playlist", "--download-sections", section)
+	if err != nil {
+		return "", "", err
+	}
+	ext := filepath.Ext(filename)
+	return filePath, fmt.Sprintf("%s - %02d %s%s", strings.TrimSuffix(filename, ext), chapter.Index, chapter.Title, ext), nil
+}
+
+// chapter returns the chapter downloaded by formatID, analyzing the video
+// unless it was analyzed recently
+func (s *SiteService) chapter(ctx context.Context, url, formatID string) (*Chapter, error) {
+	cached := s.ytdlp.cachedAnalysis(url)
+	if cached == nil {
+		if _, err := s.Analyze(ctx, url); err != nil {
+			return nil, err
+		}
+		if cached = s.ytdlp.cachedAnalysis(url); cached == nil {
+			return nil, fmt.Errorf("%w: video could not be analyzed", ErrMediaUnavailable)
+		}
+	}
+	for i := range cached.info.Chapters {
+		if cached.info.Chapters[i].FormatID == formatID {
+			return &cached.info.Chapters[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrFormatNotAvailable, formatID)
+}
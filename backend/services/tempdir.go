@@ -0,0 +1,24 @@
+package services
+
+import (
+	"io/fs"
+	"path/filepath"
+)
+
+// DirSize returns the total size of regular files under dir.
+// Files that disappear while walking (finished downloads) are skipped.
+func DirSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
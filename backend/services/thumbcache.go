@@ -0,0 +1,275 @@
+package services
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachedThumbnail is a cached image together with what is needed to revalidate it
+type CachedThumbnail struct {
+	Key         string `json:"key"`
+	Data        []byte `json:"-"`
+	ContentType string `json:"contentType"`
+	// Hash is the SHA-256 of Data, served as the ETag
+	Hash string `json:"hash"`
+	// ETag and LastModified are the upstream validators
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	Expires      time.Time `json:"expires"`
+}
+
+// Fresh reports whether the entry can be served without revalidation
+func (t *CachedThumbnail) Fresh(now time.Time) bool {
+	return now.Before(t.Expires)
+}
+
+type lruItem struct {
+	key   string
+	size  int64
+	entry *CachedThumbnail
+}
+
+// lru tracks entries by recency and total size
+type lru struct {
+	order   *list.List
+	items   map[string]*list.Element
+	size    int64
+	maxSize int64
+}
+
+func newLRU(maxSize int64) *lru {
+	return &lru{order: list.New(), items: make(map[string]*list.Element), maxSize: maxSize}
+}
+
+func (l *lru) get(key string) (*lruItem, bool) {
+	elem, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(elem)
+	return elem.Value.(*lruItem), true
+}
+
+// add inserts or replaces an entry and returns the keys evicted to stay within maxSize
+func (l *lru) add(item *lruItem) []string {
+	if elem, ok := l.items[item.key]; ok {
+		l.size -= elem.Value.(*lruItem).size
+		elem.Value = item
+		l.order.MoveToFront(elem)
+	} else {
+		l.items[item.key] = l.order.PushFront(item)
+	}
+	l.size += item.size
+
+	var evicted []string
+	for l.size > l.maxSize && l.order.Len() > 1 {
+		oldest := l.order.Back()
+		evictedItem := oldest.Value.(*lruItem)
+		l.order.Remove(oldest)
+		delete(l.items, evictedItem.key)
+		l.size -= evictedItem.size
+		evicted = append(evicted, evictedItem.key)
+	}
+	return evicted
+}
+
+// ThumbnailCache is a two-tier LRU cache: recently used thumbnails in memory,
+// everything else on disk so the cache survives restarts.
+// With an empty dir only the memory tier is used.
+type ThumbnailCache struct {
+	mu     sync.Mutex
+	memory *lru
+	disk   *lru
+	dir    string
+}
+
+// NewThumbnailCache creates a cache holding up to memoryBytes in memory and
+// diskBytes in dir. Entries already in dir are picked up, oldest first evicted.
+func NewThumbnailCache(dir string, memoryBytes, diskBytes int64) (*ThumbnailCache, error) {
+	c := &ThumbnailCache{
+		memory: newLRU(memoryBytes),
+		disk:   newLRU(diskBytes),
+		dir:    dir,
+	}
+	if dir == "" {
+		return c, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create thumbnail cache directory: %w", err)
+	}
+	if err := c.loadIndex(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadIndex rebuilds the disk LRU from the files in dir, ordered by modification time
+func (c *ThumbnailCache) loadIndex() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read thumbnail cache directory: %w", err)
+	}
+
+	type diskFile struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var files []diskFile
+	for _, entry := range entries {
+		// Leftovers of writes interrupted by a crash
+		if strings.HasPrefix(entry.Name(), ".tmp-") {
+			os.Remove(filepath.Join(c.dir, entry.Name()))
+			continue
+		}
+		name, ok := strings.CutSuffix(entry.Name(), ".img")
+		if !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, diskFile{name: name, size: info.Size(), modTime: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	for _, file := range files {
+		for _, evicted := range c.disk.add(&lruItem{key: file.name, size: file.size}) {
+			c.removeFiles(evicted)
+		}
+	}
+	return nil
+}
+
+// Get returns a cached entry, which may be stale
+func (c *ThumbnailCache) Get(key string) (*CachedThumbnail, bool) {
+	name := diskName(key)
+
+	c.mu.Lock()
+	if item, ok := c.memory.get(key); ok {
+		c.mu.Unlock()
+		return item.entry, true
+	}
+	_, onDisk := c.disk.get(name)
+	c.mu.Unlock()
+
+	if !onDisk {
+		return nil, false
+	}
+
+	entry, err := c.readFiles(name)
+	if err != nil || entry.Key != key {
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(c.path(name, ".img"), now, now)
+
+	c.mu.Lock()
+	c.memory.add(&lruItem{key: key, size: int64(len(entry.Data)), entry: entry})
+	c.mu.Unlock()
+	return entry, true
+}
+
+// Put stores an entry in both tiers. Entries must not be modified afterwards.
+func (c *ThumbnailCache) Put(entry *CachedThumbnail) error {
+	size := int64(len(entry.Data))
+
+	c.mu.Lock()
+	c.memory.add(&lruItem{key: entry.Key, size: size, entry: entry})
+	c.mu.Unlock()
+
+	if c.dir == "" {
+		return nil
+	}
+
+	name := diskName(entry.Key)
+	if err := c.writeFiles(name, entry); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	evicted := c.disk.add(&lruItem{key: name, size: size})
+	c.mu.Unlock()
+
+	for _, evictedName := range evicted {
+		c.removeFiles(evictedName)
+	}
+	return nil
+}
+
+func (c *ThumbnailCache) path(name, ext string) string {
+	return filepath.Join(c.dir, name+ext)
+}
+
+// writeFiles writes metadata first and data last, since the data file marks
+// an entry as present; both are renamed into place so readers never see partial files
+func (c *ThumbnailCache) writeFiles(name string, entry *CachedThumbnail) error {
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.path(name, ".json"), meta); err != nil {
+		return fmt.Errorf("failed to write thumbnail cache entry: %w", err)
+	}
+	if err := writeFileAtomic(c.path(name, ".img"), entry.Data); err != nil {
+		return fmt.Errorf("failed to write thumbnail cache entry: %w", err)
+	}
+	return nil
+}
+
+func (c *ThumbnailCache) readFiles(name string) (*CachedThumbnail, error) {
+	meta, err := os.ReadFile(c.path(name, ".json"))
+	if err != nil {
+		return nil, err
+	}
+	var entry CachedThumbnail
+	if err := json.Unmarshal(meta, &entry); err != nil {
+		return nil, err
+	}
+	if entry.Data, err = os.ReadFile(c.path(name, ".img")); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (c *ThumbnailCache) removeFiles(name string) {
+	os.Remove(c.path(name, ".img"))
+	os.Remove(c.path(name, ".json"))
+}
+
+func diskName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"viddown/metrics"
+)
+
+// maxThumbnailTTL caps how long upstream cache headers can keep a thumbnail fresh
+const maxThumbnailTTL = 7 * 24 * time.Hour
+
+var (
+	ErrThumbnailNotFound = errors.New("thumbnail not found")
+	ErrNotImage          = errors.New("response is not an image")
+)
+
+// ThumbnailService fetches thumbnails through a SafeFetcher, caches them and
+// serves resized or converted variants. Stale entries are revalidated with
+// the upstream ETag/Last-Modified and served stale if upstream is unreachable.
+type ThumbnailService struct {
+	fetcher *SafeFetcher
+	cache   *ThumbnailCache
+	minTTL  time.Duration
+	group   singleflight.Group
+}
+
+// NewThumbnailService creates a thumbnail service. minTTL is the shortest time
+// a thumbnail is kept fresh, regardless of upstream cache headers.
+func NewThumbnailService(fetcher *SafeFetcher, cache *ThumbnailCache, minTTL time.Duration) *ThumbnailService {
+	return &ThumbnailService{
+		fetcher: fetcher,
+		cache:   cache,
+		minTTL:  minTTL,
+	}
+}
+
+// Get returns the thumbnail at rawURL transformed according to opts
+func (s *ThumbnailService) Get(ctx context.Context, rawURL string, opts ThumbnailOptions) (*CachedThumbnail, error) {
+	original, err := s.original(ctx, rawURL)
+	if err != nil || opts.IsZero() {
+		return original, err
+	}
+
+	// Variants are keyed by the original's content, so they are replaced with it
+	key := "variant|" + original.Hash + "|" + opts.key()
+	variant, ok := s.cache.Get(key)
+	if !ok {
+		result, err, _ := s.group.Do(key, func() (any, error) {
+			data, contentType, err := TransformImage(original.Data, opts)
+			if err != nil {
+				return nil, err
+			}
+			variant := &CachedThumbnail{Key: key, Data: data, ContentType: contentType, Hash: hashBytes(data)}
+			s.cache.Put(variant)
+			return variant, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		variant = result.(*CachedThumbnail)
+	}
+
+	served := *variant
+	served.Expires = original.Expires
+	return &served, nil
+}
+
+// original returns the untransformed thumbnail, from cache when fresh
+func (s *ThumbnailService) original(ctx context.Context, rawURL string) (*CachedThumbnail, error) {
+	key := "original|" + rawURL
+	cached, ok := s.cache.Get(key)
+	if ok && cached.Fresh(time.Now()) {
+		metrics.ThumbnailCache.WithLabelValues("hit").Inc()
+		return cached, nil
+	}
+
+	// Concurrent requests for the same thumbnail share one upstream fetch,
+	// which must not be cut short when the first requester goes away
+	result, err, _ := s.group.Do(key, func() (any, error) {
+		return s.fetch(context.WithoutCancel(ctx), key, rawURL, cached)
+	})
+	if err != nil {
+		if cached != nil && !errors.Is(err, ErrHostNotAllowed) {
+			metrics.ThumbnailCache.WithLabelValues("stale").Inc()
+			return cached, nil
+		}
+		return nil, err
+	}
+	return result.(*CachedThumbnail), nil
+}
+
+// fetch downloads a thumbnail, or revalidates stale when it is not nil
+func (s *ThumbnailService) fetch(ctx context.Context, key, rawURL string, stale *CachedThumbnail) (*CachedThumbnail, error) {
+	header := http.Header{}
+	// Look like a browser; some CDNs reject unknown clients
+	header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	header.Set("Accept", "image/*")
+	header.Set("Referer", "https://www.youtube.com/")
+	if stale != nil {
+		if stale.ETag != "" {
+			header.Set("If-None-Match", stale.ETag)
+		}
+		if stale.LastModified != "" {
+			header.Set("If-Modified-Since", stale.LastModified)
+		}
+	}
+
+	resp, err := s.fetcher.Get(ctx, rawURL, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && stale != nil:
+		metrics.ThumbnailCache.WithLabelValues("revalidated").Inc()
+		refreshed := *stale
+		refreshed.Expires = time.Now().Add(s.ttl(resp.Header))
+		s.cache.Put(&refreshed)
+		return &refreshed, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, ErrThumbnailNotFound
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	contentType, ok := SniffImage(data, resp.Header.Get("Content-Type"))
+	if !ok {
+		return nil, ErrNotImage
+	}
+
+	metrics.ThumbnailCache.WithLabelValues("miss").Inc()
+	entry := &CachedThumbnail{
+		Key:          key,
+		Data:         data,
+		ContentType:  contentType,
+		Hash:         hashBytes(data),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Expires:      time.Now().Add(s.ttl(resp.Header)),
+	}
+	s.cache.Put(entry)
+	return entry, nil
+}
+
+// ttl derives freshness from upstream Cache-Control (s-maxage, then max-age)
+// or Expires, never shorter than minTTL nor longer than maxThumbnailTTL
+func (s *ThumbnailService) ttl(header http.Header) time.Duration {
+	var upstream time.Duration
+	directives := parseCacheControl(header.Get("Cache-Control"))
+	if age, ok := directives["s-maxage"]; ok {
+		upstream = age
+	} else if age, ok := directives["max-age"]; ok {
+		upstream = age
+	} else if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+		upstream = time.Until(expires)
+	}
+	return min(max(upstream, s.minTTL), maxThumbnailTTL)
+}
+
+// parseCacheControl returns the delta-seconds directives of a Cache-Control header
+func parseCacheControl(value string) map[string]time.Duration {
+	directives := make(map[string]time.Duration)
+	for _, part := range strings.Split(value, ",") {
+		name, arg, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		if seconds, err := strconv.Atoi(strings.Trim(arg, `"`)); err == nil && seconds >= 0 {
+			directives[strings.ToLower(name)] = time.Duration(seconds) * time.Second
+		}
+	}
+	return directives
+}
+
+// SniffImage returns the type of image data.
+// Formats the sniffer doesn't know (AVIF, HEIC) fall back to the declared type
+// when it is a raster image type; SVG is never accepted since it can carry script.
+func SniffImage(data []byte, declared string) (string, bool) {
+	sniffed := http.DetectContentType(data)
+	if strings.HasPrefix(sniffed, "image/") {
+		return sniffed, true
+	}
+
+	declared = strings.ToLower(strings.TrimSpace(strings.Split(declared, ";")[0]))
+	if sniffed == "application/octet-stream" && strings.HasPrefix(declared, "image/") && declared != "image/svg+xml" {
+		return declared, true
+	}
+	return "", false
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"viddown/metrics"
+)
+
+// TikTokService handles TikTok videos through yt-dlp. TikTok serves each
+// video both with a watermark (the app's "download" file) and clean
+// (the playback streams); clean variants are offered first.
+type TikTokService struct {
+	ytdlp *YtDlpService
+}
+
+// NewTikTokService creates the TikTok backend
+func NewTikTokService(ytdlp *YtDlpService) *TikTokService {
+	return &TikTokService{ytdlp: ytdlp}
+}
+
+func (s *TikTokService) Analyze(ctx context.Context, url string) (*VideoInfo, error) {
+	info, err := s.ytdlp.sharedAnalysis(ctx, url, func(ctx context.Context) (*VideoInfo, error) {
+		return s.analyze(ctx, url)
+	})
+	metrics.ObserveOperation("analyze", string(PlatformTikTok), ClassifyError(err))
+	return info, err
+}
+
+func (s *TikTokService) analyze(ctx context.Context, url string) (*VideoInfo, error) {
+	raw, err := s.ytdlp.extractInfo(ctx, url, "--no-playlist")
+	if err != nil {
+		return nil, err
+	}
+
+	info := &VideoInfo{
+		ID:          raw.ID,
+		Platform:    PlatformTikTok,
+		Title:       raw.Title,
+		Duration:    int(raw.Duration),
+		Thumbnail:   raw.Thumbnail,
+		Author:      raw.author(),
+		Description: raw.Description,
+		Formats:     tiktokFormats(raw.Formats),
+	}
+	if info.Title == "" {
+		info.Title = "TikTok " + info.Author
+	}
+
+	s.ytdlp.rememberAnalysis(url, raw, info)
+	return info, nil
+}
+
+// isWatermarked tells the app's download file, which carries the TikTok
+// watermark, from the clean playback streams
+func isWatermarked(f *ytdlpFormat) bool {
+	return strings.Contains(strings.ToLower(f.FormatNote), "watermark") || strings.HasPrefix(f.FormatID, "download")
+}
+
+// tiktokFormats reduces the formats of a TikTok video to its sound and one
+// choice per resolution, clean variants over watermarked ones and H.264
+// over H.265, which many players can't decode
+func tiktokFormats(raw []ytdlpFormat) []Format {
+	var audio, bestClean, bestAny *ytdlpFormat
+	videos := make(map[int]*ytdlpFormat)
+	// yt-dlp lists formats from worst to best, so later ones win ties
+	for i := range raw {
+		f := &raw[i]
+		switch {
+		case f.VCodec == "none" && f.ACodec != "none":
+			audio = f
+		case f.resolution() > 0:
+			current := videos[f.resolution()]
+			if current == nil || tiktokRank(f) >= tiktokRank(current) {
+				videos[f.resolution()] = f
+			}
+			if bestAny == nil || f.resolution() >= bestAny.resolution() {
+				bestAny = f
+			}
+			if !isWatermarked(f) && (bestClean == nil || f.resolution() >= bestClean.resolution()) {
+				bestClean = f
+			}
+		}
+	}
+
+	// Watermarked files are only offered for videos that have nothing else
+	if bestClean != nil {
+		for resolution, f := range videos {
+			if isWatermarked(f) {
+				delete(videos, resolution)
+			}
+		}
+	}
+
+	var formats []Format
+	// The sound is its own stream only on some videos; otherwise it is
+	// extracted from the best clean video
+	if audio != nil {
+		formats = append(formats, Format{ID: audio.FormatID, Type: "audio", Quality: "Лучшее аудио", Ext: "m4a", Size: audio.Filesize})
+	} else if source := firstFormat(bestClean, bestAny); source != nil {
+		formats = append(formats, Format{ID: source.FormatID, Type: "audio", Quality: "Аудио из видео", Ext: "m4a"})
+	}
+
+	resolutions := make([]int, 0, len(videos))
+	for resolution := range videos {
+		resolutions = append(resolutions, resolution)
+	}
+	sort.Ints(resolutions)
+
+	for _, resolution := range resolutions {
+		f := videos[resolution]
+		label := "без водяного знака"
+		if isWatermarked(f) {
+			label = "с водяным знаком"
+		}
+		formats = append(formats, Format{
+			ID:      f.FormatID,
+			Type:    "video",
+			Quality: fmt.Sprintf("%dp (%s)", resolution, label),
+			Ext:     "mp4",
+			Size:    f.Filesize,
+		})
+	}
+	return formats
+}
+
+// tiktokRank orders variants of one resolution: clean before watermarked,
+// then H.264 before other codecs
+func tiktokRank(f *ytdlpFormat) int {
+	rank := 0
+	if !isWatermarked(f) {
+		rank += 2
+	}
+	if strings.HasPrefix(f.VCodec, "h264") || strings.HasPrefix(f.VCodec, "avc1") {
+		rank++
+	}
+	return rank
+}
+
+func firstFormat(formats ...*ytdlpFormat) *ytdlpFormat {
+	for _, f := range formats {
+		if f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// BestFormats returns the formats as analyzed, which are already simplified
+func (s *TikTokService) BestFormats(info *VideoInfo) []Format {
+	return info.Formats
+}
+
+func (s *TikTokService) Identify(url string) (Platform, string) {
+	return s.ytdlp.Identify(url)
+}
+
+func (s *TikTokService) Canonicalize(url string) (*CanonicalURL, error) {
+	return s.ytdlp.Canonicalize(url)
+}
+
+func (s *TikTokService) EstimateWork(url, formatID string, isAudioOnly bool) DownloadWork {
+	return s.ytdlp.EstimateWork(url, formatID, isAudioOnly)
+}
+
+// DownloadToFile downloads a format of a TikTok video. "best" is narrowed
+// to clean variants when there are any, by the same tests as isWatermarked.
+func (s *TikTokService) DownloadToFile(ctx context.Context, url, formatID, tempDir string, isAudioOnly bool) (filePath string, filename string, err error) {
+	defer func() {
+		metrics.ObserveOperation("download", string(PlatformTikTok), ClassifyError(err))
+	}()
+
+	if formatID == "best" {
+		formatID = "best[format_note!*=watermark][format_note!*=Watermark][format_id!^=download]/best"
+	}
+	return s.ytdlp.download(ctx, url, formatID, tempDir, isAudioOnly, "--no-playlist")
+}
@@ -2,52 +2,85 @@ package services
 
 import (
 	"errors"
+	"fmt"
 	"net/url"
-	"regexp"
 	"strings"
 )
 
 type Platform string
 
 const (
-	PlatformYouTube   Platform = "youtube"
-	PlatformInstagram Platform = "instagram"
-	PlatformTikTok    Platform = "tiktok"
-	PlatformUnknown   Platform = "unknown"
+	PlatformYouTube      Platform = "youtube"
+	PlatformYouTubeMusic Platform = "youtube_music"
+	PlatformInstagram    Platform = "instagram"
+	PlatformTikTok       Platform = "tiktok"
+	PlatformVimeo        Platform = "vimeo"
+	PlatformX            Platform = "x"
+	PlatformReddit       Platform = "reddit"
+	PlatformSoundCloud   Platform = "soundcloud"
+	PlatformTwitch       Platform = "twitch"
+	PlatformDirect       Platform = "direct" // plain media file on an allowlisted host
+	PlatformUnknown      Platform = "unknown"
 )
 
 var (
 	ErrInvalidURL     = errors.New("invalid URL")
 	ErrUnsupportedURL = errors.New("unsupported platform")
+	ErrURLTooLong     = fmt.Errorf("%w: too long", ErrInvalidURL)
 )
 
-var platformPatterns = map[Platform]*regexp.Regexp{
-	PlatformYouTube:   regexp.MustCompile(`(?i)(youtube\.com|youtu\.be|music\.youtube\.com)`),
-	PlatformInstagram: regexp.MustCompile(`(?i)(instagram\.com|instagr\.am)`),
-	PlatformTikTok:    regexp.MustCompile(`(?i)(tiktok\.com|vm\.tiktok\.com)`),
+type Validator struct {
+	maxURLLength int
+	platforms    *PlatformRegistry
 }
 
-type Validator struct{}
-
-func NewValidator() *Validator {
-	return &Validator{}
+// NewValidator creates a validator recognizing the platforms of the registry
+// and rejecting URLs longer than maxURLLength characters
+func NewValidator(maxURLLength int, platforms *PlatformRegistry) *Validator {
+	return &Validator{maxURLLength: maxURLLength, platforms: platforms}
 }
 
 func (v *Validator) ValidateURL(rawURL string) (Platform, error) {
+	canonical, err := v.Canonicalize(rawURL)
+	return canonical.Platform, err
+}
+
+// Identify returns the platform of rawURL and the media ID found in the URL
+// itself, "" when the URL doesn't carry one
+func (v *Validator) Identify(rawURL string) (Platform, string) {
+	canonical, _ := v.Canonicalize(rawURL)
+	return canonical.Platform, canonical.ID
+}
+
+// Canonicalize validates rawURL and returns its canonical form. On error the
+// result still names the platform when it is known.
+func (v *Validator) Canonicalize(rawURL string) (*CanonicalURL, error) {
 	rawURL = strings.TrimSpace(rawURL)
-	
+	if len(rawURL) > v.maxURLLength {
+		return &CanonicalURL{Platform: PlatformUnknown}, ErrURLTooLong
+	}
+
 	parsed, err := url.Parse(rawURL)
-	if err != nil || parsed.Host == "" {
-		return PlatformUnknown, ErrInvalidURL
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return &CanonicalURL{Platform: PlatformUnknown}, ErrInvalidURL
 	}
 
-	for platform, pattern := range platformPatterns {
-		if pattern.MatchString(parsed.Host) {
-			return platform, nil
+	spec, err := v.platforms.Match(parsed)
+	if err != nil {
+		return &CanonicalURL{Platform: spec.Platform}, err
+	}
+	if spec != nil {
+		canonical := &CanonicalURL{Platform: spec.Platform}
+		if spec.ExtractID != nil {
+			canonical.ID = spec.ExtractID(parsed)
+		}
+		if spec.Canonicalize != nil {
+			canonical.URL = spec.Canonicalize(parsed, canonical.ID)
+		} else {
+			canonical.URL = cleanURL(parsed)
 		}
+		return canonical, nil
 	}
 
-	return PlatformUnknown, ErrUnsupportedURL
+	return &CanonicalURL{Platform: PlatformUnknown}, ErrUnsupportedURL
 }
-
-
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"strings"
+	"time"
+)
+
+// WeightUnit is the semaphore weight of a typical download (1080p, video+audio merge).
+// Capacity is configured in downloads and multiplied by this to get slots.
+const WeightUnit = 3
+
+// DownloadWork describes the expected cost of a download
+type DownloadWork struct {
+	// Height of the video stream, 0 for audio or when unknown
+	Height int
+	// Duration of the media, 0 when unknown
+	Duration time.Duration
+	// Merge is true when separate video and audio streams are muxed by ffmpeg
+	Merge bool
+	// Transcode is true when ffmpeg has to re-encode rather than stream-copy
+	Transcode bool
+}
+
+// Weight converts expected work into semaphore slots.
+// A stream-copy download costs the least; transcoding, high resolutions
+// and long durations make a download proportionally more expensive.
+func (w DownloadWork) Weight() int {
+	weight := 2
+	if w.Merge {
+		weight++
+	}
+	if w.Transcode {
+		weight += 2
+	}
+	switch {
+	case w.Height > 1440:
+		weight += 2
+	case w.Height > 1080:
+		weight++
+	}
+	switch {
+	case w.Duration > 3*time.Hour:
+		weight += 2
+	case w.Duration > time.Hour:
+		weight++
+	}
+	return weight
+}
+
+// EstimateWork predicts the work of downloading formatID from url.
+// It relies on a recent Analyze call for the same video; without one only the
+// format ID itself is used.
+func (s *YtDlpService) EstimateWork(url, formatID string, isAudioOnly bool) DownloadWork {
+	work := DownloadWork{
+		Merge: strings.Contains(formatID, "+"),
+	}
+
+	cached := s.cachedAnalysis(url)
+	if cached == nil {
+		// Unknown source: assume audio extraction re-encodes
+		work.Transcode = isAudioOnly
+		return work
+	}
+
+	work.Duration = time.Duration(cached.duration * float64(time.Second))
+	for _, id := range strings.Split(formatID, "+") {
+		f, ok := cached.formats[id]
+		if !ok {
+			continue
+		}
+		work.Height = max(work.Height, f.Height)
+		// Audio extraction to m4a is a stream copy only when the source is already AAC
+		if isAudioOnly && !strings.HasPrefix(f.ACodec, "mp4a") {
+			work.Transcode = true
+		}
+	}
+	return work
+}
@@ -9,7 +9,20 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"viddown/metrics"
+)
+
+const (
+	// analysisTTL is how long analyze results are kept for costing downloads
+	analysisTTL = 30 * time.Minute
+	// analysisTimeout bounds an analysis shared by concurrent requests,
+	// which no longer ends with the request that started it
+	analysisTimeout = 5 * time.Minute
 )
 
 type Format struct {
@@ -21,22 +34,50 @@ type Format struct {
 }
 
 type VideoInfo struct {
+	ID        string   `json:"id"`
 	Platform  Platform `json:"platform"`
 	Title     string   `json:"title"`
 	Duration  int      `json:"duration"`
 	Thumbnail string   `json:"thumbnail"`
-	Formats   []Format `json:"formats"`
+	// Author and Description are reported for platforms that have them
+	Author      string   `json:"author,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Formats     []Format `json:"formats"`
+	// Items are the photos and videos of a post with several of them
+	Items []MediaItem `json:"items,omitempty"`
+	// Chapters are the titled sections of a long video
+	Chapters []Chapter `json:"chapters,omitempty"`
+	// Music is the metadata of a music track or album
+	Music *MusicInfo `json:"music,omitempty"`
 }
 
 type YtDlpService struct {
 	ytdlpPath string
 	validator *Validator
+	logs      *ProcessLogStore
+
+	mu       sync.Mutex
+	analyses map[string]*analysis
+	// inflight shares a running analysis between requests for the same video
+	inflight singleflight.Group
 }
 
-func NewYtDlpService(ytdlpPath string, validator *Validator) *YtDlpService {
+// analysis keeps the result and raw format details of a recent Analyze call
+type analysis struct {
+	info     *VideoInfo
+	raw      *ytdlpInfo
+	id       string
+	duration float64
+	formats  map[string]ytdlpFormat
+	expires  time.Time
+}
+
+func NewYtDlpService(ytdlpPath string, validator *Validator, logs *ProcessLogStore) *YtDlpService {
 	return &YtDlpService{
 		ytdlpPath: ytdlpPath,
 		validator: validator,
+		logs:      logs,
+		analyses:  make(map[string]*analysis),
 	}
 }
 
@@ -44,60 +85,230 @@ type ytdlpFormat struct {
 	FormatID   string  `json:"format_id"`
 	Ext        string  `json:"ext"`
 	Resolution string  `json:"resolution"`
+	Width      int     `json:"width"`
 	VCodec     string  `json:"vcodec"`
 	ACodec     string  `json:"acodec"`
 	Filesize   int64   `json:"filesize"`
 	ABR        float64 `json:"abr"`
 	Height     int     `json:"height"`
 	FormatNote string  `json:"format_note"`
+	// Protocol is "https" for files served whole, "m3u8_native" for HLS
+	// and "http_dash_segments" for DASH
+	Protocol string `json:"protocol"`
 }
 
 type ytdlpInfo struct {
-	Title     string        `json:"title"`
-	Duration  float64       `json:"duration"`
-	Thumbnail string        `json:"thumbnail"`
-	Formats   []ytdlpFormat `json:"formats"`
-	Extractor string        `json:"extractor"`
+	// Type is "playlist" for posts and playlists, whose items are in Entries
+	Type       string           `json:"_type"`
+	ID         string           `json:"id"`
+	Title      string           `json:"title"`
+	Duration   float64          `json:"duration"`
+	Thumbnail  string           `json:"thumbnail"`
+	Thumbnails []ytdlpThumbnail `json:"thumbnails"`
+	Width      int              `json:"width"`
+	Height     int              `json:"height"`
+	Formats    []ytdlpFormat    `json:"formats"`
+	Entries    []ytdlpInfo      `json:"entries"`
+	Extractor  string           `json:"extractor"`
+	// URL and WebpageURL locate the entries of flat playlists and searches
+	URL        string `json:"url"`
+	WebpageURL string `json:"webpage_url"`
+	// Uploader is the account name, Channel and Creator the display name
+	Uploader    string `json:"uploader"`
+	Channel     string `json:"channel"`
+	Creator     string `json:"creator"`
+	Description string `json:"description"`
+	// Chapters are sections of the video, e.g. the games of a Twitch VOD
+	Chapters []ytdlpChapter `json:"chapters"`
+	// Music fields, reported for YouTube Music tracks
+	Track       string   `json:"track"`
+	Artist      string   `json:"artist"`
+	Artists     []string `json:"artists"`
+	Album       string   `json:"album"`
+	ReleaseYear int      `json:"release_year"`
+	TrackNumber int      `json:"track_number"`
+}
+
+// author returns the display name of the uploader, or the account name
+func (info *ytdlpInfo) author() string {
+	return firstNonEmpty(info.Channel, info.Creator, info.Uploader)
+}
+
+// resolution names a video format by its shorter side, so vertical videos
+// get the usual 720p-style names; 0 when the size is unknown
+func (f *ytdlpFormat) resolution() int {
+	if f.Width > 0 && f.Height > 0 {
+		return min(f.Width, f.Height)
+	}
+	return f.Height
+}
+
+type ytdlpChapter struct {
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+	Title     string  `json:"title"`
+}
+
+type ytdlpThumbnail struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
 }
 
 func (s *YtDlpService) Analyze(ctx context.Context, url string) (*VideoInfo, error) {
 	platform, err := s.validator.ValidateURL(url)
 	if err != nil {
+		metrics.ObserveOperation("analyze", string(platform), ClassifyError(err))
 		return nil, err
 	}
 
-	cmd := exec.CommandContext(ctx, s.ytdlpPath,
-		"--dump-json",
-		"--no-download",
-		"--no-warnings",
-		"--no-playlist",
-		url,
-	)
+	info, err := s.sharedAnalysis(ctx, url, func(ctx context.Context) (*VideoInfo, error) {
+		return s.analyze(ctx, url, platform)
+	})
+	metrics.ObserveOperation("analyze", string(platform), ClassifyError(err))
+	return info, err
+}
 
-	output, err := cmd.Output()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return nil, fmt.Errorf("yt-dlp error: %s", string(exitErr.Stderr))
+// sharedAnalysis returns the recent analysis of url's video, or else runs
+// analyze once for all concurrent requests for that video. analyze is
+// expected to remember its result. It gets a context of its own, so a client
+// disconnecting doesn't fail the analysis for the others waiting on it;
+// each caller still stops waiting when its ctx ends.
+func (s *YtDlpService) sharedAnalysis(ctx context.Context, url string, analyze func(ctx context.Context) (*VideoInfo, error)) (*VideoInfo, error) {
+	if cached := s.cachedAnalysis(url); cached != nil {
+		info := *cached.info
+		return &info, nil
+	}
+
+	shared := s.inflight.DoChan(s.analysisKey(url), func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), analysisTimeout)
+		defer cancel()
+		return analyze(ctx)
+	})
+	select {
+	case result := <-shared:
+		if result.Err != nil {
+			return nil, result.Err
 		}
-		return nil, fmt.Errorf("failed to execute yt-dlp: %w", err)
+		info := *result.Val.(*VideoInfo)
+		return &info, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
+}
 
-	var info ytdlpInfo
-	if err := json.Unmarshal(output, &info); err != nil {
-		return nil, fmt.Errorf("failed to parse yt-dlp output: %w", err)
+func (s *YtDlpService) analyze(ctx context.Context, url string, platform Platform) (*VideoInfo, error) {
+	info, err := s.extractInfo(ctx, url, "--no-playlist")
+	if err != nil {
+		return nil, err
 	}
 
 	duration := int(info.Duration)
 
 	formats := s.parseFormats(info.Formats)
-
-	return &VideoInfo{
+	videoInfo := &VideoInfo{
+		ID:        info.ID,
 		Platform:  platform,
 		Title:     info.Title,
 		Duration:  duration,
 		Thumbnail: info.Thumbnail,
 		Formats:   formats,
-	}, nil
+	}
+	s.rememberAnalysis(url, info, videoInfo)
+
+	return videoInfo, nil
+}
+
+// extractInfo runs yt-dlp to read the metadata of url without downloading;
+// playlists come back as a whole with their entries
+func (s *YtDlpService) extractInfo(ctx context.Context, url string, extraArgs ...string) (*ytdlpInfo, error) {
+	args := append([]string{
+		"--dump-single-json",
+		"--no-download",
+		"--no-warnings",
+	}, extraArgs...)
+	ctx, inv := s.command(ctx, "analyze", append(args, url)...)
+
+	start := time.Now()
+	output, err := inv.cmd.Output()
+	metrics.ObservePhase("analyze", time.Since(start), err)
+	inv.finish(err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("yt-dlp interrupted: %w", ctx.Err())
+		}
+		if _, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("yt-dlp error: %s", inv.summary())
+		}
+		return nil, fmt.Errorf("failed to execute yt-dlp: %w", startError(inv.cmd, err, ErrYtDlpUnavailable))
+	}
+
+	var info ytdlpInfo
+	if err := json.Unmarshal(output, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse yt-dlp output: %w", err)
+	}
+	return &info, nil
+}
+
+func (s *YtDlpService) rememberAnalysis(url string, info *ytdlpInfo, videoInfo *VideoInfo) {
+	entry := &analysis{
+		info:     videoInfo,
+		raw:      info,
+		id:       info.ID,
+		duration: info.Duration,
+		formats:  make(map[string]ytdlpFormat, len(info.Formats)),
+		expires:  time.Now().Add(analysisTTL),
+	}
+	for _, f := range info.Formats {
+		entry.formats[f.FormatID] = f
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, a := range s.analyses {
+		if now.After(a.expires) {
+			delete(s.analyses, key)
+		}
+	}
+	s.analyses[s.analysisKey(url)] = entry
+}
+
+func (s *YtDlpService) cachedAnalysis(url string) *analysis {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.analyses[s.analysisKey(url)]
+	if !ok || time.Now().After(a.expires) {
+		return nil
+	}
+	return a
+}
+
+// analysisKey returns the key under which analyses of url are shared, so
+// links to one video in different forms are analyzed once
+func (s *YtDlpService) analysisKey(url string) string {
+	canonical, err := s.validator.Canonicalize(url)
+	if err != nil {
+		return url
+	}
+	return canonical.Key()
+}
+
+// Canonicalize returns the canonical form of url
+func (s *YtDlpService) Canonicalize(url string) (*CanonicalURL, error) {
+	return s.validator.Canonicalize(url)
+}
+
+// Identify returns the platform of url and its video ID, taken from a recent
+// analysis or else from the URL itself
+func (s *YtDlpService) Identify(url string) (Platform, string) {
+	platform, id := s.validator.Identify(url)
+	if cached := s.cachedAnalysis(url); cached != nil {
+		return platform, cached.id
+	}
+	return platform, id
 }
 
 func (s *YtDlpService) parseFormats(ytFormats []ytdlpFormat) []Format {
@@ -156,11 +367,20 @@ func (s *YtDlpService) parseFormats(ytFormats []ytdlpFormat) []Format {
 // DownloadToFile downloads video to a temp file and returns the file path and filename
 // isAudioOnly should be true when downloading audio-only formats
 func (s *YtDlpService) DownloadToFile(ctx context.Context, url, formatID, tempDir string, isAudioOnly bool) (filePath string, filename string, err error) {
-	_, err = s.validator.ValidateURL(url)
+	platform, err := s.validator.ValidateURL(url)
+	defer func() {
+		metrics.ObserveOperation("download", string(platform), ClassifyError(err))
+	}()
 	if err != nil {
 		return "", "", err
 	}
 
+	return s.download(ctx, url, formatID, tempDir, isAudioOnly, "--no-playlist")
+}
+
+// download runs yt-dlp to download formatID of url into tempDir, with
+// extraArgs selecting playlist items or passing credentials
+func (s *YtDlpService) download(ctx context.Context, url, formatID, tempDir string, isAudioOnly bool, extraArgs ...string) (filePath string, filename string, err error) {
 	// Generate unique filename prefix
 	timestamp := time.Now().UnixNano()
 	outputTemplate := filepath.Join(tempDir, fmt.Sprintf("%d_%%(title)s.%%(ext)s", timestamp))
@@ -170,9 +390,9 @@ func (s *YtDlpService) DownloadToFile(ctx context.Context, url, formatID, tempDi
 		"-f", formatID,
 		"-o", outputTemplate,
 		"--no-warnings",
-		"--no-playlist",
 		"--no-mtime",
 	}
+	args = append(args, extraArgs...)
 
 	// For merged formats (video+audio), explicitly set output format to mp4
 	// This ensures ffmpeg properly merges the streams into a valid container
@@ -193,22 +413,10 @@ func (s *YtDlpService) DownloadToFile(ctx context.Context, url, formatID, tempDi
 
 	args = append(args, url)
 
-	cmd := exec.CommandContext(ctx, s.ytdlpPath, args...)
-	cmd.Stderr = os.Stderr // Log errors
-
-	output, err := cmd.Output()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return "", "", fmt.Errorf("download failed: %s", string(exitErr.Stderr))
-		}
-		return "", "", fmt.Errorf("download failed: %w", err)
+	if err := s.runDownload(ctx, args...); err != nil {
+		return "", "", err
 	}
 
-	// Parse output to find downloaded file path
-	// yt-dlp prints the destination path
-	outputStr := string(output)
-	_ = outputStr
-
 	// Find the downloaded file by pattern
 	pattern := filepath.Join(tempDir, fmt.Sprintf("%d_*", timestamp))
 	matches, err := filepath.Glob(pattern)
@@ -242,7 +450,29 @@ func (s *YtDlpService) DownloadToFile(ctx context.Context, url, formatID, tempDi
 	return filePath, filename, nil
 }
 
-func (s *YtDlpService) GetBestFormats(formats []Format) []Format {
+// runDownload runs a yt-dlp download command, describing a failure by the
+// tail of its output
+func (s *YtDlpService) runDownload(ctx context.Context, args ...string) error {
+	ctx, inv := s.command(ctx, "download", args...)
+
+	err := runPhased(ctx, inv)
+	inv.finish(err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("download interrupted: %w", ctx.Err())
+		}
+		if _, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("download failed: %s", inv.summary())
+		}
+		return fmt.Errorf("download failed: %w", startError(inv.cmd, err, ErrYtDlpUnavailable))
+	}
+	return nil
+}
+
+// BestFormats reduces the formats of an analyzed video to the choices offered
+// to users: the best audio, plus one video+audio combination per resolution
+func (s *YtDlpService) BestFormats(info *VideoInfo) []Format {
+	formats := info.Formats
 	var best []Format
 
 	// Find best audio format
@@ -315,7 +545,7 @@ func (s *YtDlpService) GetFilename(ctx context.Context, url, formatID string) (s
 		baseFormatID = parts[0]
 	}
 
-	cmd := exec.CommandContext(ctx, s.ytdlpPath,
+	_, inv := s.command(ctx, "get-filename",
 		"--get-filename",
 		"-f", baseFormatID,
 		"-o", "%(title)s.%(ext)s",
@@ -323,9 +553,10 @@ func (s *YtDlpService) GetFilename(ctx context.Context, url, formatID string) (s
 		url,
 	)
 
-	output, err := cmd.Output()
+	output, err := inv.cmd.Output()
+	inv.finish(err)
 	if err != nil {
-		return "", fmt.Errorf("failed to get filename: %w", err)
+		return "", fmt.Errorf("failed to get filename: %w", startError(inv.cmd, err, ErrYtDlpUnavailable))
 	}
 
 	filename := strings.TrimSpace(string(output))
@@ -1,30 +1,36 @@
 import { useState, useCallback } from 'react';
 import { motion, AnimatePresence } from 'framer-motion';
-import { Download, AlertCircle, RefreshCw, Youtube, Clock, CheckCircle } from 'lucide-react';
+import { Download, AlertCircle, RefreshCw, Youtube, CheckCircle } from 'lucide-react';
 import { useDisclaimer } from './hooks/useDisclaimer';
+import { useConfig } from './hooks/useConfig';
 import {
   DisclaimerModal,
   UrlInput,
   VideoPreview,
   FormatSelector,
   DownloadButton,
+  SearchResults,
 } from './components';
-import { analyzeUrl, downloadFile } from './api/client';
-import type { VideoInfo, Format, AppState } from './types';
+import { analyzeUrl, downloadFile, searchVideos } from './api/client';
+import type { VideoInfo, Format, AppState, SearchResult, QueueEntry } from './types';
 
 function App() {
   const { accepted, accept } = useDisclaimer();
+  const config = useConfig();
   const [state, setState] = useState<AppState>('idle');
   const [video, setVideo] = useState<VideoInfo | null>(null);
   const [selectedFormat, setSelectedFormat] = useState<Format | null>(null);
   const [currentUrl, setCurrentUrl] = useState('');
+  const [searchResults, setSearchResults] = useState<SearchResult[] | null>(null);
   const [error, setError] = useState<string | null>(null);
   const [downloadProgress, setDownloadProgress] = useState(0);
+  const [queueEntry, setQueueEntry] = useState<QueueEntry | null>(null);
 
   const handleAnalyze = useCallback(async (url: string) => {
     setError(null);
     setState('analyzing');
     setVideo(null);
+    setSearchResults(null);
     setSelectedFormat(null);
     setCurrentUrl(url);
     setDownloadProgress(0);
@@ -32,6 +38,7 @@ function App() {
     try {
       const info = await analyzeUrl(url);
       setVideo(info);
+      setCurrentUrl(info.url || url);
       const firstVideo = info.formats.find((f) => f.type === 'video');
       const firstAudio = info.formats.find((f) => f.type === 'audio');
       setSelectedFormat(firstVideo || firstAudio || info.formats[0] || null);
@@ -42,6 +49,22 @@ function App() {
     }
   }, []);
 
+  const handleSearch = useCallback(async (query: string) => {
+    setError(null);
+    setState('analyzing');
+    setVideo(null);
+    setSearchResults(null);
+    setSelectedFormat(null);
+
+    try {
+      setSearchResults(await searchVideos(query));
+      setState('idle');
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Произошла ошибка');
+      setState('error');
+    }
+  }, []);
+
   const handleDownload = useCallback(async () => {
     if (!currentUrl || !selectedFormat) return;
 
@@ -51,7 +74,7 @@ function App() {
     try {
       await downloadFile(currentUrl, selectedFormat.id, selectedFormat.type, (progress) => {
         setDownloadProgress(progress);
-      });
+      }, setQueueEntry);
       
       // Download complete!
       setState('ready');
@@ -65,6 +88,7 @@ function App() {
   const handleReset = () => {
     setState('idle');
     setVideo(null);
+    setSearchResults(null);
     setSelectedFormat(null);
     setCurrentUrl('');
     setError(null);
@@ -142,8 +166,8 @@ function App() {
             <CheckCircle className="w-3.5 h-3.5 text-green-400" />
           </div>
 
-          {/* Instagram - Coming Soon */}
-          <div className="flex items-center justify-center gap-2 h-10 px-4 rounded-xl bg-white/5 border border-white/10 opacity-50">
+          {/* Instagram - Active */}
+          <div className="flex items-center justify-center gap-2 h-10 px-4 rounded-xl bg-white/5 border border-white/10">
             <svg className="w-4 h-4" viewBox="0 0 24 24" fill="url(#ig-grad2)">
               <defs>
                 <linearGradient id="ig-grad2" x1="0%" y1="100%" x2="100%" y2="0%">
@@ -154,17 +178,17 @@ function App() {
               </defs>
               <path d="M12 2.163c3.204 0 3.584.012 4.85.07 3.252.148 4.771 1.691 4.919 4.919.058 1.265.069 1.645.069 4.849 0 3.205-.012 3.584-.069 4.849-.149 3.225-1.664 4.771-4.919 4.919-1.266.058-1.644.07-4.85.07-3.204 0-3.584-.012-4.849-.07-3.26-.149-4.771-1.699-4.919-4.92-.058-1.265-.07-1.644-.07-4.849 0-3.204.013-3.583.07-4.849.149-3.227 1.664-4.771 4.919-4.919 1.266-.057 1.645-.069 4.849-.069zm0-2.163c-3.259 0-3.667.014-4.947.072-4.358.2-6.78 2.618-6.98 6.98-.059 1.281-.073 1.689-.073 4.948 0 3.259.014 3.668.072 4.948.2 4.358 2.618 6.78 6.98 6.98 1.281.058 1.689.072 4.948.072 3.259 0 3.668-.014 4.948-.072 4.354-.2 6.782-2.618 6.979-6.98.059-1.28.073-1.689.073-4.948 0-3.259-.014-3.667-.072-4.947-.196-4.354-2.617-6.78-6.979-6.98-1.281-.059-1.69-.073-4.949-.073zm0 5.838c-3.403 0-6.162 2.759-6.162 6.162s2.759 6.163 6.162 6.163 6.162-2.759 6.162-6.163c0-3.403-2.759-6.162-6.162-6.162zm0 10.162c-2.209 0-4-1.79-4-4 0-2.209 1.791-4 4-4s4 1.791 4 4c0 2.21-1.791 4-4 4zm6.406-11.845c-.796 0-1.441.645-1.441 1.44s.645 1.44 1.441 1.44c.795 0 1.439-.645 1.439-1.44s-.644-1.44-1.439-1.44z" />
             </svg>
-            <span className="text-gray-400 font-medium text-xs">Instagram</span>
-            <Clock className="w-3.5 h-3.5 text-amber-400" />
+            <span className="text-white font-medium text-xs">Instagram</span>
+            <CheckCircle className="w-3.5 h-3.5 text-green-400" />
           </div>
 
-          {/* TikTok - Coming Soon */}
-          <div className="flex items-center justify-center gap-2 h-10 px-4 rounded-xl bg-white/5 border border-white/10 opacity-50">
+          {/* TikTok - Active */}
+          <div className="flex items-center justify-center gap-2 h-10 px-4 rounded-xl bg-white/5 border border-white/10">
             <svg className="w-4 h-4" viewBox="0 0 24 24" fill="white">
               <path d="M19.59 6.69a4.83 4.83 0 0 1-3.77-4.25V2h-3.45v13.67a2.89 2.89 0 0 1-5.2 1.74 2.89 2.89 0 0 1 2.31-4.64 2.93 2.93 0 0 1 .88.13V9.4a6.84 6.84 0 0 0-1-.05A6.33 6.33 0 0 0 5 20.1a6.34 6.34 0 0 0 10.86-4.43v-7a8.16 8.16 0 0 0 4.77 1.52v-3.4a4.85 4.85 0 0 1-1-.1z" />
             </svg>
-            <span className="text-gray-400 font-medium text-xs">TikTok</span>
-            <Clock className="w-3.5 h-3.5 text-amber-400" />
+            <span className="text-white font-medium text-xs">TikTok</span>
+            <CheckCircle className="w-3.5 h-3.5 text-green-400" />
           </div>
         </motion.div>
 
@@ -177,8 +201,10 @@ function App() {
         >
           <UrlInput
             onAnalyze={handleAnalyze}
+            onSearch={handleSearch}
             isLoading={state === 'analyzing'}
             disabled={state === 'downloading'}
+            platformHosts={config?.platformHosts}
           />
         </motion.div>
 
@@ -205,6 +231,21 @@ function App() {
           )}
         </AnimatePresence>
 
+        {/* Search Results */}
+        <AnimatePresence>
+          {searchResults && !video && state === 'idle' && (
+            <motion.div
+              initial={{ opacity: 0, y: 30 }}
+              animate={{ opacity: 1, y: 0 }}
+              exit={{ opacity: 0, y: -30 }}
+              transition={{ duration: 0.4, ease: 'easeOut' }}
+              className="w-full max-w-xl mt-8"
+            >
+              <SearchResults results={searchResults} onSelect={(result) => handleAnalyze(result.url)} />
+            </motion.div>
+          )}
+        </AnimatePresence>
+
         {/* Video Info and Format Selection */}
         <AnimatePresence>
           {video && state !== 'error' && (
@@ -247,6 +288,7 @@ function App() {
                   disabled={!selectedFormat}
                   isDownloading={state === 'downloading'}
                   progress={downloadProgress}
+                  queue={queueEntry}
                 />
               </motion.div>
 
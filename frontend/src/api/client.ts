@@ -1,7 +1,10 @@
-import type { VideoInfo, ConfigResponse, AnalyzeRequest, ErrorResponse } from '../types';
+import type { VideoInfo, ConfigResponse, AnalyzeRequest, ErrorResponse, SearchResult, SearchResponse, QueueEntry, QueueResponse } from '../types';
 
 const API_BASE = '/api';
 
+// How often the queue position is checked while a download waits for a slot
+const QUEUE_POLL_INTERVAL = 2000;
+
 class ApiError extends Error {
   status: number;
   
@@ -36,6 +39,23 @@ export async function analyzeUrl(url: string): Promise<VideoInfo> {
   return handleResponse<VideoInfo>(response);
 }
 
+// Search videos by text; each result's url can be passed to analyzeUrl
+export async function searchVideos(query: string, platform = 'youtube', limit = 10): Promise<SearchResult[]> {
+  const params = new URLSearchParams({
+    q: query,
+    platform: platform,
+    limit: String(limit),
+  });
+  const response = await fetch(`${API_BASE}/search?${params.toString()}`);
+  const data = await handleResponse<SearchResponse>(response);
+  return data.results;
+}
+
+export async function getQueue(): Promise<QueueResponse> {
+  const response = await fetch(`${API_BASE}/queue`);
+  return handleResponse<QueueResponse>(response);
+}
+
 export function getDownloadUrl(url: string, formatId: string, formatType?: string): string {
   const params = new URLSearchParams({
     url: url,
@@ -55,19 +75,43 @@ export function getThumbnailUrl(originalUrl: string): string {
   return `${API_BASE}/thumbnail?${params.toString()}`;
 }
 
-// Download with progress tracking - returns a Promise that resolves when download completes
+// Download with progress tracking - returns a Promise that resolves when download completes.
+// The server holds the request while it waits for a download slot; meanwhile the
+// queue position is polled and reported through onQueued, with null once it starts.
 export async function downloadFile(
   url: string,
   formatId: string,
   formatType?: string,
-  onProgress?: (progress: number) => void
+  onProgress?: (progress: number) => void,
+  onQueued?: (entry: QueueEntry | null) => void
 ): Promise<void> {
   const downloadUrl = getDownloadUrl(url, formatId, formatType);
-  
-  const response = await fetch(downloadUrl);
+
+  let waiting = true;
+  const pollQueue = async () => {
+    try {
+      const queue = await getQueue();
+      if (waiting) {
+        onQueued?.(queue.waiting[0] ?? null);
+      }
+    } catch {
+      // The position is informational, the download goes on without it
+    }
+  };
+  const poll = onQueued ? window.setInterval(pollQueue, QUEUE_POLL_INTERVAL) : undefined;
+
+  let response: Response;
+  try {
+    response = await fetch(downloadUrl);
+  } finally {
+    waiting = false;
+    window.clearInterval(poll);
+    onQueued?.(null);
+  }
   
   if (!response.ok) {
-    throw new ApiError(response.status, 'Download failed');
+    const error: ErrorResponse = await response.json().catch(() => ({ error: 'Download failed' }));
+    throw new ApiError(response.status, error.error);
   }
 
   // Get filename from Content-Disposition header
@@ -1,14 +1,22 @@
 import { motion } from 'framer-motion';
 import { Download, Loader2 } from 'lucide-react';
+import type { QueueEntry } from '../types';
 
 interface DownloadButtonProps {
   onClick: () => void;
   disabled: boolean;
   isDownloading: boolean;
   progress?: number; // 0-100
+  queue?: QueueEntry | null; // set while waiting for a free download slot
 }
 
-export function DownloadButton({ onClick, disabled, isDownloading, progress = 0 }: DownloadButtonProps) {
+function queueLabel(queue: QueueEntry): string {
+  const place = queue.position === 0 ? 'Вы следующий' : `Перед вами ${queue.position}`;
+  const minutes = Math.ceil(queue.estimatedWaitSeconds / 60);
+  return `В очереди: ${place}, ~${minutes} мин`;
+}
+
+export function DownloadButton({ onClick, disabled, isDownloading, progress = 0, queue = null }: DownloadButtonProps) {
   return (
     <motion.button
       whileHover={{ scale: disabled ? 1 : 1.02 }}
@@ -56,7 +64,7 @@ export function DownloadButton({ onClick, disabled, isDownloading, progress = 0
           <>
             <Loader2 className="w-5 h-5 animate-spin flex-shrink-0" />
             <span>
-              {progress > 0 ? `Загрузка ${progress}%` : 'Подготовка...'}
+              {progress > 0 ? `Загрузка ${progress}%` : queue ? queueLabel(queue) : 'Подготовка...'}
             </span>
           </>
         ) : (
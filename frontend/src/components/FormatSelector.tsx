@@ -1,6 +1,6 @@
 import { useState, useMemo } from 'react';
 import { motion, AnimatePresence } from 'framer-motion';
-import { Music, Video, ChevronDown, Check } from 'lucide-react';
+import { Music, Video, Image, Archive, ChevronDown, Check } from 'lucide-react';
 import type { Format } from '../types';
 
 interface FormatSelectorProps {
@@ -12,19 +12,35 @@ interface FormatSelectorProps {
 type TabType = 'audio' | 'video';
 
 export function FormatSelector({ formats, selectedFormat, onSelect }: FormatSelectorProps) {
-  const [activeTab, setActiveTab] = useState<TabType>('video');
+  // Tracks and albums have nothing but audio
+  const audioOnly = !formats.some((f) => f.type === 'video' || f.type === 'video_only' || f.type === 'image');
+  const [activeTab, setActiveTab] = useState<TabType>(audioOnly ? 'audio' : 'video');
   const [isOpen, setIsOpen] = useState(false);
 
   const groupedFormats = useMemo(() => {
     const audio = formats.filter((f) => f.type === 'audio');
     const video = formats.filter((f) => f.type === 'video');
     const videoOnly = formats.filter((f) => f.type === 'video_only');
-    return { audio, video, videoOnly };
+    // Photos and the ZIP of a carousel post or an album
+    const files = formats.filter((f) => f.type === 'image' || f.type === 'archive');
+    return { audio, video, videoOnly, files };
   }, [formats]);
 
-  const currentFormats = activeTab === 'audio' 
-    ? groupedFormats.audio 
-    : [...groupedFormats.video, ...groupedFormats.videoOnly];
+  const currentFormats = activeTab === 'audio'
+    ? [...groupedFormats.audio, ...(audioOnly ? groupedFormats.files : [])]
+    : [...groupedFormats.video, ...groupedFormats.videoOnly, ...(audioOnly ? [] : groupedFormats.files)];
+
+  const FormatIcon = ({ format, className }: { format: Format; className: string }) => {
+    if (format.type === 'audio') return <Music className={className} />;
+    if (format.type === 'image') return <Image className={className} />;
+    if (format.type === 'archive') return <Archive className={className} />;
+    return <Video className={className} />;
+  };
+
+  // The sound is extracted from a video format on some platforms, so an ID
+  // can appear under both tabs
+  const isSelected = (format: Format) =>
+    selectedFormat?.id === format.id && selectedFormat?.type === format.type;
 
   const handleSelect = (format: Format) => {
     onSelect(format);
@@ -80,11 +96,7 @@ export function FormatSelector({ formats, selectedFormat, onSelect }: FormatSele
           <div className="flex items-center gap-3 min-w-0 flex-1">
             {selectedFormat && (
               <div className="w-9 h-9 rounded-lg bg-cyan-500/20 flex items-center justify-center flex-shrink-0">
-                {selectedFormat.type === 'audio' ? (
-                  <Music className="w-4 h-4 text-cyan-400" />
-                ) : (
-                  <Video className="w-4 h-4 text-cyan-400" />
-                )}
+                <FormatIcon format={selectedFormat} className="w-4 h-4 text-cyan-400" />
               </div>
             )}
             <div className="min-w-0 flex-1">
@@ -130,30 +142,28 @@ export function FormatSelector({ formats, selectedFormat, onSelect }: FormatSele
                   <div className="p-2">
                     {currentFormats.map((format, index) => (
                       <motion.button
-                        key={format.id}
+                        key={`${format.type}-${format.id}`}
                         initial={{ opacity: 0, x: -10 }}
                         animate={{ opacity: 1, x: 0 }}
                         transition={{ delay: index * 0.02 }}
                         onClick={() => handleSelect(format)}
                         className={`w-full p-3 flex items-center gap-3 text-left rounded-lg transition-all duration-200 mb-1 last:mb-0 ${
-                          selectedFormat?.id === format.id 
+                          isSelected(format) 
                             ? 'bg-cyan-500/20' 
                             : 'hover:bg-white/5'
                         }`}
                       >
                         <div className={`w-8 h-8 rounded-lg flex items-center justify-center flex-shrink-0 ${
-                          selectedFormat?.id === format.id ? 'bg-cyan-500' : 'bg-white/10'
+                          isSelected(format) ? 'bg-cyan-500' : 'bg-white/10'
                         }`}>
-                          {selectedFormat?.id === format.id ? (
+                          {isSelected(format) ? (
                             <Check className="w-4 h-4 text-white" />
-                          ) : format.type === 'audio' ? (
-                            <Music className="w-4 h-4 text-gray-400" />
                           ) : (
-                            <Video className="w-4 h-4 text-gray-400" />
+                            <FormatIcon format={format} className="w-4 h-4 text-gray-400" />
                           )}
                         </div>
                         <div className="flex-1 min-w-0">
-                          <p className={`text-sm font-medium truncate ${selectedFormat?.id === format.id ? 'text-cyan-400' : 'text-white'}`}>
+                          <p className={`text-sm font-medium truncate ${isSelected(format) ? 'text-cyan-400' : 'text-white'}`}>
                             {format.quality}
                           </p>
                           <p className="text-xs text-gray-500 truncate">{format.ext.toUpperCase()}</p>
@@ -1,12 +1,34 @@
+import type { ReactNode } from 'react';
 import { motion } from 'framer-motion';
+import type { Platform } from '../types';
 
 interface PlatformIconProps {
-  platform: 'youtube' | 'instagram' | 'tiktok' | null;
+  platform: Platform | null;
   size?: number;
 }
 
+// LetterBadge stands in for platforms without a drawn logo
+function LetterBadge({ letters, color }: { letters: string; color: string }) {
+  return (
+    <svg viewBox="0 0 24 24" className="w-full h-full">
+      <rect width="24" height="24" rx="6" fill={color} />
+      <text
+        x="12"
+        y="16.5"
+        textAnchor="middle"
+        fontSize={letters.length > 1 ? 10 : 13}
+        fontWeight="700"
+        fontFamily="sans-serif"
+        fill="#fff"
+      >
+        {letters}
+      </text>
+    </svg>
+  );
+}
+
 export function PlatformIcon({ platform, size = 24 }: PlatformIconProps) {
-  if (!platform) {
+  if (!platform || platform === 'direct') {
     return (
       <div
         className="rounded-lg bg-gray-700/50 flex items-center justify-center flex-shrink-0"
@@ -17,7 +39,7 @@ export function PlatformIcon({ platform, size = 24 }: PlatformIconProps) {
     );
   }
 
-  const icons = {
+  const icons: Record<Exclude<Platform, 'direct'>, ReactNode> = {
     youtube: (
       <svg viewBox="0 0 24 24" fill="currentColor" className="text-red-500 w-full h-full">
         <path d="M23.498 6.186a3.016 3.016 0 0 0-2.122-2.136C19.505 3.545 12 3.545 12 3.545s-7.505 0-9.377.505A3.017 3.017 0 0 0 .502 6.186C0 8.07 0 12 0 12s0 3.93.502 5.814a3.016 3.016 0 0 0 2.122 2.136c1.871.505 9.376.505 9.376.505s7.505 0 9.377-.505a3.015 3.015 0 0 0 2.122-2.136C24 15.93 24 12 24 12s0-3.93-.502-5.814zM9.545 15.568V8.432L15.818 12l-6.273 3.568z" />
@@ -42,6 +64,20 @@ export function PlatformIcon({ platform, size = 24 }: PlatformIconProps) {
         <path d="M19.59 6.69a4.83 4.83 0 0 1-3.77-4.25V2h-3.45v13.67a2.89 2.89 0 0 1-5.2 1.74 2.89 2.89 0 0 1 2.31-4.64 2.93 2.93 0 0 1 .88.13V9.4a6.84 6.84 0 0 0-1-.05A6.33 6.33 0 0 0 5 20.1a6.34 6.34 0 0 0 10.86-4.43v-7a8.16 8.16 0 0 0 4.77 1.52v-3.4a4.85 4.85 0 0 1-1-.1z" />
       </svg>
     ),
+    x: (
+      <svg viewBox="0 0 24 24" fill="currentColor" className="text-white w-full h-full">
+        <path d="M18.901 1.153h3.68l-8.04 9.19L24 22.846h-7.406l-5.8-7.584-6.638 7.584H.474l8.6-9.83L0 1.154h7.594l5.243 6.932ZM17.61 20.644h2.039L6.486 3.24H4.298Z" />
+      </svg>
+    ),
+    twitch: (
+      <svg viewBox="0 0 24 24" fill="currentColor" className="text-purple-500 w-full h-full">
+        <path d="M11.571 4.714h1.715v5.143H11.57zm4.715 0H18v5.143h-1.714zM6 0L1.714 4.286v15.428h5.143V24l4.286-4.286h3.428L22.286 12V0zm14.571 11.143l-3.428 3.428h-3.429l-3 3v-3H6.857V1.714h13.714Z" />
+      </svg>
+    ),
+    youtube_music: <LetterBadge letters="♪" color="#FF0000" />,
+    vimeo: <LetterBadge letters="V" color="#1AB7EA" />,
+    reddit: <LetterBadge letters="R" color="#FF4500" />,
+    soundcloud: <LetterBadge letters="SC" color="#FF5500" />,
   };
 
   return (
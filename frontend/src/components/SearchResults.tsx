@@ -0,0 +1,55 @@
+import { motion } from 'framer-motion';
+import { getThumbnailUrl } from '../api/client';
+import { formatDuration } from './VideoPreview';
+import type { SearchResult } from '../types';
+
+interface SearchResultsProps {
+  results: SearchResult[];
+  onSelect: (result: SearchResult) => void;
+}
+
+export function SearchResults({ results, onSelect }: SearchResultsProps) {
+  if (results.length === 0) {
+    return (
+      <div className="glass-card rounded-2xl p-6 text-center text-sm text-gray-400">
+        Ничего не найдено
+      </div>
+    );
+  }
+
+  return (
+    <div className="glass-card rounded-2xl p-2 space-y-1">
+      {results.map((result, index) => (
+        <motion.button
+          key={`${result.platform}-${result.id}`}
+          initial={{ opacity: 0, y: 10 }}
+          animate={{ opacity: 1, y: 0 }}
+          transition={{ delay: index * 0.03 }}
+          whileHover={{ scale: 1.01 }}
+          onClick={() => onSelect(result)}
+          className="w-full flex items-center gap-4 p-2 rounded-xl text-left hover:bg-white/5 transition-colors"
+        >
+          <div className="relative w-28 aspect-video rounded-lg overflow-hidden bg-gray-900 flex-shrink-0">
+            {result.thumbnail && (
+              <img
+                src={getThumbnailUrl(result.thumbnail)}
+                alt=""
+                loading="lazy"
+                className="w-full h-full object-cover"
+              />
+            )}
+            {result.duration ? (
+              <span className="absolute bottom-1 right-1 px-1.5 py-0.5 rounded bg-black/70 text-[10px] text-white font-semibold">
+                {formatDuration(result.duration)}
+              </span>
+            ) : null}
+          </div>
+          <div className="min-w-0 flex-1">
+            <p className="text-sm text-white font-medium line-clamp-2 break-words">{result.title}</p>
+            {result.channel && <p className="mt-1 text-xs text-gray-400 truncate">{result.channel}</p>}
+          </div>
+        </motion.button>
+      ))}
+    </div>
+  );
+}
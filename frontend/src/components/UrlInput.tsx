@@ -2,43 +2,65 @@ import { useState, useEffect } from 'react';
 import { motion, AnimatePresence } from 'framer-motion';
 import { Link, Search, Loader2, X } from 'lucide-react';
 import { PlatformIcon } from './PlatformIcon';
+import type { Platform } from '../types';
 
 interface UrlInputProps {
   onAnalyze: (url: string) => void;
+  // Called with text that isn't a link
+  onSearch?: (query: string) => void;
   isLoading: boolean;
   disabled?: boolean;
+  // Domains of each platform, from /api/config
+  platformHosts?: Partial<Record<Platform, string[]>>;
 }
 
-type Platform = 'youtube' | 'instagram' | 'tiktok' | null;
+const NO_HOSTS: Partial<Record<Platform, string[]>> = {};
 
-function detectPlatform(url: string): Platform {
-  if (!url) return null;
-  const lower = url.toLowerCase();
-  if (lower.includes('youtube.com') || lower.includes('youtu.be') || lower.includes('music.youtube.com')) {
-    return 'youtube';
+// detectPlatform only picks the icon; which links are supported is up to the server.
+// Like the server, it prefers the most specific domain, so music.youtube.com
+// is YouTube Music rather than YouTube.
+function detectPlatform(url: string, platformHosts: Partial<Record<Platform, string[]>>): Platform | null {
+  let host: string;
+  try {
+    host = new URL(url.trim()).hostname.toLowerCase();
+  } catch {
+    return null;
   }
-  if (lower.includes('instagram.com') || lower.includes('instagr.am')) {
-    return 'instagram';
-  }
-  if (lower.includes('tiktok.com') || lower.includes('vm.tiktok.com')) {
-    return 'tiktok';
+  let match: Platform | null = null;
+  let matched = '';
+  for (const [platform, hosts] of Object.entries(platformHosts) as [Platform, string[]][]) {
+    for (const h of hosts) {
+      if ((host === h || host.endsWith('.' + h)) && h.length > matched.length) {
+        match = platform;
+        matched = h;
+      }
+    }
   }
-  return null;
+  return match;
+}
+
+function isHttpUrl(url: string): boolean {
+  return /^https?:\/\/\S+$/i.test(url.trim());
 }
 
-export function UrlInput({ onAnalyze, isLoading, disabled }: UrlInputProps) {
+export function UrlInput({ onAnalyze, onSearch, isLoading, disabled, platformHosts = NO_HOSTS }: UrlInputProps) {
   const [url, setUrl] = useState('');
-  const [platform, setPlatform] = useState<Platform>(null);
+  const [platform, setPlatform] = useState<Platform | null>(null);
   const [isFocused, setIsFocused] = useState(false);
 
   useEffect(() => {
-    setPlatform(detectPlatform(url));
-  }, [url]);
+    setPlatform(detectPlatform(url, platformHosts));
+  }, [url, platformHosts]);
+
+  const canSubmit = isHttpUrl(url) || (!!onSearch && url.trim().length > 0);
 
   const handleSubmit = (e: React.FormEvent) => {
     e.preventDefault();
-    if (url.trim() && !isLoading && !disabled && platform === 'youtube') {
+    if (!canSubmit || isLoading || disabled) return;
+    if (isHttpUrl(url)) {
       onAnalyze(url.trim());
+    } else {
+      onSearch?.(url.trim());
     }
   };
 
@@ -47,9 +69,6 @@ export function UrlInput({ onAnalyze, isLoading, disabled }: UrlInputProps) {
     setPlatform(null);
   };
 
-  const isValidUrl = url.trim().length > 0 && platform === 'youtube';
-  const isComingSoon = platform === 'instagram' || platform === 'tiktok';
-
   return (
     <form onSubmit={handleSubmit} className="w-full">
       <motion.div 
@@ -106,7 +125,7 @@ export function UrlInput({ onAnalyze, isLoading, disabled }: UrlInputProps) {
               onChange={(e) => setUrl(e.target.value)}
               onFocus={() => setIsFocused(true)}
               onBlur={() => setIsFocused(false)}
-              placeholder="Вставьте ссылку на видео..."
+              placeholder={onSearch ? 'Вставьте ссылку или введите название...' : 'Вставьте ссылку на видео...'}
               disabled={disabled || isLoading}
               className="flex-1 min-w-0 bg-transparent border-none outline-none text-white text-base placeholder-gray-500 py-3 h-12"
             />
@@ -129,12 +148,12 @@ export function UrlInput({ onAnalyze, isLoading, disabled }: UrlInputProps) {
 
             {/* Submit button */}
             <motion.button
-              whileHover={{ scale: isValidUrl && !isLoading ? 1.02 : 1 }}
-              whileTap={{ scale: isValidUrl && !isLoading ? 0.98 : 1 }}
+              whileHover={{ scale: canSubmit && !isLoading ? 1.02 : 1 }}
+              whileTap={{ scale: canSubmit && !isLoading ? 0.98 : 1 }}
               type="submit"
-              disabled={!isValidUrl || isLoading || disabled}
+              disabled={!canSubmit || isLoading || disabled}
               className={`h-12 px-6 rounded-xl font-semibold text-base flex items-center justify-center gap-2 transition-all duration-300 flex-shrink-0 whitespace-nowrap ${
-                isValidUrl && !isLoading
+                canSubmit && !isLoading
                   ? 'bg-gradient-to-r from-cyan-500 to-blue-500 text-white shadow-lg shadow-cyan-500/25 hover:shadow-cyan-500/40'
                   : 'bg-gray-700/50 text-gray-500 cursor-not-allowed'
               }`}
@@ -154,22 +173,6 @@ export function UrlInput({ onAnalyze, isLoading, disabled }: UrlInputProps) {
           </div>
         </div>
       </motion.div>
-
-      {/* Coming soon message */}
-      <AnimatePresence>
-        {isComingSoon && (
-          <motion.p
-            initial={{ opacity: 0, y: -10 }}
-            animate={{ opacity: 1, y: 0 }}
-            exit={{ opacity: 0, y: -10 }}
-            className="mt-4 text-amber-400 text-xs flex items-center justify-center gap-2"
-          >
-            <span className="px-3 py-1.5 rounded-lg bg-amber-500/20">
-              {platform === 'instagram' ? 'Instagram' : 'TikTok'} скоро будет поддерживаться
-            </span>
-          </motion.p>
-        )}
-      </AnimatePresence>
     </form>
   );
 }
@@ -9,7 +9,7 @@ interface VideoPreviewProps {
   video: VideoInfo;
 }
 
-function formatDuration(seconds: number): string {
+export function formatDuration(seconds: number): string {
   const hours = Math.floor(seconds / 3600);
   const minutes = Math.floor((seconds % 3600) / 60);
   const secs = seconds % 60;
@@ -92,6 +92,15 @@ export function VideoPreview({ video }: VideoPreviewProps) {
         <h3 className="text-base font-medium text-white leading-relaxed break-words">
           {video.title}
         </h3>
+        {video.author && (
+          <p className="mt-1 text-sm text-gray-400 truncate">{video.author}</p>
+        )}
+        {video.music?.album && video.music.album !== video.title && (
+          <p className="mt-0.5 text-xs text-gray-500 truncate">
+            {video.music.album}
+            {video.music.year ? ` • ${video.music.year}` : ''}
+          </p>
+        )}
       </div>
     </motion.div>
   );
@@ -6,3 +6,4 @@ export { VideoPreview } from './VideoPreview';
 export { DownloadButton } from './DownloadButton';
 
 
+export { SearchResults } from './SearchResults';
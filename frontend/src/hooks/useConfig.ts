@@ -0,0 +1,22 @@
+import { useState, useEffect } from 'react';
+import { getConfig } from '../api/client';
+import type { ConfigResponse } from '../types';
+
+// useConfig loads the server configuration once; null until it arrives or when it fails
+export function useConfig() {
+  const [config, setConfig] = useState<ConfigResponse | null>(null);
+
+  useEffect(() => {
+    let cancelled = false;
+    getConfig()
+      .then((loaded) => {
+        if (!cancelled) setConfig(loaded);
+      })
+      .catch(() => {});
+    return () => {
+      cancelled = true;
+    };
+  }, []);
+
+  return config;
+}
@@ -1,23 +1,104 @@
+export type Platform =
+  | 'youtube'
+  | 'youtube_music'
+  | 'instagram'
+  | 'tiktok'
+  | 'vimeo'
+  | 'x'
+  | 'reddit'
+  | 'soundcloud'
+  | 'twitch'
+  | 'direct';
+
 export interface Format {
   id: string;
-  type: 'audio' | 'video' | 'video_only';
+  type: 'audio' | 'video' | 'video_only' | 'image' | 'archive';
   quality: string;
   ext: string;
   size?: number;
 }
 
+export interface MediaItem {
+  index: number;
+  type: 'video' | 'image';
+  thumbnail: string;
+  width?: number;
+  height?: number;
+  duration?: number;
+  formatId: string;
+}
+
+export interface Chapter {
+  index: number;
+  title: string;
+  start: number;
+  end: number;
+  formatId: string;
+}
+
+export interface MusicTrack {
+  index: number;
+  title: string;
+  artist?: string;
+  duration?: number;
+  formatId: string;
+}
+
+export interface MusicInfo {
+  track?: string;
+  artist?: string;
+  album?: string;
+  year?: number;
+  trackNumber?: number;
+  tracks?: MusicTrack[];
+}
+
 export interface VideoInfo {
-  platform: 'youtube' | 'instagram' | 'tiktok';
+  url: string;
+  platform: Platform;
   title: string;
   duration: number;
   thumbnail: string;
+  author?: string;
+  description?: string;
   formats: Format[];
+  items?: MediaItem[];
+  chapters?: Chapter[];
+  music?: MusicInfo;
+}
+
+export interface SearchResult {
+  id: string;
+  platform: Platform;
+  title: string;
+  channel?: string;
+  duration?: number;
+  thumbnail?: string;
+  url: string;
+}
+
+export interface SearchResponse {
+  results: SearchResult[];
+}
+
+export interface QueueEntry {
+  position: number;
+  estimatedWaitSeconds: number;
+  waitingSeconds: number;
+}
+
+export interface QueueResponse {
+  waiting: QueueEntry[];
+  queueLength: number;
+  estimatedWaitSeconds: number;
 }
 
 export interface ConfigResponse {
   authRequired: boolean;
   maxConcurrent: number;
   platforms: string[];
+  // Domains of each enabled platform, without direct links
+  platformHosts: Partial<Record<Platform, string[]>>;
 }
 
 export interface AnalyzeRequest {